@@ -0,0 +1,110 @@
+// Package deadletter keeps games that were not imported in a JSON lines file,
+// together with the reason, so they can be inspected or retried later
+package deadletter
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"importGames/pgnparse"
+)
+
+// Entry is one quarantined game
+type Entry struct {
+	File   string    `json:"file"`
+	Game   int       `json:"game"` // 1-based index of the game in the file
+	Reason string    `json:"reason"`
+	PGN    string    `json:"pgn"`
+	Time   time.Time `json:"time"`
+
+	ParserVersion string `json:"parser_version"`
+}
+
+// Queue appends entries to the dead-letter file, safe for concurrent use
+type Queue struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Open opens the dead-letter file for appending
+func Open(path string) (*Queue, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &Queue{file: file}, nil
+}
+
+// Add appends an entry
+func (q *Queue) Add(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if e.ParserVersion == "" {
+		e.ParserVersion = pgnparse.Version
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, err = q.file.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes the dead-letter file
+func (q *Queue) Close() error {
+	return q.file.Close()
+}
+
+// Load reads all entries, a missing file has none
+func Load(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		var entry Entry
+		if err := decoder.Decode(&entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// Rewrite replaces the file content with entries
+func Rewrite(path string, entries []Entry) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,42 @@
+// Package eco is the list of ECO codes (A00 to E99) and their usual opening
+// names, for OTB PGNs that only have an ECO tag
+package eco
+
+import (
+	_ "embed"
+	"strings"
+)
+
+//go:embed codes.tsv
+var data string
+
+// Code is one entry of the list
+type Code struct {
+	Code string
+	Name string
+}
+
+var (
+	codes []Code
+	names = make(map[string]string)
+)
+
+func init() {
+	for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+		code, name, _ := strings.Cut(line, "\t")
+		codes = append(codes, Code{code, name})
+		names[code] = name
+	}
+}
+
+// Codes returns every code in order
+func Codes() []Code {
+	return codes
+}
+
+// Name returns the opening name of a code, false for anything that isn't one
+// of A00 to E99 (empty, "?", "A00a", ...)
+func Name(code string) (string, bool) {
+	name, ok := names[code]
+	return name, ok
+}
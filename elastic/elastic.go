@@ -0,0 +1,157 @@
+// Package elastic bulk-indexes documents with the REST API Elasticsearch
+// and OpenSearch share. The official clients each refuse the other server,
+// plain HTTP works with both.
+package elastic
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrConflict is the error of a document created with an id already indexed
+var ErrConflict = errors.New("document already exists")
+
+// bulkTries is how many times a bulk request is sent while the cluster
+// rejects it as too busy (429)
+const bulkTries = 3
+
+// Client indexes into one index
+type Client struct {
+	url    string
+	index  string
+	header http.Header
+	http   *http.Client
+}
+
+// Doc is a document to index, Source being its JSON
+type Doc struct {
+	ID     string
+	Source []byte
+}
+
+// New returns a client of the index. The API key wins over the user and
+// password, both are optional.
+func New(url string, index string, username string, password string, apiKey string) *Client {
+	header := http.Header{}
+	switch {
+	case apiKey != "":
+		header.Set("Authorization", "ApiKey "+apiKey)
+	case username != "":
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(username+":"+password)))
+	}
+	return &Client{url: strings.TrimRight(url, "/"), index: index, header: header, http: &http.Client{Timeout: 2 * time.Minute}}
+}
+
+// EnsureIndex creates the index with the mappings, unless it exists. The
+// mappings of an existing index are left as they are.
+func (c *Client) EnsureIndex(ctx context.Context, mappings any) error {
+	status, _, err := c.do(ctx, http.MethodHead, "/"+c.index, "", nil)
+	if err != nil || status == http.StatusOK {
+		return err
+	}
+	body, err := json.Marshal(map[string]any{"mappings": mappings})
+	if err != nil {
+		return err
+	}
+	status, response, err := c.do(ctx, http.MethodPut, "/"+c.index, "application/json", body)
+	switch {
+	case err != nil:
+		return err
+	case status >= 300 && !bytes.Contains(response, []byte("resource_already_exists_exception")):
+		return fmt.Errorf("creating index %s: %d %s", c.index, status, response)
+	}
+	return nil
+}
+
+// Bulk indexes the documents in one request: replace overwrites documents
+// with the same id, else they fail with ErrConflict. It returns the error
+// of every document, nil for those indexed.
+func (c *Client) Bulk(ctx context.Context, docs []Doc, replace bool) ([]error, error) {
+	action := "create"
+	if replace {
+		action = "index"
+	}
+	var body bytes.Buffer
+	for _, d := range docs {
+		meta, _ := json.Marshal(map[string]any{action: map[string]string{"_index": c.index, "_id": d.ID}})
+		body.Write(meta)
+		body.WriteByte('\n')
+		body.Write(d.Source)
+		body.WriteByte('\n')
+	}
+
+	var status int
+	var response []byte
+	var err error
+	for try := 1; ; try++ {
+		status, response, err = c.do(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", body.Bytes())
+		if err != nil || status != http.StatusTooManyRequests || try == bulkTries {
+			break
+		}
+		time.Sleep(time.Duration(try) * time.Second)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status >= 300 {
+		return nil, fmt.Errorf("bulk request: %d %s", status, response)
+	}
+
+	var result struct {
+		Items []map[string]struct {
+			Status int `json:"status"`
+			Error  *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(response, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Items) != len(docs) {
+		return nil, fmt.Errorf("bulk response has %d items for %d documents", len(result.Items), len(docs))
+	}
+	errs := make([]error, len(docs))
+	for i, item := range result.Items {
+		for _, outcome := range item {
+			switch {
+			case outcome.Status == http.StatusConflict:
+				errs[i] = ErrConflict
+			case outcome.Error != nil:
+				errs[i] = fmt.Errorf("%s: %s", outcome.Error.Type, outcome.Error.Reason)
+			case outcome.Status >= 300:
+				errs[i] = fmt.Errorf("status %d", outcome.Status)
+			}
+		}
+	}
+	return errs, nil
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, contentType string, body []byte) (int, []byte, error) {
+	request, err := http.NewRequestWithContext(ctx, method, c.url+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	for key, values := range c.header {
+		request.Header[key] = values
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	response, err := c.http.Do(request)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	return response.StatusCode, data, err
+}
@@ -0,0 +1,87 @@
+// Package features turns a game into a fixed-length numeric vector for ML pipelines
+package features
+
+import (
+	"math"
+	"strings"
+
+	"importGames/pgnparse"
+)
+
+// EarlyPlies is how many plies count for the early evaluation swing
+const EarlyPlies = 20
+
+// maxEval clamps evaluations (in pawns) so mates don't dominate the swing
+const maxEval = 10.0
+
+// Names describes the vector, one name per position
+var Names = []string{
+	"white_elo", "black_elo", "elo_diff",
+	"speed_ultrabullet", "speed_bullet", "speed_blitz", "speed_rapid", "speed_classical", "speed_correspondence",
+	"eco_a", "eco_b", "eco_c", "eco_d", "eco_e",
+	"has_evals", "early_eval_swing",
+}
+
+var speeds = []string{"ultraBullet", "bullet", "blitz", "rapid", "classical", "correspondence"}
+
+// Game is what the vector is computed from
+type Game struct {
+	WhiteElo    int
+	BlackElo    int
+	TimeControl string
+	Eco         string
+	Moves       []pgnparse.Move
+}
+
+// Vector returns len(Names) values for the game
+func Vector(g Game) []float64 {
+	v := make([]float64, 0, len(Names))
+
+	v = append(v, float64(g.WhiteElo), float64(g.BlackElo), float64(g.WhiteElo-g.BlackElo))
+
+	speed := pgnparse.Speed(g.TimeControl)
+	for _, s := range speeds {
+		v = append(v, oneHot(speed == s))
+	}
+
+	family := ""
+	if g.Eco != "" {
+		family = strings.ToUpper(g.Eco[:1])
+	}
+	for _, letter := range []string{"A", "B", "C", "D", "E"} {
+		v = append(v, oneHot(family == letter))
+	}
+
+	swing, ok := earlyEvalSwing(g.Moves)
+	v = append(v, oneHot(ok), swing)
+
+	return v
+}
+
+// earlyEvalSwing is the spread between the best and worst evaluation in the opening
+func earlyEvalSwing(moves []pgnparse.Move) (float64, bool) {
+	low, high := math.Inf(1), math.Inf(-1)
+	for i, move := range moves {
+		if i == EarlyPlies {
+			break
+		}
+		eval, ok := pgnparse.Eval(move.Comment)
+		if !ok {
+			continue
+		}
+		eval = math.Max(-maxEval, math.Min(maxEval, eval))
+		low, high = math.Min(low, eval), math.Max(high, eval)
+	}
+
+	if math.IsInf(low, 1) {
+		return 0, false
+	}
+	return high - low, true
+}
+
+func oneHot(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
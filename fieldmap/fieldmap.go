@@ -0,0 +1,123 @@
+// Package fieldmap renames the top level fields of the stored games, so the
+// importer can write documents matching an existing schema
+package fieldmap
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Map is field name -> stored name. A nil Map renames nothing.
+type Map map[string]string
+
+// Parse reads "whiteElo=white_elo,moves_count=movesCount", or a JSON object
+// like {"whiteElo": "white_elo"} from a file when spec ends with .json
+func Parse(spec string) (Map, error) {
+	m := Map{}
+	if strings.HasSuffix(spec, ".json") {
+		data, err := os.ReadFile(spec)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("%s: %s", spec, err)
+		}
+	} else {
+		for _, pair := range strings.Split(spec, ",") {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+			from, to, found := strings.Cut(pair, "=")
+			if !found {
+				return nil, fmt.Errorf("field mapping %q is not field=name", pair)
+			}
+			m[strings.TrimSpace(from)] = strings.TrimSpace(to)
+		}
+	}
+
+	// Two fields can't share a name, and _id is the document's identity
+	targets := make(map[string]string)
+	for from, to := range m {
+		switch {
+		case from == "" || to == "":
+			return nil, fmt.Errorf("empty field name in mapping %s=%s", from, to)
+		case from == "_id" || to == "_id":
+			return nil, fmt.Errorf("_id can't be mapped")
+		case strings.ContainsAny(to, ".$"):
+			return nil, fmt.Errorf("field name %q can't contain '.' or '$'", to)
+		case targets[to] != "":
+			return nil, fmt.Errorf("%s and %s both map to %s", targets[to], from, to)
+		}
+		targets[to] = from
+	}
+	if len(m) == 0 {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// Name returns the stored name of a field
+func (m Map) Name(field string) string {
+	if to, ok := m[field]; ok {
+		return to
+	}
+	return field
+}
+
+// Document returns v with its fields renamed, v itself when nothing is.
+// Renaming a field to the name of one that's kept is an error.
+func (m Map) Document(v any) (any, error) {
+	if len(m) == 0 {
+		return v, nil
+	}
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	doc = m.Keys(doc)
+	seen := make(map[string]bool, len(doc))
+	for _, e := range doc {
+		if seen[e.Key] {
+			return nil, fmt.Errorf("two fields are stored as %s", e.Key)
+		}
+		seen[e.Key] = true
+	}
+	return doc, nil
+}
+
+// Keys renames the keys of an index, sort or document
+func (m Map) Keys(keys bson.D) bson.D {
+	renamed := make(bson.D, len(keys))
+	for i, e := range keys {
+		renamed[i] = bson.E{Key: m.Name(e.Key), Value: e.Value}
+	}
+	return renamed
+}
+
+// Filter renames the fields of a filter, including inside $or and $and
+func (m Map) Filter(filter bson.M) bson.M {
+	renamed := make(bson.M, len(filter))
+	for key, value := range filter {
+		if conditions, ok := value.(bson.A); ok && (key == "$or" || key == "$and") {
+			mapped := make(bson.A, len(conditions))
+			for i, c := range conditions {
+				if c, ok := c.(bson.M); ok {
+					mapped[i] = m.Filter(c)
+				} else {
+					mapped[i] = c
+				}
+			}
+			value = mapped
+		}
+		renamed[m.Name(key)] = value
+	}
+	return renamed
+}
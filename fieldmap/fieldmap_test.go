@@ -0,0 +1,122 @@
+package fieldmap
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Map
+		err  bool
+	}{
+		{"", nil, false},
+		{"whiteElo=white_elo", Map{"whiteElo": "white_elo"}, false},
+		{" whiteElo = white_elo , blackElo=black_elo ,", Map{"whiteElo": "white_elo", "blackElo": "black_elo"}, false},
+		{"whiteElo", nil, true},
+		{"whiteElo=", nil, true},
+		{"=white_elo", nil, true},
+		{"_id=id", nil, true},
+		{"gameId=_id", nil, true},
+		{"whiteElo=elo.white", nil, true},
+		{"whiteElo=$elo", nil, true},
+		{"whiteElo=elo,blackElo=elo", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			m, err := Parse(tt.spec)
+			if (err != nil) != tt.err {
+				t.Fatalf("Parse error = %v, want error: %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(m, tt.want) {
+				t.Errorf("Parse = %v, want %v", m, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	folder := t.TempDir()
+	valid := filepath.Join(folder, "fields.json")
+	broken := filepath.Join(folder, "broken.json")
+	if err := os.WriteFile(valid, []byte(`{"whiteElo": "white_elo"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(broken, []byte(`{"whiteElo": `), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := Parse(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Map{"whiteElo": "white_elo"}); !reflect.DeepEqual(m, want) {
+		t.Errorf("Parse = %v, want %v", m, want)
+	}
+	if _, err := Parse(broken); err == nil {
+		t.Error("Parse of a malformed JSON file succeeded")
+	}
+	if _, err := Parse(filepath.Join(folder, "missing.json")); err == nil {
+		t.Error("Parse of a missing file succeeded")
+	}
+}
+
+func TestKeysAndFilter(t *testing.T) {
+	m := Map{"gameId": "game_id", "source": "origin"}
+
+	keys := m.Keys(bson.D{{Key: "source", Value: 1}, {Key: "gameId", Value: 1}, {Key: "date", Value: -1}})
+	wantKeys := bson.D{{Key: "origin", Value: 1}, {Key: "game_id", Value: 1}, {Key: "date", Value: -1}}
+	if !reflect.DeepEqual(keys, wantKeys) {
+		t.Errorf("Keys = %v, want %v", keys, wantKeys)
+	}
+
+	filter := m.Filter(bson.M{
+		"gameId": bson.M{"$exists": true},
+		"$or":    bson.A{bson.M{"source": "lichess"}, bson.M{"date": "2024.01.01"}},
+	})
+	wantFilter := bson.M{
+		"game_id": bson.M{"$exists": true},
+		"$or":     bson.A{bson.M{"origin": "lichess"}, bson.M{"date": "2024.01.01"}},
+	}
+	if !reflect.DeepEqual(filter, wantFilter) {
+		t.Errorf("Filter = %v, want %v", filter, wantFilter)
+	}
+}
+
+func TestDocument(t *testing.T) {
+	type game struct {
+		White    string `bson:"white"`
+		WhiteElo int    `bson:"whiteElo"`
+	}
+	g := game{"alice", 1500}
+
+	tests := []struct {
+		name string
+		m    Map
+		want any
+		err  bool
+	}{
+		{"no mapping", nil, g, false},
+		{"renamed", Map{"whiteElo": "white_elo"}, bson.D{{Key: "white", Value: "alice"}, {Key: "white_elo", Value: int32(1500)}}, false},
+		{"unknown field", Map{"blackElo": "black_elo"}, bson.D{{Key: "white", Value: "alice"}, {Key: "whiteElo", Value: int32(1500)}}, false},
+		{"name of a kept field", Map{"whiteElo": "white"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := tt.m.Document(g)
+			if (err != nil) != tt.err {
+				t.Fatalf("Document error = %v, want error: %v", err, tt.err)
+			}
+			if !tt.err && !reflect.DeepEqual(doc, tt.want) {
+				t.Errorf("Document = %v, want %v", doc, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+// Package gamecheck holds the checks both importers run on a game before
+// storing it. The problems found go to the import report.
+package gamecheck
+
+import (
+	"fmt"
+
+	"importGames/deadletter"
+	"importGames/pgnparse"
+	"importGames/report"
+)
+
+// Checks are set from the flags, with the report once it is open
+type Checks struct {
+	ResultMismatch string // flag (report and import), skip or ignore
+	EloCheck       string // skip (report, don't import), flag (report and import) or off
+	EloLimits      pgnparse.EloLimits
+	Report         *report.Report
+	Quarantine     *deadletter.Queue // games with illegal moves, nil to only report them
+}
+
+// Result compares the movetext terminator with the Result tag,
+// false when the game should be skipped
+func (c *Checks) Result(data string, result pgnparse.Result, file string, index int) bool {
+	if c.ResultMismatch == "ignore" {
+		return true
+	}
+
+	terminator := pgnparse.MovetextResult(data)
+	if pgnparse.Result(terminator) == result {
+		return true
+	}
+
+	c.Report.Add(file, index, "result_mismatch", fmt.Sprintf("Result tag is %q but movetext ends with %q", result, terminator))
+	return c.ResultMismatch != "skip"
+}
+
+// Moves reports a game with an illegal move, err from replaying it, and
+// quarantines it when there's a queue. False when the game has one.
+func (c *Checks) Moves(data string, err error, file string, index int) bool {
+	if err == nil {
+		return true
+	}
+
+	c.Report.Add(file, index, "illegal_move", err.Error())
+	if c.Quarantine != nil {
+		entry := deadletter.Entry{File: file, Game: index, Reason: err.Error(), PGN: data}
+		if err := c.Quarantine.Add(entry); err != nil {
+			fmt.Println("Failed to quarantine game:", err)
+		}
+	}
+	return false
+}
+
+// Elo reports games with impossible ratings or rating gaps, white and
+// black are the Elo tags as read. False when the game should be skipped
+func (c *Checks) Elo(white string, black string, file string, index int) bool {
+	if c.EloCheck == "off" {
+		return true
+	}
+
+	err := c.EloLimits.CheckElo(white, black)
+	if err == nil {
+		return true
+	}
+
+	c.Report.Add(file, index, "implausible_elo", err.Error())
+	return c.EloCheck != "skip"
+}
@@ -0,0 +1,63 @@
+// Package gameid gives every game an ID namespaced by the site it comes from
+// (lichess:abcd1234, chesscom:987654, hash:…), so games merged from several
+// sources into one collection can never collide
+package gameid
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// ID is a game ID within its source
+type ID struct {
+	Source string
+	Value  string
+}
+
+// String returns "source:value"
+func (id ID) String() string {
+	return id.Source + ":" + id.Value
+}
+
+var sites = []struct {
+	source string
+	re     *regexp.Regexp
+}{
+	{"lichess", regexp.MustCompile(`^https?://lichess\.org/(\w{8})(?:[/?#]|$)`)},
+	{"chesscom", regexp.MustCompile(`^https?://(?:www\.)?chess\.com/(?:game/)?(?:live|daily)/(?:game/)?(\d+)(?:[/?#]|$)`)},
+}
+
+// FromURL reads the ID of a game URL (Lichess Site tag, Chess.com Link tag),
+// false for anything else, e.g. the city of an OTB game
+func FromURL(url string) (ID, bool) {
+	url = strings.TrimSpace(url)
+	for _, site := range sites {
+		if match := site.re.FindStringSubmatch(url); match != nil {
+			return ID{Source: site.source, Value: match[1]}, true
+		}
+	}
+	return ID{}, false
+}
+
+// Hash identifies a game without a site ID by its players, date, round and moves
+func Hash(white string, black string, date string, round string, sans []string) ID {
+	sum := sha256.Sum256([]byte(strings.Join([]string{white, black, date, round, strings.Join(sans, " ")}, "\n")))
+	return ID{Source: "hash", Value: hex.EncodeToString(sum[:12])}
+}
+
+// ContentHash identifies a game by what was played, whatever file or site it
+// comes from: players (case and surrounding spaces ignored), date and moves
+func ContentHash(white string, black string, date string, sans []string) string {
+	players := []string{strings.ToLower(strings.TrimSpace(white)), strings.ToLower(strings.TrimSpace(black))}
+	sum := sha256.Sum256([]byte(strings.Join(append(players, strings.TrimSpace(date), strings.Join(sans, " ")), "\n")))
+	return hex.EncodeToString(sum[:12])
+}
+
+// MovesHash fingerprints the main line, so a corrected republished game
+// can be told apart from the stored one
+func MovesHash(sans []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(sans, " ")))
+	return hex.EncodeToString(sum[:12])
+}
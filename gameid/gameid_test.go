@@ -0,0 +1,103 @@
+package gameid
+
+import "testing"
+
+func TestFromURL(t *testing.T) {
+	tests := []struct {
+		url string
+		id  ID
+		ok  bool
+	}{
+		{"https://lichess.org/abcd1234", ID{"lichess", "abcd1234"}, true},
+		{"http://lichess.org/abcd1234", ID{"lichess", "abcd1234"}, true},
+		{" https://lichess.org/abcd1234 ", ID{"lichess", "abcd1234"}, true},
+		{"https://lichess.org/abcd1234/black", ID{"lichess", "abcd1234"}, true},
+		{"https://lichess.org/abcd1234#32", ID{"lichess", "abcd1234"}, true},
+		{"https://lichess.org/abcd1234?ply=3", ID{"lichess", "abcd1234"}, true},
+		{"https://lichess.org/abcd12345", ID{}, false},
+		{"https://lichess.org/tournament", ID{}, false},
+		{"https://lichess.org/abc", ID{}, false},
+		{"https://www.chess.com/game/live/987654", ID{"chesscom", "987654"}, true},
+		{"https://www.chess.com/live/game/987654", ID{"chesscom", "987654"}, true},
+		{"https://chess.com/game/daily/123", ID{"chesscom", "123"}, true},
+		{"https://www.chess.com/game/live/987654?username=someone", ID{"chesscom", "987654"}, true},
+		{"https://www.chess.com/game/live/987654abc", ID{}, false},
+		{"Berlin GER", ID{}, false},
+		{"", ID{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			id, ok := FromURL(tt.url)
+			if id != tt.id || ok != tt.ok {
+				t.Errorf("FromURL(%q) = %v, %v, want %v, %v", tt.url, id, ok, tt.id, tt.ok)
+			}
+		})
+	}
+}
+
+func TestHash(t *testing.T) {
+	sans := []string{"e4", "e5", "Nf3"}
+	id := Hash("Carlsen", "Nakamura", "2024.04.16", "1", sans)
+	if id.Source != "hash" || len(id.Value) != 24 {
+		t.Fatalf("Hash = %v, want a 24 hex digit hash id", id)
+	}
+
+	tests := []struct {
+		name         string
+		white, black string
+		date, round  string
+		sans         []string
+		same         bool
+	}{
+		{"same game", "Carlsen", "Nakamura", "2024.04.16", "1", sans, true},
+		{"colors swapped", "Nakamura", "Carlsen", "2024.04.16", "1", sans, false},
+		{"other date", "Carlsen", "Nakamura", "2024.04.17", "1", sans, false},
+		{"other round", "Carlsen", "Nakamura", "2024.04.16", "2", sans, false},
+		{"other moves", "Carlsen", "Nakamura", "2024.04.16", "1", []string{"e4", "e5", "Nc3"}, false},
+		{"fields don't run together", "Carlsen", "Nakamura", "2024.04.16", "1e4", []string{"e5", "Nf3"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := Hash(tt.white, tt.black, tt.date, tt.round, tt.sans)
+			if (other == id) != tt.same {
+				t.Errorf("Hash = %v, same as %v: %v, want %v", other, id, other == id, tt.same)
+			}
+		})
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	sans := []string{"e4", "e5", "Nf3"}
+	hash := ContentHash("Carlsen", "Nakamura", "2024.04.16", sans)
+	if len(hash) != 24 {
+		t.Fatalf("ContentHash = %q, want 24 hex digits", hash)
+	}
+
+	tests := []struct {
+		name         string
+		white, black string
+		date         string
+		sans         []string
+		same         bool
+	}{
+		{"same game", "Carlsen", "Nakamura", "2024.04.16", sans, true},
+		{"player case", "carlsen", "NAKAMURA", "2024.04.16", sans, true},
+		{"spaces around players and date", " Carlsen ", "Nakamura\t", " 2024.04.16", sans, true},
+		{"colors swapped", "Nakamura", "Carlsen", "2024.04.16", sans, false},
+		{"other date", "Carlsen", "Nakamura", "2024.04.17", sans, false},
+		{"other moves", "Carlsen", "Nakamura", "2024.04.16", []string{"e4", "e5", "Nc3"}, false},
+		{"fewer moves", "Carlsen", "Nakamura", "2024.04.16", sans[:2], false},
+		{"fields don't run together", "Carlsen", "Nakamura", "2024.04.16 e4", []string{"e5", "Nf3"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := ContentHash(tt.white, tt.black, tt.date, tt.sans)
+			if (other == hash) != tt.same {
+				t.Errorf("ContentHash = %q, same as %q: %v, want %v", other, hash, other == hash, tt.same)
+			}
+		})
+	}
+}
@@ -1,39 +1,546 @@
 package main
 
 import (
-	"bufio"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"maps"
+	"math"
 	"os"
 	"path/filepath"
-	"regexp"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"importGames/deadletter"
+	"importGames/eco"
+	"importGames/env"
+	"importGames/features"
+	"importGames/gamecheck"
+	"importGames/gameid"
+	"importGames/lichess"
+	"importGames/packed"
+	"importGames/pgmigrate"
+	"importGames/pgnparse"
+	"importGames/pgnsource"
+	"importGames/progress"
+	"importGames/replay"
+	"importGames/report"
+	"importGames/sink"
+	_ "importGames/sink/csv"
+	_ "importGames/sink/export"
+	_ "importGames/sink/parquet"
+	"importGames/sink/postgres"
+	_ "importGames/sink/sqlite"
+	"importGames/stats"
+
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
-	"gopkg.in/freeeve/pgn.v1"
 )
 
 type Game struct {
-	Opening     string
-	Eco         string
-	Result      string
-	White       string
-	Black       string
-	WhiteElo    int
-	BlackElo    int
-	Positions   []string // Storing positions as a slice of strings
-	Moves       string
-	MovesCount  int
-	Event       string
-	TimeControl string
-	Termination string
-	Date        time.Time
-	Time        time.Time
-	LichessId   string
+	Opening           string
+	Eco               string
+	Result            pgnparse.Result
+	ResultRaw         string
+	White             string
+	Black             string
+	WhiteElo          *int // nil when unrated
+	BlackElo          *int
+	Positions         []string // Storing positions as a slice of strings
+	PositionsOid      uint32   // large object with the positions (--positions-storage=large-object)
+	Zobrist           []int64  // hash of the position after every move
+	UciMoves          []string
+	FinalFen          string
+	MaterialSignature string
+	MaxImbalance      int
+	GameMoves         []pgnparse.MoveDetail // san, uci, ply, clock, eval, comment
+	Evals             []*float32            // per ply in pawns, nil entries for moves without %eval
+	Clocks            []*int32              // per ply in centiseconds left, from %clk
+	Analyzed          bool                  // a move has an %eval
+	Moves             []string              // SAN
+	MovesCount        int                   // full moves
+	PlyCount          int
+	Event             string
+	Tournament        string     // Lichess arena or swiss ID from the Event tag
+	EventDate         *time.Time // nil unless year, month and day are known
+	EventType         string
+	Section           string
+	Stage             string
+	Board             *int
+	Annotator         string
+	DataSource        string // Source tag, not to be confused with Source
+	TimeControl       string
+	Termination       string
+	TerminationDetail string
+	Variant           string
+	Round             string
+	WhiteTitle        string
+	BlackTitle        string
+	WhiteRatingDiff   *int
+	BlackRatingDiff   *int
+	Date              *time.Time // nil unless year, month and day are known
+	DateParts         pgnparse.PartialDate
+	Time              *time.Time
+	LichessId         string
+	Source            string            // lichess, chesscom or hash
+	SourceId          string            // game ID within the source, unique with source
+	MovesHash         string            // fingerprint of the main line, see diff-import
+	ContentHash       string            // players, date and moves, see --dedupe-content
+	Features          []float64         // Only with --features, layout described by features.Names
+	ExtraTags         map[string]string // Original values of normalized tags (--keep-original-tags)
+	Tags              map[string]string // Tags without a column of their own (FEN, WhiteTeam, Site, ...)
+	WhiteRaw          string            // White and Black as read, with --normalize-names
+	SourceFile        string            // where the game was read, empty when reparsed
+	SourceOffset      *int64            // byte offset of the game in the (decompressed) file
+	ImportJobId       string
+	PlayedAt          *time.Time // UTCDate (or Date) and UTCTime, partial dates at the start of the period
+	BlackRaw          string
+	Pgn               string // the game as read, in UTF-8, for --raw-pgn
+
+	// Rows of the dimension tables with --normalized, nil for empty names
+	WhiteId, BlackId, EventId, OpeningId *int32
+
+	replayErr                error  // illegal move found replaying the game, see --validate-moves
+	whiteEloRaw, blackEloRaw string // Elo tags as read, for --elo-check
+}
+
+// config holds the import options (flags default to their .env values)
+type config struct {
+	duplicateTags     pgnparse.DuplicatePolicy
+	skipVariants      []string
+	stateFile         string
+	reportFile        string
+	resultMismatch    string
+	validateMoves     bool
+	invalidGames      string
+	quarantineFile    string
+	features          bool
+	encoding          string
+	normalizeTags     bool
+	keepOriginals     bool
+	eloCheck          string
+	eloLimits         pgnparse.EloLimits
+	minEloRequired    int
+	statsFile         string
+	maxOpenFiles      int
+	dirBatch          int
+	batchSize         int
+	table             string // --table, "" for one table per directory
+	partitionBy       string // "month" or "source", "" with --table-layout=per-directory
+	hypertable        bool   // --table-layout=hypertable, chunked on played_at
+	chunkInterval     string // Postgres intervals, "1 month"
+	compressAfter     string
+	compressSegmentBy string
+	normalized        bool
+	onConflict        string // "nothing" or "update"
+	ensureIndexes     bool
+	trigramIndexes    bool
+	fastLoad          bool
+	rawPgn            string // "text" or "zstd" to keep the PGN of every game, see reparse
+	statsViews        bool
+	openingFromEco    bool
+	normalizeNames    bool // white and black unified, as read in white_raw and black_raw
+	citextNames       bool // --case-insensitive-names
+	notifyChannel     string
+	exportDir         string   // write COPY files there instead of importing
+	importId          string   // in import_job_id of the stored games
+	exportFormat      string   // "csv" or "tsv"
+	backends          []string // registered destinations, postgres by default
+	csvColumns        []string // of --backend=csv, in the order of --columns
+
+	// Connection settings
+	dialect          dialect
+	schema           string // "" for the server's search_path
+	maxConns         int    // 0 for the pgx default
+	minConns         int
+	statementTimeout time.Duration // 0 for the server's
+	applicationName  string
+	tournaments      []string
+	columns          []column
+	diff             bool   // diff-import
+	positionsAside   string // "table", "large-object" or "rows" (--positions-table) when positions are kept out of the games table
+	strict           bool
+	dedupeContent    bool
+	normalizeSAN     bool
+	tagProcessors    []pgnparse.TagProcessor
+}
+
+var cfg config
+
+// dialect is what differs between Postgres and the distributed databases
+// speaking its protocol (--dialect)
+type dialect struct {
+	idType              string // of the games id column
+	batchSize           int    // default --batch-size
+	copy                bool   // COPY FROM in binary format
+	advisoryLocks       bool   // held while migrating
+	doBlocks            bool   // DO $$ ... $$, to convert tables of older versions
+	extensions          bool   // CREATE EXTENSION (citext, pg_trgm)
+	jsonbPathOps        bool   // GIN operator class
+	partitioning        bool   // PARTITION BY LIST
+	unlogged            bool
+	largeObjects        bool
+	refreshConcurrently bool
+	runtimeParams       map[string]string
+}
+
+var dialects = map[string]dialect{
+	"postgres": {
+		idType: "SERIAL", batchSize: 1000, copy: true, advisoryLocks: true, doBlocks: true, extensions: true,
+		jsonbPathOps: true, partitioning: true, unlogged: true, largeObjects: true, refreshConcurrently: true,
+	},
+	// Ids from unique_rowid() spread inserts over the ranges, a sequence
+	// would make the last range a hotspot. SERIAL of the shared tables
+	// become cached sequences, their ids fit the INTEGER references.
+	// Smaller batches stay far from the transaction size limits.
+	"cockroach": {
+		idType: "INT8 DEFAULT unique_rowid()", batchSize: 100,
+		runtimeParams: map[string]string{"serial_normalization": "sql_sequence_cached"},
+	},
+	"yugabyte": {
+		idType: "SERIAL", batchSize: 1000, copy: true, doBlocks: true, extensions: true,
+		jsonbPathOps: true, partitioning: true, refreshConcurrently: true,
+	},
+}
+
+// failedGames counts games that could not be parsed or stored
+var failedGames atomic.Int64
+
+// delta counts what diff-import did
+var delta struct {
+	added, changed, unchanged atomic.Int64
+}
+
+// importReport lists problems with individual games
+var importReport *report.Report
+
+// aggregates are running statistics of the stored games
+var aggregates = stats.New()
+
+// errorsPool receives the games that could not be parsed in import_errors, when importing
+var errorsPool *pgxpool.Pool
+
+// quarantine receives games with illegal moves when --invalid-games=quarantine
+var quarantine *deadletter.Queue
+
+// checks are run on every game before it is stored
+var checks gamecheck.Checks
+
+func loadConfig(args []string) error {
+	duplicateTags := flag.String("duplicate-tags", env.String("DUPLICATE_TAGS", "last"), "value kept for repeated tags in a game: first, last or reject")
+	skipVariants := flag.String("skip-variants", env.String("SKIP_VARIANTS", ""), "comma separated variants to leave out, e.g. Crazyhouse,Atomic")
+	stateFile := flag.String("state-file", env.String("STATE_FILE", ".import_state.json"), "file keeping the last 24h of throughput history, empty to disable")
+	reportFile := flag.String("report-file", env.String("REPORT_FILE", "import_report.jsonl"), "JSON lines file listing problems with individual games, empty to only count them")
+	resultMismatch := flag.String("result-mismatch", env.String("RESULT_MISMATCH", "flag"), "games whose movetext terminator differs from the Result tag: flag (report and import), skip or ignore")
+	validateMoves := flag.Bool("validate-moves", env.Bool("VALIDATE_MOVES", false), "replay every game with a chess rules engine and leave out games with illegal moves")
+	invalidGames := flag.String("invalid-games", env.String("INVALID_GAMES", "reject"), "what to do with games with illegal moves: reject or quarantine")
+	quarantineFile := flag.String("quarantine-file", env.String("QUARANTINE_FILE", "quarantine.jsonl"), "JSON lines file receiving quarantined games")
+	withFeatures := flag.Bool("features", env.Bool("FEATURES", false), "store a numeric feature vector per game for ML (see features.Names)")
+	encoding := flag.String("encoding", env.String("ENCODING", "auto"), "input encoding: auto (UTF-8, else Windows-1252), utf-8, latin1 or windows-1252")
+	positionsStorage := flag.String("positions-storage", env.String("POSITIONS_STORAGE", "column"), "where positions are stored: column (JSONB in the games table), table (chunked side table) or large-object")
+	positionsTable := flag.Bool("positions-table", env.Bool("POSITIONS_TABLE", false), "store positions as (game_id, ply, fen, zobrist) rows in game_positions, searchable by Zobrist hash")
+	selectedColumns := flag.String("columns", env.String("COLUMNS", ""), "columns to create and fill, or -name entries to drop some, e.g. -positions,-features")
+	normalizeTags := flag.Bool("normalize-tags", env.Bool("NORMALIZE_TAGS", true), "trim, collapse spaces, NFC-normalize and strip control characters from tag values")
+	flag.BoolVar(&cfg.normalizeSAN, "normalize-san", env.Bool("NORMALIZE_SAN", true), "store the moves of standard games in canonical SAN (O-O, check marks, no e.p.), whatever notation the file uses")
+	tagProcessors := flag.String("tag-processors", env.String("TAG_PROCESSORS", ""), "comma separated tag processors run on every game, e.g. drop-placeholders,titles-from-names")
+	keepOriginals := flag.Bool("keep-original-tags", env.Bool("KEEP_ORIGINAL_TAGS", false), "keep the original value of normalized tags in extra_tags")
+	eloCheck := flag.String("elo-check", env.String("ELO_CHECK", "skip"), "games with impossible ratings: skip (report, don't import), flag (report and import) or off")
+	flag.IntVar(&cfg.eloLimits.Min, "min-elo", env.Int("MIN_ELO", 100), "lowest plausible rating")
+	flag.IntVar(&cfg.eloLimits.Max, "max-elo", env.Int("MAX_ELO", 3500), "highest plausible rating")
+	flag.IntVar(&cfg.minEloRequired, "min-elo-required", env.Int("MIN_ELO_REQUIRED", 0), "skip games where a player is unrated or rated below this, 0 to import all")
+	flag.IntVar(&cfg.eloLimits.MaxGap, "max-elo-gap", env.Int("MAX_ELO_GAP", 1500), "largest plausible rating difference between the players, 0 to disable")
+	flag.StringVar(&cfg.statsFile, "stats-file", env.String("STATS_FILE", ""), "JSON file receiving the aggregates of the imported games")
+	flag.IntVar(&cfg.maxOpenFiles, "max-open-files", env.Int("MAX_OPEN_FILES", 64), "number of PGN files read at the same time")
+	tournaments := flag.String("tournaments", env.String("TOURNAMENTS", ""), "import-tournaments: comma separated tournament URLs or IDs (swiss:ID for swiss), default all tournaments of the imported games")
+	flag.IntVar(&cfg.dirBatch, "dir-batch", env.Int("DIR_BATCH", 1000), "directory entries read at a time when listing input folders")
+	tableLayout := flag.String("table-layout", env.String("TABLE_LAYOUT", "partitioned"), "partitioned (one table partitioned by --partition-by), hypertable (one TimescaleDB hypertable on played_at) or per-directory (a table per directory, named after it)")
+	flag.StringVar(&cfg.chunkInterval, "chunk-interval", env.String("TIMESCALE_CHUNK_INTERVAL", "1 month"), "played_at range of each hypertable chunk")
+	flag.StringVar(&cfg.compressAfter, "compress-after", env.String("TIMESCALE_COMPRESS_AFTER", ""), "compress hypertable chunks of games played longer ago than this interval, e.g. 3 months")
+	flag.StringVar(&cfg.compressSegmentBy, "compress-segment-by", env.String("TIMESCALE_COMPRESS_SEGMENT_BY", ""), "comma separated columns compressed chunks are grouped by, e.g. white, black")
+	partitionBy := flag.String("partition-by", env.String("PARTITION_BY", "month"), "partitions of the games table: month (of the Date tag) or source")
+	onConflict := flag.String("on-conflict", env.String("ON_CONFLICT", "nothing"), "games already stored: nothing (skip them) or update (refresh termination, result, moves and positions)")
+	flag.BoolVar(&cfg.ensureIndexes, "ensure-indexes", env.Bool("ENSURE_INDEXES", false), "create the usual query indexes (players, eco, date, ratings, positions) after the import")
+	flag.BoolVar(&cfg.trigramIndexes, "trigram-indexes", env.Bool("TRIGRAM_INDEXES", false), "with --ensure-indexes, also index player names for LIKE and similarity searches (pg_trgm)")
+	flag.StringVar(&cfg.schema, "schema", env.String("POSTGRES_SCHEMA", ""), "schema of every table, created if needed, default the server's search_path (usually public)")
+	flag.IntVar(&cfg.maxConns, "max-conns", env.Int("POSTGRES_MAX_CONNS", 0), "largest number of connections to Postgres, 0 for the pgx default (4 or the number of CPUs)")
+	flag.IntVar(&cfg.minConns, "min-conns", env.Int("POSTGRES_MIN_CONNS", 0), "connections kept open even when idle")
+	statementTimeout := flag.String("statement-timeout", env.String("POSTGRES_STATEMENT_TIMEOUT", "0s"), "cancel statements running longer than this, 0 for the server's setting")
+	flag.StringVar(&cfg.applicationName, "application-name", env.String("POSTGRES_APPLICATION_NAME", "importPGN"), "application_name of the connections, shown in pg_stat_activity")
+	rawPgn := flag.String("raw-pgn", env.String("RAW_PGN", ""), "keep the PGN of every game for reparse: text (pgn column) or zstd (compressed pgn_zstd column)")
+	flag.BoolVar(&cfg.statsViews, "stats-views", env.Bool("STATS_VIEWS", false), "create or refresh materialized views of statistics (per ECO, per player, ratings per time control) after the import")
+	flag.BoolVar(&cfg.openingFromEco, "opening-from-eco", env.Bool("OPENING_FROM_ECO", true), "name the opening after the ECO code when the PGN has no Opening tag")
+	flag.BoolVar(&cfg.normalizeNames, "normalize-names", env.Bool("NORMALIZE_NAMES", false), "unify how player names are written (comma spacing, initials, underscores), keeping the names as read in white_raw and black_raw")
+	flag.BoolVar(&cfg.citextNames, "case-insensitive-names", env.Bool("CASE_INSENSITIVE_NAMES", false), "make white and black citext columns, so names compare case-insensitively")
+	flag.StringVar(&cfg.notifyChannel, "notify-channel", env.String("POSTGRES_NOTIFY_CHANNEL", ""), "NOTIFY this channel with the game id, players and ECO of the games of every committed batch")
+	flag.StringVar(&cfg.exportDir, "export-dir", env.String("EXPORT_DIR", ""), "don't connect: write a COPY file per table, with the SQL creating and loading them, into this directory")
+	flag.StringVar(&cfg.exportFormat, "export-format", env.String("EXPORT_FORMAT", "csv"), "format of the --export-dir files: csv or tsv")
+	backends := flag.String("backend", env.String("BACKEND", "postgres"), "comma separated destinations of the games, written in one pass: postgres, sqlite for a single file needing no server, parquet for files to analyze or csv for spreadsheets")
+	flag.StringVar(&cfg.importId, "import-id", env.String("IMPORT_ID", ""), "ID stored in import_job_id on every game of this run (default: a new one)")
+	flag.BoolVar(&cfg.fastLoad, "fast-load", env.Bool("FAST_LOAD", false), "create new games tables UNLOGGED during the import, then log them and build the indexes; a crash during the import empties them")
+	flag.BoolVar(&cfg.normalized, "normalized", env.Bool("NORMALIZED", false), "store players, events and openings once in their own tables, referenced by the games")
+	flag.StringVar(&cfg.table, "table", env.String("POSTGRES_TABLE", ""), "table receiving every game, default games, or one table per directory with --table-layout=per-directory")
+	flag.IntVar(&cfg.batchSize, "batch-size", env.Int("BATCH_SIZE", 0), "games loaded per COPY, default 1000 (100 with --dialect=cockroach)")
+	dialectName := flag.String("dialect", env.String("POSTGRES_DIALECT", "postgres"), "database speaking the Postgres protocol: postgres, cockroach or yugabyte")
+	flag.BoolVar(&cfg.dedupeContent, "dedupe-content", env.Bool("DEDUPE_CONTENT", false), "make content_hash (players, date and moves) unique, skipping the same game from other files or sites")
+	flag.BoolVar(&cfg.strict, "strict", env.Bool("STRICT", false), "abort on the first malformed game instead of skipping it")
+	sink.RegisterFlags()
+	flag.CommandLine.Parse(args)
+
+	policy, err := pgnparse.ParseDuplicatePolicy(*duplicateTags)
+	if err != nil {
+		return err
+	}
+	cfg.duplicateTags = policy
+	cfg.skipVariants = env.SplitList(*skipVariants)
+	cfg.tournaments = env.SplitList(*tournaments)
+	cfg.stateFile = *stateFile
+	cfg.reportFile = *reportFile
+
+	switch *resultMismatch {
+	case "flag", "skip", "ignore":
+		cfg.resultMismatch = *resultMismatch
+	default:
+		return fmt.Errorf("unknown result mismatch policy %q", *resultMismatch)
+	}
+
+	cfg.validateMoves = *validateMoves
+	cfg.features = *withFeatures
+
+	if err := pgnparse.CheckEncoding(*encoding); err != nil {
+		return err
+	}
+	cfg.encoding = *encoding
+	cfg.normalizeTags = *normalizeTags
+	cfg.keepOriginals = *keepOriginals
+	if cfg.tagProcessors, err = pgnparse.TagProcessors(env.SplitList(*tagProcessors)); err != nil {
+		return err
+	}
+
+	switch *eloCheck {
+	case "skip", "flag", "off":
+		cfg.eloCheck = *eloCheck
+	default:
+		return fmt.Errorf("unknown elo check policy %q", *eloCheck)
+	}
+
+	cfg.columns, err = selectColumns(*selectedColumns)
+	if err != nil {
+		return err
+	}
+	if cfg.dedupeContent && !hasColumn("content_hash") {
+		return fmt.Errorf("--dedupe-content needs the content_hash column")
+	}
+
+	for _, backend := range env.SplitList(*backends) {
+		// export is --export-dir, in place of postgres
+		if _, ok := sink.Lookup(backend); !ok || backend == "export" {
+			return fmt.Errorf("unknown backend %q", backend)
+		}
+		if !slices.Contains(cfg.backends, backend) {
+			cfg.backends = append(cfg.backends, backend)
+		}
+	}
+	if len(cfg.backends) == 0 {
+		return fmt.Errorf("--backend needs a destination")
+	}
+	var ok bool
+	if slices.Contains(cfg.backends, "postgres") {
+		if cfg.dialect, ok = dialects[*dialectName]; !ok {
+			return fmt.Errorf("unknown dialect %q", *dialectName)
+		}
+	} else {
+		// None of the Postgres features, partitioned layouts become one table
+		*dialectName = strings.Join(cfg.backends, ",")
+		cfg.dialect = dialect{batchSize: 1000}
+	}
+	if cfg.batchSize == 0 {
+		cfg.batchSize = cfg.dialect.batchSize
+	}
+	switch {
+	case *positionsStorage == "large-object" && !cfg.dialect.largeObjects:
+		return fmt.Errorf("--dialect=%s has no large objects, use --positions-storage=table", *dialectName)
+	case cfg.fastLoad && !cfg.dialect.unlogged:
+		return fmt.Errorf("--dialect=%s has no unlogged tables for --fast-load", *dialectName)
+	case cfg.citextNames && !cfg.dialect.extensions:
+		return fmt.Errorf("--dialect=%s has no citext for --case-insensitive-names", *dialectName)
+	case cfg.notifyChannel != "" && *dialectName != "postgres":
+		return fmt.Errorf("--dialect=%s has no LISTEN/NOTIFY for --notify-channel", *dialectName)
+	}
+
+	switch *positionsStorage {
+	case "column":
+	case "table", "large-object":
+		// Positions leave the games table, which stays lean to scan
+		if hasColumn("positions") {
+			cfg.positionsAside = *positionsStorage
+			cfg.columns = withoutColumn(cfg.columns, "positions")
+			if cfg.positionsAside == "large-object" {
+				cfg.columns = append(cfg.columns, column{"positions_oid", "OID", func(g *Game) any { return g.PositionsOid }})
+			}
+		}
+	default:
+		return fmt.Errorf("unknown positions storage %q", *positionsStorage)
+	}
+	if *positionsTable {
+		if cfg.positionsAside != "" {
+			return fmt.Errorf("--positions-table replaces --positions-storage=%s", cfg.positionsAside)
+		}
+		if hasColumn("positions") {
+			cfg.positionsAside = "rows"
+			cfg.columns = withoutColumn(cfg.columns, "positions")
+		}
+	}
+	cfg.quarantineFile = *quarantineFile
+	switch *invalidGames {
+	case "reject", "quarantine":
+		cfg.invalidGames = *invalidGames
+	default:
+		return fmt.Errorf("unknown invalid games policy %q", *invalidGames)
+	}
+
+	if cfg.normalizeNames && (hasColumn("white") || hasColumn("black")) {
+		cfg.columns = append(cfg.columns,
+			column{"white_raw", "TEXT", func(g *Game) any { return rawName(g.WhiteRaw, g.White) }},
+			column{"black_raw", "TEXT", func(g *Game) any { return rawName(g.BlackRaw, g.Black) }})
+	}
+	if cfg.citextNames {
+		if cfg.normalized {
+			return fmt.Errorf("--case-insensitive-names applies to the white and black columns, --normalized keeps names in players")
+		}
+		for i, c := range cfg.columns {
+			if c.name == "white" || c.name == "black" {
+				cfg.columns[i].ddl = "CITEXT"
+			}
+		}
+	}
+	if cfg.normalized {
+		cfg.columns = normalizedColumns(cfg.columns)
+	}
+
+	switch *rawPgn {
+	case "":
+	case "text":
+		cfg.columns = append(cfg.columns, column{"pgn", "TEXT", func(g *Game) any { return g.Pgn }})
+	case "zstd":
+		cfg.columns = append(cfg.columns, column{"pgn_zstd", "BYTEA", func(g *Game) any { return packed.Compress([]byte(g.Pgn)) }})
+	default:
+		return fmt.Errorf("unknown raw PGN storage %q", *rawPgn)
+	}
+	cfg.rawPgn = *rawPgn
+
+	switch *tableLayout {
+	case "partitioned":
+		if cfg.table == "" {
+			cfg.table = "games"
+		}
+		if !cfg.dialect.partitioning {
+			break // one table, split into ranges by the database itself
+		}
+		switch *partitionBy {
+		case "month", "source":
+			cfg.partitionBy = *partitionBy
+		default:
+			return fmt.Errorf("unknown partitioning %q", *partitionBy)
+		}
+		cfg.columns = partitionedColumns(cfg.columns)
+	case "hypertable":
+		if *dialectName != "postgres" || cfg.fastLoad {
+			return fmt.Errorf("--table-layout=hypertable needs Postgres with TimescaleDB, and logged tables")
+		}
+		if cfg.table == "" {
+			cfg.table = "games"
+		}
+		cfg.hypertable = true
+		cfg.columns = partitionedColumns(cfg.columns)
+	case "per-directory":
+	default:
+		return fmt.Errorf("unknown table layout %q", *tableLayout)
+	}
+	if cfg.partitionBy == "source" && cfg.dedupeContent {
+		// A unique index must include the partition key
+		return fmt.Errorf("--dedupe-content can't find copies across sources with --partition-by=source")
+	}
+
+	if cfg.fastLoad {
+		cfg.ensureIndexes = true
+	}
+
+	if cfg.statementTimeout, err = time.ParseDuration(*statementTimeout); err != nil {
+		return fmt.Errorf("invalid --statement-timeout %q", *statementTimeout)
+	}
+	if cfg.maxConns < 0 || cfg.minConns < 0 || cfg.statementTimeout < 0 {
+		return fmt.Errorf("--max-conns, --min-conns and --statement-timeout can't be negative")
+	}
+	if cfg.maxConns > 0 && cfg.minConns > cfg.maxConns {
+		return fmt.Errorf("--min-conns is above --max-conns")
+	}
+
+	switch *onConflict {
+	case "nothing", "update":
+		cfg.onConflict = *onConflict
+	default:
+		return fmt.Errorf("unknown conflict policy %q", *onConflict)
+	}
+	if cfg.onConflict == "update" && cfg.dedupeContent {
+		// DO UPDATE handles conflicts on one unique index only
+		return fmt.Errorf("--on-conflict=update can't be combined with --dedupe-content")
+	}
+
+	if cfg.table != "" && tableNameOf(cfg.table) != cfg.table {
+		return fmt.Errorf("invalid --table %q: letters, digits and underscores only, at most %d bytes", cfg.table, maxTableName)
+	}
+
+	if cfg.importId == "" {
+		id := make([]byte, 12)
+		rand.Read(id)
+		cfg.importId = hex.EncodeToString(id)
+	}
+
+	if cfg.exportDir != "" {
+		switch {
+		case cfg.exportFormat != "csv" && cfg.exportFormat != "tsv":
+			return fmt.Errorf("unknown export format %q", cfg.exportFormat)
+		case cfg.normalized || cfg.positionsAside != "":
+			return fmt.Errorf("--export-dir can't look up --normalized names or write positions aside")
+		case cfg.fastLoad || cfg.statsViews || cfg.notifyChannel != "":
+			return fmt.Errorf("--fast-load, --stats-views and --notify-channel need a connection, not --export-dir")
+		}
+	}
+
+	// The other destinations get the games table only
+	if backends := strings.Join(cfg.backends, ","); backends != "postgres" {
+		switch {
+		case cfg.exportDir != "":
+			return fmt.Errorf("--export-dir writes Postgres files, not --backend=%s", backends)
+		case cfg.normalized || cfg.positionsAside != "":
+			return fmt.Errorf("--backend=%s keeps everything in the games table, no --normalized or positions aside", backends)
+		case cfg.statsViews && !slices.Contains(cfg.backends, "postgres"):
+			return fmt.Errorf("--stats-views needs Postgres materialized views")
+		}
+	}
+	if slices.Contains(cfg.backends, "csv") {
+		if *tableLayout == "per-directory" {
+			return fmt.Errorf("--backend=csv writes one file, not a table per directory")
+		}
+		cfg.csvColumns = csvColumns(cfg.columns, *selectedColumns)
+	}
+
+	if cfg.maxOpenFiles < 1 || cfg.dirBatch < 1 || cfg.batchSize < 1 {
+		return fmt.Errorf("--max-open-files, --dir-batch and --batch-size must be at least 1")
+	}
+
+	return nil
 }
 
 func main() {
@@ -41,21 +548,272 @@ func main() {
 		fmt.Println("No .env file found")
 	}
 
+	// Optional command before the flags
+	command, args := "import", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	if err := loadConfig(args); err != nil {
+		fmt.Println("Invalid config:", err)
+		return
+	}
+
+	var err error
+	importReport, err = report.Open(cfg.reportFile)
+	if err != nil {
+		fmt.Println("Failed to create report file:", err)
+		return
+	}
+
+	if cfg.validateMoves && cfg.invalidGames == "quarantine" && command == "import" {
+		quarantine, err = deadletter.Open(cfg.quarantineFile)
+		if err != nil {
+			fmt.Println("Failed to open quarantine file:", err)
+			return
+		}
+		defer quarantine.Close()
+	}
+	checks = gamecheck.Checks{
+		ResultMismatch: cfg.resultMismatch,
+		EloCheck:       cfg.eloCheck,
+		EloLimits:      cfg.eloLimits,
+		Report:         importReport,
+		Quarantine:     quarantine,
+	}
+
 	databaseUrl := os.Getenv("DATABASE_URL")
 	folderPath := os.Getenv("FOLDER_PATH")
 
-	pool, err := pgxpool.New(context.Background(), databaseUrl)
+	if (cfg.exportDir != "" || !slices.Equal(cfg.backends, []string{"postgres"})) && command != "import" {
+		fmt.Println("--export-dir and --backend other than postgres only work with import")
+		return
+	}
+	if cfg.exportDir != "" || !slices.Contains(cfg.backends, "postgres") {
+		if output, err = openOutput(nil); err != nil {
+			fmt.Println("Failed to create output:", err)
+			return
+		}
+		fmt.Println("Import ID:", cfg.importId)
+		importFolder(folderPath, nil)
+		closeOutput()
+		closeReport()
+		return
+	}
+
+	pool, err := connect(context.Background(), databaseUrl)
 	if err != nil {
 		fmt.Println("Failed to connect to PostgreSQL:", err)
 		return
 	}
 	defer pool.Close()
 
+	// Shared tables come from the migrations, games tables from the columns
+	if !migrate(pool) {
+		return
+	}
+	if output, err = openOutput(pool); err != nil {
+		fmt.Println("Failed to create output:", err)
+		return
+	}
+	if command == "import" || command == "reprocess-dead-letters" || command == "diff-import" {
+		errorsPool = pool
+		fmt.Println("Import ID:", cfg.importId)
+	}
+
+	switch command {
+	case "migrate":
+		fmt.Println("Schema up to date")
+		return
+	case "import":
+		importFolder(folderPath, pool)
+	case "diff-import":
+		if !hasColumn("moves_hash") {
+			fmt.Println("diff-import needs the moves_hash column")
+			return
+		}
+		cfg.diff = true
+		importFolder(folderPath, pool)
+		fmt.Printf("Diff: %d added, %d changed, %d unchanged\n", delta.added.Load(), delta.changed.Load(), delta.unchanged.Load())
+	case "reprocess-dead-letters":
+		reprocessDeadLetters(folderPath, pool)
+	case "import-tournaments":
+		importTournaments(folderPath, pool)
+	case "refresh-stats":
+		tables, err := tablesOf(folderPath)
+		if err != nil {
+			fmt.Println("Error reading directory:", err)
+			return
+		}
+		for _, tableName := range tables {
+			updateStatsViews(pool, tableName)
+		}
+	case "reparse":
+		reparse(folderPath, pool)
+	case "ensure-indexes":
+		tables, err := tablesOf(folderPath)
+		if err != nil {
+			fmt.Println("Error reading directory:", err)
+			return
+		}
+		for _, tableName := range tables {
+			if err := ensureIndexes(pool, tableName); err != nil {
+				fmt.Printf("Failed to create indexes of %s: %s\n", tableName, err)
+			}
+		}
+	default:
+		fmt.Println("Unknown command:", command)
+		return
+	}
+
+	closeOutput()
+	closeReport()
+}
+
+func closeReport() {
+	if err := importReport.Close(); err != nil {
+		fmt.Println("Failed to write report file:", err)
+	}
+	fmt.Println("Report:", importReport.Summary())
+}
+
+// output receives the games: Postgres, the files of --export-dir instead,
+// and the other destinations of --backend, all at once
+var output *sink.Fanout
+
+// outputOptions are what the destinations get of the config, pool is nil
+// without a connection
+func outputOptions(pool *pgxpool.Pool) sink.Options {
+	return sink.Options{
+		ImportId:      cfg.importId,
+		Workers:       cfg.maxOpenFiles,
+		BatchSize:     cfg.batchSize,
+		Upsert:        cfg.onConflict == "update",
+		DedupeContent: cfg.dedupeContent,
+		Pool:          pool,
+		Copy:          cfg.dialect.copy,
+		Positions:     cfg.positionsAside,
+		Notify:        cfg.notifyChannel,
+		Columns:       cfg.csvColumns,
+		ExportDir:     cfg.exportDir,
+		ExportFormat:  cfg.exportFormat,
+	}
+}
+
+// openOutput opens the destinations of --backend, with the files of
+// --export-dir instead of Postgres
+func openOutput(pool *pgxpool.Pool) (*sink.Fanout, error) {
+	f := &sink.Fanout{}
+	for _, backend := range cfg.backends {
+		name := backend
+		if backend == "postgres" && cfg.exportDir != "" {
+			name = "export"
+		}
+		s, _ := sink.Lookup(name)
+		f.Add(backend, s)
+	}
+	if err := f.Open(context.Background(), outputOptions(pool)); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func closeOutput() {
+	if err := output.Close(); err != nil {
+		fmt.Println("Failed to write output:", err)
+	}
+}
+
+// tableOf describes a games table to the destinations
+func tableOf(tableName string) sink.Table {
+	table := sink.Table{
+		Name:    tableName,
+		Columns: make([]sink.Column, len(cfg.columns)),
+		Create:  createTableSQL(tableName),
+		Insert:  insertSQL(tableName),
+		Update:  updateSQL(tableName),
+	}
+	for i, c := range cfg.columns {
+		table.Columns[i] = sink.Column{Name: c.name, Type: c.ddl, Mutable: mutableColumns[c.name]}
+	}
+	if cfg.ensureIndexes {
+		table.Indexes = indexStatements(tableName)
+	}
+	return table
+}
+
+// record is what the destinations get of a queued game
+func record(q queuedGame) sink.Record {
+	return sink.Record{Table: q.tableName, File: q.file, Index: q.index, Game: sinkGame(q.game), Row: insertArgs(q.game)}
+}
+
+// sinkGame converts a game for the destinations
+func sinkGame(g *Game) *sink.Game {
+	game := &sink.Game{
+		Source: g.Source, SourceId: g.SourceId, MovesHash: g.MovesHash, ContentHash: g.ContentHash,
+		ImportId: g.ImportJobId, SourceFile: g.SourceFile, SourceOffset: g.SourceOffset,
+		Event: g.Event, TournamentId: g.Tournament, EventDate: g.EventDate, Site: g.Tags["Site"], Round: g.Round,
+		White: g.White, Black: g.Black, WhiteElo: g.WhiteElo, BlackElo: g.BlackElo,
+		WhiteTitle: g.WhiteTitle, BlackTitle: g.BlackTitle, WhiteRatingDiff: g.WhiteRatingDiff, BlackRatingDiff: g.BlackRatingDiff,
+		Result: string(g.Result), Eco: g.Eco, Opening: g.Opening, TimeControl: g.TimeControl, Termination: g.Termination, Variant: g.Variant,
+		Year: g.DateParts.Year, Month: g.DateParts.Month, Day: g.DateParts.Day, Date: g.Date, PlayedAt: g.PlayedAt,
+		Moves: g.Moves, UciMoves: g.UciMoves, Evals: g.Evals, Clocks: g.Clocks, Analyzed: g.Analyzed,
+		Positions: g.Positions, Zobrist: g.Zobrist, MovesCount: g.MovesCount, PlyCount: g.PlyCount,
+		FinalFen: g.FinalFen, MaxImbalance: g.MaxImbalance, Features: g.Features, Tags: g.Tags,
+	}
+	if g.Time != nil {
+		game.Time = g.Time.Format("15:04:05")
+	}
+	return game
+}
+
+// storeGame inserts the game, or with update replaces the stored one,
+// outside of the batches (see postgres.Store)
+func storeGame(ctx context.Context, pool *pgxpool.Pool, tableName string, game *Game, update bool) error {
+	r := sink.Record{Table: tableName, Game: sinkGame(game), Row: insertArgs(game)}
+	return postgres.Store(ctx, pool, outputOptions(pool), tableOf(tableName), r, update)
+}
+
+// csvColumns are the columns of --columns in the order given, the spreadsheet
+// order. Unlike tables, a file doesn't need the identity columns to skip
+// conflicts. Columns added by other options come last.
+func csvColumns(selected []column, value string) []string {
+	var ordered []string
+	for _, name := range env.SplitList(value) {
+		if slices.ContainsFunc(selected, func(c column) bool { return c.name == name }) {
+			ordered = append(ordered, name)
+		}
+	}
+	if len(ordered) == 0 {
+		return nil // all columns, or all but the -name ones
+	}
+	for _, c := range selected {
+		if !slices.ContainsFunc(columns, func(known column) bool { return known.name == c.name }) {
+			ordered = append(ordered, c.name)
+		}
+	}
+	return ordered
+}
+
+// importFolder imports every directory of the folder into its own table
+func importFolder(folderPath string, pool *pgxpool.Pool) {
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 	var totalGames int
 	dirs := make(chan string, 10)
 
+	// Rolling throughput history
+	started := time.Now()
+	history := loadHistory()
+	stopTracking := func() {}
+	if history != nil {
+		stopTracking = history.Track(time.Minute, func() (int, int) {
+			mu.Lock()
+			defer mu.Unlock()
+			return totalGames, int(failedGames.Load())
+		})
+	}
+
 	// Add directories to the channel
 	go func() {
 		defer close(dirs)
@@ -72,7 +830,7 @@ func main() {
 	}()
 
 	// Create workers to process directories in parallel
-	for i := 0; i < 3; i++ { // Number of directory processing goroutines (for 2 directories)
+	for i := 0; i < dirWorkers; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -83,279 +841,1895 @@ func main() {
 	}
 
 	wg.Wait()
-	fmt.Printf("Finished. Total Games Processed: %d\n", totalGames)
-}
-
-func processDirectory(dirPath string, pool *pgxpool.Pool, totalProcessed *int, mu *sync.Mutex) {
-	var wg sync.WaitGroup
-	files := make(chan string, 100)
+	stopTracking()
 
-	// Walk through the files in the directory and queue them for processing
-	go func() {
-		defer close(files)
-		err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				fmt.Printf("Error accessing file %s: %s\n", path, err)
-				return nil
+	if cfg.fastLoad {
+		for _, tableName := range slices.Sorted(maps.Keys(loadedTables)) {
+			if err := setLogged(pool, tableName); err != nil {
+				fmt.Printf("Failed to log %s, it stays UNLOGGED: %s\n", tableName, err)
 			}
+		}
+	}
 
-			if !info.IsDir() {
-				files <- path
+	// Indexes are built once the games are in, much faster than maintaining them while loading
+	if cfg.ensureIndexes && pool != nil {
+		for _, tableName := range slices.Sorted(maps.Keys(loadedTables)) {
+			if err := ensureIndexes(pool, tableName); err != nil {
+				fmt.Printf("Failed to create indexes of %s: %s\n", tableName, err)
 			}
-			return nil
-		})
-		if err != nil {
-			fmt.Println("Error processing files:", err)
 		}
-	}()
-
-	tableName := strings.ReplaceAll(filepath.Base(dirPath), "-", "_")
-	tableName = fmt.Sprintf("\"%s\"", tableName) // Ensure table name is valid
-
-	// Create table for the current directory
-	_, err := pool.Exec(context.Background(), fmt.Sprintf(`
-		CREATE TABLE IF NOT EXISTS %s (
-			id SERIAL PRIMARY KEY,
-			lichess_id TEXT UNIQUE,
-			opening TEXT,
-			eco TEXT,
-			result TEXT,
-			white TEXT,
-			black TEXT,
-			white_elo INTEGER,
-			black_elo INTEGER,
-			positions JSONB,
-			moves TEXT,
-			moves_count INTEGER,
-			event TEXT,
-			time_control TEXT,
-			termination TEXT,
-			date DATE,
-			time TIME,
-			created_at TIMESTAMPTZ DEFAULT now(),
-			updated_at TIMESTAMPTZ DEFAULT now()
-		);
-	`, tableName))
-	if err != nil {
-		fmt.Printf("Failed to create table %s: %s\n", tableName, err)
-		return
 	}
 
-	// Create workers to process files in the current directory
-	for i := 0; i < 8; i++ { // Number of file processing goroutines
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for filePath := range files {
-				processFile(filePath, pool, tableName, totalProcessed, mu)
-			}
-		}()
+	if cfg.statsViews {
+		for _, tableName := range slices.Sorted(maps.Keys(loadedTables)) {
+			updateStatsViews(pool, tableName)
+		}
 	}
 
-	wg.Wait()
+	fmt.Printf("Finished. Total Games Processed: %d\n", totalGames)
+	if history != nil {
+		printThroughput(history, started)
+	}
+	printStats()
 }
 
-func processFile(filePath string, pool *pgxpool.Pool, tableName string, totalProcessed *int, mu *sync.Mutex) {
-	file, err := os.Open(filePath)
+// reprocessDeadLetters retries quarantined games with the current parser
+// and keeps only the entries that still fail. Games the current options
+// leave out are cleared too, retrying them would never store them.
+func reprocessDeadLetters(folderPath string, pool *pgxpool.Pool) {
+	entries, err := deadletter.Load(cfg.quarantineFile)
 	if err != nil {
-		fmt.Printf("Failed to open file %s: %s\n", filePath, err)
+		fmt.Println("Failed to read quarantine file:", err)
 		return
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	var gameData strings.Builder
+	// Games were quarantined for illegal moves, never import them unchecked
+	cfg.validateMoves = true
 
-	for scanner.Scan() {
-		line := scanner.Text()
+	var mu sync.Mutex
+	var recovered, cleared int
+	var queued, remaining []deadletter.Entry
 
-		if strings.HasPrefix(line, "[Event ") {
-			if gameData.Len() > 0 {
-				processGame(gameData.String(), pool, tableName)
-				mu.Lock()
-				*totalProcessed++
-				fmt.Printf("Total games processed: %d\n", *totalProcessed)
-				mu.Unlock()
-				gameData.Reset()
-			}
+	// One batch per table, all keeping what became of every game to know
+	// which entries succeeded
+	outcomes := make(map[gameKey]outcome)
+	batches := make(map[string]*gameBatch)
+	for _, entry := range entries {
+		tableName, err := tableForFile(folderPath, entry.File)
+		if err != nil {
+			remaining = append(remaining, entry)
+			continue
+		}
+		batch := batches[tableName]
+		if batch == nil {
+			batch = newGameBatch(pool, tableName, cfg.batchSize, &recovered, &mu)
+			batch.outcomes = outcomes
+			batches[tableName] = batch
 		}
 
-		gameData.WriteString(line + "\n")
+		switch processGame(entry.PGN, entry.File, entry.Game, -1, batch) {
+		case stored:
+		case pending:
+			queued = append(queued, entry)
+		case skipped:
+			cleared++
+		default:
+			remaining = append(remaining, entry)
+		}
 	}
 
-	if gameData.Len() > 0 {
-		processGame(gameData.String(), pool, tableName)
-		mu.Lock()
-		*totalProcessed++
-		fmt.Printf("Total games processed: %d\n", *totalProcessed)
-		mu.Unlock()
+	for _, batch := range batches {
+		batch.flush()
+	}
+	for _, entry := range queued {
+		switch outcomes[gameKey{entry.File, entry.Game}] {
+		case stored:
+		case skipped:
+			cleared++
+		default:
+			remaining = append(remaining, entry)
+		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Printf("Error reading file %s: %s\n", filePath, err)
+	if err := deadletter.Rewrite(cfg.quarantineFile, remaining); err != nil {
+		fmt.Println("Failed to rewrite quarantine file:", err)
+		return
 	}
-}
 
-func processGame(data string, pool *pgxpool.Pool, tableName string) {
-	game := parseGame(data)
+	fmt.Printf("Finished. Recovered %d of %d quarantined games, %d left out by the current options\n", recovered, len(entries), cleared)
+}
 
-	positionsJSON, err := json.Marshal(game.Positions)
+// connect opens the pool with the connection settings, creating --schema
+func connect(ctx context.Context, url string) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(url)
 	if err != nil {
-		fmt.Println("Failed to marshal positions to JSON:", err)
-		return
+		return nil, err
+	}
+	if cfg.maxConns > 0 {
+		poolConfig.MaxConns = int32(cfg.maxConns)
+	}
+	if cfg.minConns > 0 {
+		poolConfig.MinConns = int32(cfg.minConns)
 	}
 
-	_, err = pool.Exec(context.Background(), fmt.Sprintf(`
-		INSERT INTO %s (lichess_id, opening, eco, result, white, black, white_elo, black_elo, positions, moves, moves_count, event, time_control, termination, date, time)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
-		ON CONFLICT (lichess_id) DO NOTHING
-	`, tableName), game.LichessId, game.Opening, game.Eco, game.Result, game.White, game.Black, game.WhiteElo, game.BlackElo, positionsJSON, game.Moves, game.MovesCount, game.Event, game.TimeControl, game.Termination, game.Date, game.Time)
+	params := poolConfig.ConnConfig.RuntimeParams
+	maps.Copy(params, cfg.dialect.runtimeParams)
+	if cfg.applicationName != "" {
+		params["application_name"] = cfg.applicationName
+	}
+	if cfg.statementTimeout > 0 {
+		params["statement_timeout"] = fmt.Sprint(cfg.statementTimeout.Milliseconds())
+	}
+	if cfg.schema != "" {
+		// Tables are created in the schema, extensions are still found in public
+		params["search_path"] = pgx.Identifier{cfg.schema}.Sanitize() + ", public"
+	}
 
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
-		fmt.Println("Failed to insert game into PostgreSQL:", err)
+		return nil, err
 	}
+	if cfg.schema != "" {
+		if _, err := pool.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS "+pgx.Identifier{cfg.schema}.Sanitize()); err != nil {
+			pool.Close()
+			return nil, err
+		}
+	}
+	return pool, nil
 }
 
-func parseGame(data string) *Game {
-	game := &Game{
-		Positions: []string{}, // Initialize as a slice
+// migrate applies the pending migrations of the shared tables
+// (import_errors, tournaments, players, ...)
+func migrate(pool *pgxpool.Pool) bool {
+	ran, err := pgmigrate.Apply(context.Background(), pool, cfg.dialect.advisoryLocks)
+	for _, m := range ran {
+		fmt.Println("Applied migration", m.Name)
 	}
+	if err != nil {
+		fmt.Println("Failed to migrate:", err)
+		return false
+	}
+	if err := loadEcoCodes(pool); err != nil {
+		fmt.Println("Failed to load ECO codes:", err)
+		return false
+	}
+	return true
+}
 
-	re := regexp.MustCompile(`\[(\w+) "([^"]*)"\]`)
-	matches := re.FindAllStringSubmatch(data, -1)
+// loadEcoCodes fills eco_codes from the embedded list, so games can reference
+// it. Names are updated when the list changes.
+func loadEcoCodes(pool *pgxpool.Pool) error {
+	var codes, names []string
+	for _, c := range eco.Codes() {
+		codes = append(codes, c.Code)
+		names = append(names, c.Name)
+	}
+	_, err := pool.Exec(context.Background(), `
+INSERT INTO eco_codes (code, name) SELECT * FROM unnest($1::text[], $2::text[])
+ON CONFLICT (code) DO UPDATE SET name = EXCLUDED.name WHERE eco_codes.name <> EXCLUDED.name`, codes, names)
+	return err
+}
 
-	for _, match := range matches {
-		tag := match[1]
-		value := match[2]
+// importTournaments stores the standings of the Lichess tournaments the
+// imported games were played in (or of --tournaments), skipping known ones
+func importTournaments(folderPath string, pool *pgxpool.Pool) {
+	ctx := context.Background()
 
-		switch tag {
-		case "Opening":
-			game.Opening = value
-		case "Event":
-			game.Event = value
-		case "Site":
-			game.LichessId = strings.TrimPrefix(value, "https://lichess.org/")
-		case "Date":
-			parsedDate, err := time.Parse("2006.01.02", value)
-			if err == nil {
-				game.Date = parsedDate
-			}
-		case "UTCTime":
-			parsedTime, err := time.Parse("15:04:05", value)
-			if err == nil {
-				game.Time = parsedTime
+	var refs []lichess.TournamentRef
+	if len(cfg.tournaments) > 0 {
+		for _, value := range cfg.tournaments {
+			refs = append(refs, lichess.ParseRef(value))
+		}
+	} else {
+		refs = tournamentsOfGames(folderPath, pool)
+	}
+
+	client := &lichess.Client{Token: os.Getenv("LICHESS_TOKEN")}
+	var stored int
+
+	for _, ref := range refs {
+		if len(cfg.tournaments) == 0 {
+			var known bool
+			err := pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM tournaments WHERE id = $1)", ref.ID).Scan(&known)
+			if err == nil && known {
+				continue
 			}
-		case "White":
-			game.White = value
-		case "Black":
+		}
+
+		tournament, err := client.Tournament(ref)
+		if err != nil {
+			fmt.Printf("Failed to fetch tournament %s: %s\n", ref.ID, err)
+			continue
+		}
+
+		if err := storeTournament(ctx, pool, tournament); err != nil {
+			fmt.Println("Failed to store tournament in PostgreSQL:", err)
+			continue
+		}
+		stored++
+		fmt.Printf("Stored %s (%d players)\n", tournament.Name, len(tournament.Standings))
+	}
+
+	fmt.Printf("Finished. Stored %d of %d tournaments\n", stored, len(refs))
+}
+
+// tournamentsOfGames lists the tournaments found in the tables of the games folder
+func tournamentsOfGames(folderPath string, pool *pgxpool.Pool) []lichess.TournamentRef {
+	tables, err := tablesOf(folderPath)
+	if err != nil {
+		fmt.Println("Error reading directory:", err)
+		return nil
+	}
+
+	var refs []lichess.TournamentRef
+	seen := make(map[string]bool)
+	for _, tableName := range tables {
+		query := fmt.Sprintf("SELECT DISTINCT event FROM %s WHERE tournament_id IS NOT NULL", tableName)
+		if cfg.normalized {
+			query = fmt.Sprintf("SELECT DISTINCT e.name FROM %s g JOIN events e ON e.id = g.event_id WHERE g.tournament_id IS NOT NULL", tableName)
+		}
+		rows, err := pool.Query(context.Background(), query)
+		if err != nil {
+			fmt.Printf("Failed to list tournaments of %s: %s\n", tableName, err)
+			continue
+		}
+		for rows.Next() {
+			var event string
+			if err := rows.Scan(&event); err != nil {
+				break
+			}
+			ref, ok := lichess.ParseEvent(event)
+			if ok && !seen[ref.ID] {
+				seen[ref.ID] = true
+				refs = append(refs, ref)
+			}
+		}
+		rows.Close()
+	}
+	return refs
+}
+
+// statsView is a materialized view of --stats-views
+type statsView struct {
+	suffix  string   // of the games table
+	columns []string // needed in the games table
+	key     string   // unique, for REFRESH CONCURRENTLY
+	sql     string   // the games table as %[1]s
+}
+
+// scoreSQL is white's score of a game
+const scoreSQL = "CASE result WHEN '1-0' THEN 1 WHEN '1/2-1/2' THEN 0.5 WHEN '0-1' THEN 0 END"
+
+var statsViews = []statsView{
+	{"eco_stats", []string{"eco", "result"}, "eco", `
+		SELECT coalesce(eco, '') AS eco, count(*) AS games,
+			count(*) FILTER (WHERE result = '1-0') AS white_wins,
+			count(*) FILTER (WHERE result = '1/2-1/2') AS draws,
+			count(*) FILTER (WHERE result = '0-1') AS black_wins,
+			avg(` + scoreSQL + `) AS white_score
+		FROM %[1]s GROUP BY 1`},
+	{"player_stats", []string{"white", "black", "result", "white_elo", "black_elo"}, "player", `
+		SELECT coalesce(player, '') AS player, count(*) AS games, sum(score) AS points,
+			avg(score) AS score, round(avg(elo)) AS average_elo, max(elo) AS best_elo
+		FROM (
+			SELECT white AS player, white_elo AS elo, ` + scoreSQL + ` AS score FROM %[1]s
+			UNION ALL
+			SELECT black, black_elo, 1 - ` + scoreSQL + ` FROM %[1]s
+		) sides GROUP BY 1`},
+	{"rating_stats", []string{"time_control", "white_elo", "black_elo"}, "time_control, rating", `
+		SELECT coalesce(time_control, '') AS time_control, (white_elo + black_elo) / 200 * 100 AS rating, count(*) AS games
+		FROM %[1]s WHERE white_elo IS NOT NULL AND black_elo IS NOT NULL GROUP BY 1, 2`},
+}
+
+// normalizedViews read the names of --normalized from the dimension tables
+var normalizedViews = map[string]statsView{
+	"eco_stats": {"eco_stats", []string{"opening_id", "result"}, "eco", `
+		SELECT coalesce(o.eco, '') AS eco, count(*) AS games,
+			count(*) FILTER (WHERE result = '1-0') AS white_wins,
+			count(*) FILTER (WHERE result = '1/2-1/2') AS draws,
+			count(*) FILTER (WHERE result = '0-1') AS black_wins,
+			avg(` + scoreSQL + `) AS white_score
+		FROM %[1]s g LEFT JOIN openings o ON o.id = g.opening_id GROUP BY 1`},
+	"player_stats": {"player_stats", []string{"white_id", "black_id", "result", "white_elo", "black_elo"}, "player", `
+		SELECT coalesce(p.name, '') AS player, count(*) AS games, sum(score) AS points,
+			avg(score) AS score, round(avg(elo)) AS average_elo, max(elo) AS best_elo
+		FROM (
+			SELECT white_id AS player_id, white_elo AS elo, ` + scoreSQL + ` AS score FROM %[1]s
+			UNION ALL
+			SELECT black_id, black_elo, 1 - ` + scoreSQL + ` FROM %[1]s
+		) sides LEFT JOIN players p ON p.id = sides.player_id GROUP BY 1`},
+}
+
+// updateStatsViews creates the statistics views of a games table, or
+// refreshes them when they exist. Refreshes don't block readers.
+func updateStatsViews(pool *pgxpool.Pool, tableName string) {
+	ctx := context.Background()
+	for _, view := range statsViews {
+		if normalized, ok := normalizedViews[view.suffix]; ok && cfg.normalized {
+			view = normalized
+		}
+		if slices.ContainsFunc(view.columns, func(name string) bool { return !hasColumn(name) }) {
+			fmt.Printf("Skipping %s of %s, it needs the columns %s\n", view.suffix, tableName, strings.Join(view.columns, ", "))
+			continue
+		}
+
+		name := suffixedName(tableName, view.suffix)
+		started := time.Now()
+		var exists bool
+		err := pool.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", name).Scan(&exists)
+		switch {
+		case err != nil:
+		case exists:
+			refresh := "REFRESH MATERIALIZED VIEW "
+			if cfg.dialect.refreshConcurrently {
+				refresh += "CONCURRENTLY "
+			}
+			_, err = pool.Exec(ctx, refresh+name)
+		default:
+			_, err = pool.Exec(ctx, fmt.Sprintf("CREATE MATERIALIZED VIEW %s AS %s; CREATE UNIQUE INDEX ON %s (%s)",
+				name, fmt.Sprintf(view.sql, tableName), name, view.key))
+		}
+		if err != nil {
+			fmt.Printf("Failed to update %s: %s\n", name, err)
+			continue
+		}
+		fmt.Printf("%s ready in %s\n", name, time.Since(started).Round(time.Second))
+	}
+}
+
+// reparseWorkers is the number of games reparsed at the same time
+const reparseWorkers = 4
+
+// storedPgn is the PGN kept by --raw-pgn in a row
+type storedPgn struct {
+	id   int64
+	data []byte
+}
+
+// reparse parses the PGN kept by --raw-pgn again and updates every column
+// computed from it, e.g. after a parser fix, without reading the files again
+func reparse(folderPath string, pool *pgxpool.Pool) {
+	if cfg.rawPgn == "" {
+		fmt.Println("reparse needs --raw-pgn, as used for the import")
+		return
+	}
+	tables, err := tablesOf(folderPath)
+	if err != nil {
+		fmt.Println("Error reading directory:", err)
+		return
+	}
+	for _, tableName := range tables {
+		reparseTable(pool, tableName)
+	}
+}
+
+func reparseTable(pool *pgxpool.Pool, tableName string) {
+	ctx := context.Background()
+	pgnColumn := "pgn"
+	if cfg.rawPgn == "zstd" {
+		pgnColumn = "pgn_zstd"
+	}
+
+	var reparsed, failed atomic.Int64
+	var wg sync.WaitGroup
+	pgns := make(chan storedPgn, cfg.batchSize)
+	for i := 0; i < reparseWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range pgns {
+				if err := reparseGame(ctx, pool, tableName, p); err != nil {
+					fmt.Printf("Failed to reparse game %d of %s: %s\n", p.id, tableName, err)
+					failed.Add(1)
+					continue
+				}
+				reparsed.Add(1)
+			}
+		}()
+	}
+
+	// Pages by id, so no cursor stays open during the whole run
+	var lastId int64
+	for {
+		rows, err := pool.Query(ctx, fmt.Sprintf("SELECT id, %s FROM %s WHERE id > $1 AND %s IS NOT NULL ORDER BY id LIMIT $2", pgnColumn, tableName, pgnColumn),
+			lastId, cfg.batchSize)
+		if err != nil {
+			fmt.Printf("Failed to read the games of %s: %s\n", tableName, err)
+			break
+		}
+		page, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (storedPgn, error) {
+			var p storedPgn
+			err := row.Scan(&p.id, &p.data)
+			return p, err
+		})
+		if err != nil {
+			fmt.Printf("Failed to read the games of %s: %s\n", tableName, err)
+			break
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, p := range page {
+			pgns <- p
+		}
+		lastId = page[len(page)-1].id
+	}
+	close(pgns)
+	wg.Wait()
+
+	fmt.Printf("Reparsed %d games of %s, %d failed\n", reparsed.Load(), tableName, failed.Load())
+}
+
+// reparseGame replaces the stored game by a new parse of its PGN, matched
+// by source and source_id
+func reparseGame(ctx context.Context, pool *pgxpool.Pool, tableName string, p storedPgn) error {
+	data := p.data
+	if cfg.rawPgn == "zstd" {
+		var err error
+		if data, err = packed.Decompress(data); err != nil {
+			return err
+		}
+	}
+
+	game, err := parseGame(string(data))
+	if err != nil {
+		return err
+	}
+	if err := resolveDimensions(pool, game); err != nil {
+		return err
+	}
+	return storeGame(ctx, pool, tableName, game, true)
+}
+
+// tablesOf lists the games tables of the directories of the folder,
+// only --table when it is set
+func tablesOf(folderPath string) ([]string, error) {
+	if cfg.table != "" {
+		return []string{tableFor("")}, nil
+	}
+	entries, err := os.ReadDir(folderPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var tables []string
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		tableName := tableFor(entry.Name())
+		if entry.IsDir() && !seen[tableName] {
+			seen[tableName] = true
+			tables = append(tables, tableName)
+		}
+	}
+	return tables, nil
+}
+
+// setLogged makes the tables created by --fast-load crash safe again: the
+// table or its partitions. Each is written to the WAL once, much faster than
+// logging every insert.
+func setLogged(pool *pgxpool.Pool, tableName string) error {
+	ctx := context.Background()
+	rows, err := pool.Query(ctx, `SELECT oid::regclass::text FROM pg_class
+		WHERE relpersistence = 'u' AND (oid = $1::regclass OR oid IN (SELECT inhrelid FROM pg_inherits WHERE inhparent = $1::regclass))`, tableName)
+	if err != nil {
+		return err
+	}
+	unlogged, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+
+	started := time.Now()
+	for _, name := range unlogged {
+		if _, err := pool.Exec(ctx, fmt.Sprintf("ALTER TABLE %s SET LOGGED", name)); err != nil {
+			return err
+		}
+	}
+	if len(unlogged) > 0 {
+		fmt.Printf("%s logged in %s\n", tableName, time.Since(started).Round(time.Second))
+	}
+	return nil
+}
+
+// queryIndexes are created by --ensure-indexes, when their columns are selected
+var queryIndexes = [][]string{
+	{"white"}, {"black"}, {"white_id"}, {"black_id"}, // the last two with --normalized
+	{"eco"}, {"opening_id"},
+	{"date"},
+	{"white_elo", "black_elo"},
+}
+
+// ensureIndexes creates the indexes most queries need
+func ensureIndexes(pool *pgxpool.Pool, tableName string) error {
+	started := time.Now()
+	for _, statement := range indexStatements(tableName) {
+		if _, err := pool.Exec(context.Background(), statement); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Indexes of %s ready in %s\n", tableName, time.Since(started).Round(time.Second))
+	return nil
+}
+
+// indexStatements create btree indexes, GIN on positions and tags for
+// containment searches (positions @> '["<fen>"]') and with --trigram-indexes
+// trigram indexes on player names
+func indexStatements(tableName string) []string {
+	var statements []string
+	for _, keys := range queryIndexes {
+		if slices.ContainsFunc(keys, func(name string) bool { return !hasColumn(name) }) {
+			continue
+		}
+		statements = append(statements, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)",
+			suffixedName(tableName, strings.Join(keys, "_")), tableName, strings.Join(keys, ", ")))
+	}
+	operatorClass := ""
+	if cfg.dialect.jsonbPathOps {
+		operatorClass = " jsonb_path_ops"
+	}
+	for _, name := range []string{"positions", "tags"} {
+		if hasColumn(name) {
+			statements = append(statements, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING GIN (%s%s)",
+				suffixedName(tableName, name+"_gin"), tableName, name, operatorClass))
+		}
+	}
+	if cfg.trigramIndexes {
+		if cfg.dialect.extensions { // built into CockroachDB
+			statements = append(statements, "CREATE EXTENSION IF NOT EXISTS pg_trgm")
+		}
+		if cfg.normalized {
+			statements = append(statements, "CREATE INDEX IF NOT EXISTS players_name_trgm ON players USING GIN (name gin_trgm_ops)")
+		}
+		for _, name := range []string{"white", "black"} {
+			if !hasColumn(name) {
+				continue
+			}
+			expression := name
+			if cfg.citextNames {
+				expression = "(" + name + "::text)" // the operator class is for text
+			}
+			statements = append(statements, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING GIN (%s gin_trgm_ops)",
+				suffixedName(tableName, name+"_trgm"), tableName, expression))
+		}
+	}
+	return statements
+}
+
+// storeTournament replaces the tournament and its standings
+func storeTournament(ctx context.Context, pool *pgxpool.Pool, t *lichess.Tournament) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `INSERT INTO tournaments (id, kind, name, starts_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (id) DO UPDATE SET kind = EXCLUDED.kind, name = EXCLUDED.name, starts_at = EXCLUDED.starts_at`,
+		t.ID, t.Kind, t.Name, t.StartsAt)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM tournament_standings WHERE tournament_id = $1", t.ID); err != nil {
+		return err
+	}
+
+	for _, s := range t.Standings {
+		_, err := tx.Exec(ctx, `INSERT INTO tournament_standings (tournament_id, rank, username, title, rating, score, performance)
+			VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT DO NOTHING`,
+			t.ID, s.Rank, s.Username, s.Title, s.Rating, s.Score, s.Performance)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// tableFor returns the quoted table for a directory of the games folder
+func tableFor(dirPath string) string {
+	if cfg.table != "" {
+		return pgx.Identifier{cfg.table}.Sanitize()
+	}
+	return pgx.Identifier{tableNameOf(filepath.Base(dirPath))}.Sanitize()
+}
+
+// maxTableName leaves room for the suffixes of indexes and side tables in
+// the 63 bytes Postgres keeps of a name
+const maxTableName = 48
+
+// tableNameOf makes a table name of a directory name: letters, digits and
+// underscores, so "lichess-2024.01" is stored in lichess_2024_01
+func tableNameOf(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			r = '_'
+		}
+		if b.Len()+utf8.RuneLen(r) > maxTableName {
+			break
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 {
+		return "games"
+	}
+	return b.String()
+}
+
+// tableForFile returns the table a file of the games folder is imported into
+func tableForFile(folderPath string, filePath string) (string, error) {
+	rel, err := filepath.Rel(folderPath, filePath)
+	if err != nil {
+		return "", err
+	}
+
+	dir, _, found := strings.Cut(filepath.ToSlash(rel), "/")
+	if !found || dir == ".." {
+		return "", fmt.Errorf("%s is not in a directory of %s", filePath, folderPath)
+	}
+	return tableFor(dir), nil
+}
+
+// loadHistory opens the state file, nil when disabled or unreadable
+func loadHistory() *progress.History {
+	if cfg.stateFile == "" {
+		return nil
+	}
+
+	history, err := progress.Load(cfg.stateFile)
+	if err != nil {
+		fmt.Printf("Failed to load state file %s: %s\n", cfg.stateFile, err)
+		return nil
+	}
+	return history
+}
+
+// printThroughput compares this run with the rolling 24h history
+func printThroughput(history *progress.History, started time.Time) {
+	rate, errorRate := history.Rate(started)
+	dayRate, dayErrorRate := history.Rate(time.Now().Add(-progress.Window))
+	fmt.Printf("Throughput: %.1f games/s, %.2f%% errors (last 24h: %.1f games/s, %.2f%% errors)\n",
+		rate, errorRate*100, dayRate, dayErrorRate*100)
+}
+
+// dirWorkers is the number of directories imported in parallel
+const dirWorkers = 3
+
+// createTable serializes CREATE TABLE IF NOT EXISTS, which fails when
+// run twice at once on the same table
+var createTable sync.Mutex
+
+// loadedTables are the games tables of the import, under createTable
+var loadedTables = make(map[string]bool)
+
+func processDirectory(dirPath string, pool *pgxpool.Pool, totalProcessed *int, mu *sync.Mutex) {
+	var wg sync.WaitGroup
+	files := make(chan string, 100)
+
+	// Walk through the files in the directory and queue them for processing
+	go func() {
+		defer close(files)
+		err := pgnsource.Walk(dirPath, cfg.dirBatch, func(path string) {
+			files <- path
+		})
+		if err != nil {
+			fmt.Println("Error processing files:", err)
+		}
+	}()
+
+	tableName := tableFor(dirPath)
+
+	// Create table for the current directory. Directories sharing --table
+	// would race to create it, so tables are created one at a time.
+	createTable.Lock()
+	err := output.EnsureSchema(context.Background(), tableOf(tableName))
+	if err == nil {
+		loadedTables[tableName] = true
+	}
+	createTable.Unlock()
+	if err != nil {
+		fmt.Printf("Failed to create table %s: %s\n", tableName, err)
+		return
+	}
+
+	// Create workers to process files in the current directory, sharing
+	// --max-open-files between the directory workers
+	fileWorkers := max(cfg.maxOpenFiles/dirWorkers, 1)
+	for i := 0; i < fileWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			batch := newGameBatch(pool, tableName, cfg.batchSize, totalProcessed, mu)
+			for filePath := range files {
+				processFile(filePath, batch)
+			}
+			batch.flush()
+		}()
+	}
+
+	wg.Wait()
+}
+
+func processFile(filePath string, batch *gameBatch) {
+	// Read file, or every file of a tar archive
+	err := pgnsource.Each(filePath, func(name string, r io.Reader) error {
+		scanner := pgnparse.NewScanner(r)
+		var index int
+
+		for scanner.Scan() {
+			index++
+			processGame(scanner.Text(), name, index, scanner.Offset(), batch)
+		}
+
+		if err := scanner.Err(); err != nil {
+			fmt.Printf("Error reading file %s: %s\n", name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Failed to read file %s: %s\n", filePath, err)
+	}
+}
+
+// outcome tells what processGame did with a game
+type outcome int
+
+const (
+	stored  outcome = iota
+	skipped         // left out on purpose, e.g. by --skip-variants
+	failed          // not stored, worth another try
+	pending         // queued in a batch, stored or not once the batch is flushed
+)
+
+// processGame stores one game, or queues it in the batch. offset is where
+// the game starts in the file, -1 when unknown.
+func processGame(data string, filePath string, index int, offset int64, batch *gameBatch) outcome {
+	data, err := pgnparse.ToUTF8(data, cfg.encoding)
+	if err == nil {
+		err = pgnparse.Validate(data)
+	}
+	if err != nil {
+		rejectGame(filePath, index, offset, err)
+		return failed
+	}
+
+	game, err := parseGame(data)
+	if err != nil {
+		rejectGame(filePath, index, offset, err)
+		return failed
+	}
+
+	if pgnparse.VariantIn(game.Variant, cfg.skipVariants) {
+		return skipped
+	}
+
+	game.SourceFile, game.ImportJobId = filePath, cfg.importId
+	if offset >= 0 {
+		game.SourceOffset = &offset
+	}
+
+	if !checks.Result(data, game.Result, filePath, index) {
+		return skipped
+	}
+
+	if !checks.Elo(game.whiteEloRaw, game.blackEloRaw, filePath, index) {
+		return skipped
+	}
+
+	if cfg.minEloRequired > 0 && !pgnparse.Rated(game.WhiteElo, game.BlackElo, cfg.minEloRequired) {
+		return skipped
+	}
+
+	if cfg.validateMoves && !checks.Moves(data, game.replayErr, filePath, index) {
+		failedGames.Add(1)
+		return failed
+	}
+
+	if err := ensurePartition(batch.tableName, game); err != nil {
+		fmt.Printf("Failed to create the partition of game %d of %s: %s\n", index, filePath, err)
+		importReport.Add(filePath, index, "insert_error", err.Error())
+		failedGames.Add(1)
+		return failed
+	}
+
+	if err := resolveDimensions(batch.pool, game); err != nil {
+		fmt.Printf("Failed to look up the players, event or opening of game %d of %s: %s\n", index, filePath, err)
+		importReport.Add(filePath, index, "insert_error", err.Error())
+		failedGames.Add(1)
+		return failed
+	}
+
+	if cfg.diff {
+		result := applyDiff(batch.pool, batch.tableName, game)
+		if result == stored {
+			batch.stored(game)
+		}
+		return result
+	}
+
+	batch.add(game, filePath, index)
+	return pending
+}
+
+// gameBatch buffers parsed games of one table and loads them with one COPY
+// per --batch-size games. Every file worker has its own.
+type gameBatch struct {
+	pool      *pgxpool.Pool
+	tableName string
+	size      int
+	queued    []queuedGame
+	outcomes  map[gameKey]outcome // what became of every flushed game, only kept when set
+
+	totalProcessed *int
+	mu             *sync.Mutex
+}
+
+type queuedGame struct {
+	game      *Game
+	tableName string
+	file      string
+	index     int
+}
+
+// gameKey identifies a game by its file and index in the file
+type gameKey struct {
+	file  string
+	index int
+}
+
+func newGameBatch(pool *pgxpool.Pool, tableName string, size int, totalProcessed *int, mu *sync.Mutex) *gameBatch {
+	return &gameBatch{pool: pool, tableName: tableName, size: size, totalProcessed: totalProcessed, mu: mu}
+}
+
+// add queues a game and stores the batch once it is full
+func (b *gameBatch) add(game *Game, file string, index int) {
+	b.queued = append(b.queued, queuedGame{game, b.tableName, file, index})
+	if len(b.queued) >= b.size {
+		b.flush()
+	}
+}
+
+// flush stores the queued games in every destination of --backend at
+// once and returns how many were stored. A game failed by any destination
+// isn't counted as stored.
+func (b *gameBatch) flush() int {
+	if len(b.queued) == 0 {
+		return 0
+	}
+	queued := b.queued
+	b.queued = nil
+
+	batch := make([]sink.Record, len(queued))
+	for i, q := range queued {
+		batch[i] = record(q)
+	}
+	failures, err := output.WriteBatch(context.Background(), batch)
+	if err != nil {
+		// Nothing was committed
+		fmt.Printf("Failed to insert %d games: %s\n", len(queued), err)
+		for _, q := range queued {
+			importReport.Add(q.file, q.index, "insert_error", err.Error())
+			b.record(q, failed)
+		}
+		failedGames.Add(int64(len(queued)))
+		return 0
+	}
+
+	for i, q := range queued {
+		err, ok := failures[i]
+		switch {
+		case !ok:
+			b.stored(q.game)
+			b.record(q, stored)
+		case errors.Is(err, sink.ErrDuplicate):
+			fmt.Println("Skipping duplicate game", q.game.Source+":"+q.game.SourceId)
+			b.record(q, skipped)
+		default:
+			fmt.Printf("Failed to insert game %d of %s: %s\n", q.index, q.file, err)
+			importReport.Add(q.file, q.index, "insert_error", err.Error())
+			failedGames.Add(1)
+			b.record(q, failed)
+		}
+	}
+	return len(queued) - len(failures)
+}
+
+// record keeps what became of a flushed game, when outcomes are kept
+func (b *gameBatch) record(q queuedGame, result outcome) {
+	if b.outcomes != nil {
+		b.outcomes[gameKey{q.file, q.index}] = result
+	}
+}
+
+// stored counts a stored game
+func (b *gameBatch) stored(game *Game) {
+	aggregates.Add(pgnparse.Speed(game.TimeControl), game.Eco, pgnparse.Elo(game.WhiteElo), pgnparse.Elo(game.BlackElo))
+
+	b.mu.Lock()
+	*b.totalProcessed++
+	fmt.Printf("Total games processed: %d\n", *b.totalProcessed)
+	b.mu.Unlock()
+}
+
+// rejectGame records a game that could not be parsed in the report and
+// import_errors, or stops the import with --strict
+func rejectGame(filePath string, index int, offset int64, err error) {
+	failedGames.Add(1)
+
+	where := fmt.Sprintf("game %d of %s", index, filePath)
+	message := err.Error()
+	var byteOffset any
+	if offset >= 0 {
+		where += fmt.Sprintf(" (byte %d)", offset)
+		message = fmt.Sprintf("byte %d: %s", offset, err)
+		byteOffset = offset
+	}
+	importReport.Add(filePath, index, "parse_error", message)
+
+	if errorsPool != nil {
+		_, insertErr := errorsPool.Exec(context.Background(),
+			"INSERT INTO import_errors (file, game, byte_offset, error, parser_version) VALUES ($1, $2, $3, $4, $5)",
+			filePath, index, byteOffset, err.Error(), pgnparse.Version)
+		if insertErr != nil {
+			fmt.Println("Failed to record parse error:", insertErr)
+		}
+	}
+
+	if cfg.strict {
+		fmt.Printf("Malformed %s: %s\n", where, err)
+		importReport.Close()
+		os.Exit(1)
+	}
+	fmt.Printf("Skipping malformed %s: %s\n", where, err)
+}
+
+// typedTags are stored in columns of their own, the others go to tags
+var typedTags = map[string]bool{
+	"Opening": true, "Event": true, "EventDate": true, "EventType": true, "Section": true, "Stage": true,
+	"Board": true, "Annotator": true, "Source": true, "Date": true, "UTCTime": true,
+	"White": true, "Black": true, "Result": true, "WhiteElo": true, "BlackElo": true, "ECO": true,
+	"TimeControl": true, "Termination": true, "Variant": true, "Round": true,
+	"WhiteTitle": true, "BlackTitle": true, "WhiteRatingDiff": true, "BlackRatingDiff": true,
+}
+
+func parseGame(data string) (*Game, error) {
+	game := &Game{
+		Positions: []string{}, // Initialize as a slice
+		Pgn:       data,
+	}
+
+	tags, duplicates, err := pgnparse.ParseTags(data, cfg.duplicateTags)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.normalizeTags {
+		originals := pgnparse.NormalizeTags(tags)
+		if cfg.keepOriginals {
+			game.ExtraTags = originals
+		}
+	}
+	tags = pgnparse.ProcessTags(tags, cfg.tagProcessors)
+
+	var site, link, rawDate string // Chess.com keeps the game URL in Link
+	var utcDate, utcTime string    // see PlayedAt
+	for _, tag := range tags {
+		value := tag.Value
+
+		switch tag.Name {
+		case "Opening":
+			game.Opening = value
+		case "Event":
+			game.Event = value
+		case "EventDate":
+			if eventDate, ok := pgnparse.ParseDate(value).Time(); ok {
+				game.EventDate = &eventDate
+			}
+		case "EventType":
+			game.EventType = value
+		case "Section":
+			game.Section = value
+		case "Stage":
+			game.Stage = value
+		case "Board":
+			game.Board = convertToOptionalInt(value)
+		case "Annotator":
+			game.Annotator = value
+		case "Source":
+			game.DataSource = value
+		case "Site":
+			site = value
+		case "Link":
+			link = value
+		case "Date":
+			rawDate = value
+			game.DateParts = pgnparse.ParseDate(value)
+			if parsedDate, ok := game.DateParts.Time(); ok {
+				game.Date = &parsedDate
+			}
+		case "UTCDate":
+			utcDate = value
+		case "UTCTime":
+			utcTime = value
+			parsedTime, err := time.Parse("15:04:05", value)
+			if err == nil {
+				game.Time = &parsedTime
+			}
+		case "White":
+			game.White = value
+		case "Black":
 			game.Black = value
 		case "Result":
-			game.Result = value
+			game.ResultRaw = value
 		case "WhiteElo":
-			game.WhiteElo = convertToInt(value)
+			game.WhiteElo, game.whiteEloRaw = pgnparse.ParseElo(value), value
 		case "BlackElo":
-			game.BlackElo = convertToInt(value)
+			game.BlackElo, game.blackEloRaw = pgnparse.ParseElo(value), value
 		case "ECO":
 			game.Eco = value
 		case "TimeControl":
 			game.TimeControl = value
 		case "Termination":
 			game.Termination = value
+		case "Variant":
+			game.Variant = value
+		case "Round":
+			game.Round = value
+		case "WhiteTitle":
+			game.WhiteTitle = value
+		case "BlackTitle":
+			game.BlackTitle = value
+		case "WhiteRatingDiff":
+			game.WhiteRatingDiff = convertToOptionalInt(value)
+		case "BlackRatingDiff":
+			game.BlackRatingDiff = convertToOptionalInt(value)
 		}
 	}
 
-	game.Moves = parseMovesFromPGN(data)
-	game.MovesCount = getMoveCount(data)
-	game.Positions = parsePositionsFromPGN(data) // Now returns []string
+	if len(duplicates) > 0 {
+		fmt.Printf("Duplicate tags in game %s: %s\n", site, strings.Join(duplicates, ", "))
+	}
 
-	return game
-}
+	for _, tag := range tags {
+		if typedTags[tag.Name] {
+			continue
+		}
+		if game.Tags == nil {
+			game.Tags = make(map[string]string)
+		}
+		if _, kept := game.Tags[tag.Name]; !kept {
+			game.Tags[tag.Name] = tag.Value
+		}
+	}
+
+	// UTCDate goes with UTCTime, Date may be local
+	date := utcDate
+	if !pgnparse.ParseDate(date).Complete() && rawDate != "" {
+		date = rawDate
+	}
+	if playedAt, _, ok := pgnparse.ApproxPlayedAt(date, utcTime); ok {
+		game.PlayedAt = &playedAt
+	}
+
+	// OTB PGNs often only have the ECO code
+	if game.Opening == "" && cfg.openingFromEco {
+		game.Opening, _ = eco.Name(game.Eco)
+	}
+
+	if tournament, ok := lichess.ParseEvent(game.Event); ok {
+		game.Tournament = tournament.ID
+	}
+
+	result, ok := pgnparse.NormalizeResult(game.ResultRaw)
+	if !ok && game.ResultRaw != "" {
+		fmt.Printf("Unknown result %q in game %s\n", game.ResultRaw, site)
+	}
+	game.Result = result
+
+	moves := pgnparse.Moves(data)
+
+	// Other variants would produce illegal standard-chess positions
+	var summary replay.Summary
+	if pgnparse.IsStandardVariant(game.Variant) && needsReplay() {
+		summary, game.replayErr = replay.Summarize(pgnparse.SANs(moves))
+		if game.replayErr != nil && !cfg.validateMoves {
+			fmt.Println("Failed to replay game:", game.replayErr)
+		}
+	}
+	if cfg.normalizeSAN {
+		// Store and hash the same SAN whatever notation the source used
+		for i, san := range summary.SANs {
+			moves[i].SAN = san
+		}
+	}
+
+	id, ok := gameid.FromURL(site)
+	if !ok {
+		id, ok = gameid.FromURL(link)
+	}
+	if !ok {
+		id = gameid.Hash(game.White, game.Black, rawDate, game.Round, pgnparse.SANs(moves))
+	}
+	game.Source, game.SourceId = id.Source, id.Value
+	game.MovesHash = gameid.MovesHash(pgnparse.SANs(moves))
+	game.ContentHash = gameid.ContentHash(game.White, game.Black, rawDate, pgnparse.SANs(moves))
+	if id.Source == "lichess" {
+		game.LichessId = id.Value
+	}
+
+	// After the ID, so hashed IDs don't depend on the option
+	if cfg.normalizeNames {
+		username := id.Source != "hash" // online handles keep their underscores
+		game.WhiteRaw, game.BlackRaw = game.White, game.Black
+		game.White = pgnparse.NormalizeName(game.White, username)
+		game.Black = pgnparse.NormalizeName(game.Black, username)
+	}
 
-func getMoveCount(data string) int {
-	re := regexp.MustCompile(`\d+\.\s+([^\d]+)`)
-	matches := re.FindAllStringSubmatch(data, -1)
+	game.Moves = pgnparse.SANs(moves)
+	game.PlyCount = len(moves)
+	game.MovesCount = (len(moves) + 1) / 2
 
-	moves := make([]string, len(matches))
-	for i, match := range matches {
-		move := strings.TrimSpace(match[1])
-		moveParts := strings.Fields(move)
-		moves[i] = strings.Join(moveParts, " ")
+	game.Positions, game.UciMoves, game.FinalFen = summary.Positions, summary.UCI, summary.FinalFEN
+	game.Zobrist = summary.Zobrist
+	game.MaterialSignature, game.MaxImbalance = summary.MaterialSignature, summary.MaxImbalance
+	game.TerminationDetail = pgnparse.TerminationDetail(game.Termination, game.Result, summary.Ending)
+	if hasColumn("game_moves") {
+		game.GameMoves = pgnparse.Details(moves, game.UciMoves)
 	}
+	game.Evals, game.Clocks = evalsAndClocks(moves)
+	game.Analyzed = game.Evals != nil
 
-	return len(moves) - 1
+	if cfg.features && hasColumn("features") {
+		game.Features = features.Vector(features.Game{
+			WhiteElo:    pgnparse.Elo(game.WhiteElo),
+			BlackElo:    pgnparse.Elo(game.BlackElo),
+			TimeControl: game.TimeControl,
+			Eco:         game.Eco,
+			Moves:       moves,
+		})
+	}
+
+	return game, nil
+}
+
+// rawName is the name as read, NULL when normalization didn't change it
+func rawName(raw, name string) any {
+	if raw == name {
+		return nil
+	}
+	return raw
 }
 
-func parseMovesFromPGN(gameString string) string {
-	lines := strings.Split(gameString, "\n")
-	var moves []string
-	for _, line := range lines {
-		if len(line) > 0 && line[0] >= '0' && line[0] <= '9' {
-			moves = append(moves, line)
+// evalsAndClocks reads the %eval and %clk annotations of every move, nil
+// when no move has one
+func evalsAndClocks(moves []pgnparse.Move) ([]*float32, []*int32) {
+	evals := make([]*float32, len(moves))
+	clocks := make([]*int32, len(moves))
+	var hasEval, hasClock bool
+	for i, move := range moves {
+		if eval, ok := pgnparse.Eval(move.Comment); ok {
+			e := float32(eval)
+			evals[i], hasEval = &e, true
 		}
+		if clock, ok := pgnparse.Clock(move.Comment); ok {
+			c := int32(math.Round(clock * 100))
+			clocks[i], hasClock = &c, true
+		}
+	}
+	if !hasEval {
+		evals = nil
 	}
+	if !hasClock {
+		clocks = nil
+	}
+	return evals, clocks
+}
+
+// column is one column of the games table
+type column struct {
+	name  string
+	ddl   string
+	value func(game *Game) any
+}
+
+// columns in table order
+var columns = []column{
+	{"lichess_id", "TEXT UNIQUE", func(g *Game) any {
+		if g.LichessId == "" {
+			return nil
+		}
+		return g.LichessId
+	}},
+	{"source", "TEXT", func(g *Game) any { return g.Source }},
+	{"source_id", "TEXT", func(g *Game) any { return g.SourceId }},
+	{"game_id", "TEXT", func(g *Game) any { return g.Source + ":" + g.SourceId }},
+	{"source_file", "TEXT", func(g *Game) any { return nullIfEmpty(g.SourceFile) }},
+	{"source_offset", "BIGINT", func(g *Game) any { return g.SourceOffset }},
+	{"import_job_id", "TEXT", func(g *Game) any { return nullIfEmpty(g.ImportJobId) }},
+	{"moves_hash", "TEXT", func(g *Game) any { return g.MovesHash }},
+	{"content_hash", "TEXT", func(g *Game) any { return g.ContentHash }},
+	{"opening", "TEXT", func(g *Game) any { return g.Opening }},
+	{"eco", "TEXT REFERENCES eco_codes (code)", func(g *Game) any {
+		if _, ok := eco.Name(g.Eco); !ok {
+			return nil // "?", "" and made up codes
+		}
+		return g.Eco
+	}},
+	{"result", "TEXT", func(g *Game) any { return string(g.Result) }},
+	{"result_raw", "TEXT", func(g *Game) any { return g.ResultRaw }},
+	{"white", "TEXT", func(g *Game) any { return g.White }},
+	{"black", "TEXT", func(g *Game) any { return g.Black }},
+	{"white_elo", "INTEGER", func(g *Game) any { return g.WhiteElo }},
+	{"black_elo", "INTEGER", func(g *Game) any { return g.BlackElo }},
+	{"positions", "JSONB", func(g *Game) any {
+		positions, _ := json.Marshal(g.Positions)
+		return positions
+	}},
+	{"zobrist", "BIGINT[]", func(g *Game) any { return g.Zobrist }},
+	{"moves", "TEXT[]", func(g *Game) any { return g.Moves }},
+	{"uci_moves", "TEXT[]", func(g *Game) any { return g.UciMoves }},
+	{"evals", "REAL[]", func(g *Game) any { return g.Evals }},
+	{"clocks", "INTEGER[]", func(g *Game) any { return g.Clocks }},
+	{"analyzed", "BOOLEAN", func(g *Game) any { return g.Analyzed }},
+	{"game_moves", "JSONB", func(g *Game) any {
+		gameMoves, _ := json.Marshal(g.GameMoves)
+		return gameMoves
+	}},
+	{"moves_count", "INTEGER", func(g *Game) any { return g.MovesCount }},
+	{"ply_count", "INTEGER", func(g *Game) any { return g.PlyCount }},
+	{"material_signature", "TEXT", func(g *Game) any {
+		if g.MaterialSignature == "" {
+			return nil
+		}
+		return g.MaterialSignature
+	}},
+	{"max_imbalance", "SMALLINT", func(g *Game) any { return g.MaxImbalance }},
+	{"final_fen", "TEXT", func(g *Game) any {
+		if g.FinalFen == "" {
+			return nil
+		}
+		return g.FinalFen
+	}},
+	{"event", "TEXT", func(g *Game) any { return g.Event }},
+	{"tournament_id", "TEXT", func(g *Game) any {
+		if g.Tournament == "" {
+			return nil
+		}
+		return g.Tournament
+	}},
+	{"event_date", "DATE", func(g *Game) any { return g.EventDate }},
+	{"event_type", "TEXT", func(g *Game) any { return nullIfEmpty(g.EventType) }},
+	{"section", "TEXT", func(g *Game) any { return nullIfEmpty(g.Section) }},
+	{"stage", "TEXT", func(g *Game) any { return nullIfEmpty(g.Stage) }},
+	{"board", "INTEGER", func(g *Game) any { return g.Board }},
+	{"annotator", "TEXT", func(g *Game) any { return nullIfEmpty(g.Annotator) }},
+	{"data_source", "TEXT", func(g *Game) any { return nullIfEmpty(g.DataSource) }},
+	{"time_control", "TEXT", func(g *Game) any { return g.TimeControl }},
+	{"termination", "TEXT", func(g *Game) any { return g.Termination }},
+	{"termination_detail", "TEXT", func(g *Game) any { return g.TerminationDetail }},
+	{"variant", "TEXT", func(g *Game) any { return g.Variant }},
+	{"round", "TEXT", func(g *Game) any { return g.Round }},
+	{"white_title", "TEXT", func(g *Game) any { return g.WhiteTitle }},
+	{"black_title", "TEXT", func(g *Game) any { return g.BlackTitle }},
+	{"white_rating_diff", "INTEGER", func(g *Game) any { return g.WhiteRatingDiff }},
+	{"black_rating_diff", "INTEGER", func(g *Game) any { return g.BlackRatingDiff }},
+	{"features", "DOUBLE PRECISION[]", func(g *Game) any { return g.Features }},
+	{"extra_tags", "JSONB", func(g *Game) any {
+		if len(g.ExtraTags) == 0 {
+			return nil
+		}
+		extraTags, _ := json.Marshal(g.ExtraTags)
+		return extraTags
+	}},
+	{"tags", "JSONB", func(g *Game) any {
+		if len(g.Tags) == 0 {
+			return nil
+		}
+		tags, _ := json.Marshal(g.Tags)
+		return tags
+	}},
+	{"date", "DATE", func(g *Game) any { return g.Date }},
+	{"date_year", "SMALLINT", func(g *Game) any { return g.DateParts.Year }},
+	{"date_month", "SMALLINT", func(g *Game) any { return g.DateParts.Month }},
+	{"date_day", "SMALLINT", func(g *Game) any { return g.DateParts.Day }},
+	{"time", "TIME", func(g *Game) any { return g.Time }},
+}
 
-	movesStr := strings.Join(moves, " ")
-	movesStr = regexp.MustCompile(`\{[^}]*\}|\b\d+\.|\d+-\d+|\.`).ReplaceAllString(movesStr, "")
-	return strings.Join(strings.Fields(movesStr), " ")
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
 }
 
-func clearFromNotations(gameString string) string {
-	// Remove all text inside curly braces including the braces
-	re := regexp.MustCompile(`\{[^}]*\}`)
-	cleanedString := re.ReplaceAllString(gameString, "")
+// identityColumns are always kept because inserts conflict on them
+var identityColumns = map[string]bool{"lichess_id": true, "source": true, "source_id": true, "game_id": true}
+
+// selectColumns applies --columns: either the columns to keep or "-name"
+// entries removing columns from the full set. identityColumns are always kept.
+func selectColumns(value string) ([]column, error) {
+	names := env.SplitList(value)
+	if len(names) == 0 {
+		return columns, nil
+	}
+
+	known := make(map[string]bool, len(columns))
+	for _, c := range columns {
+		known[c.name] = true
+	}
 
-	// Remove extra new lines or spaces
-	cleanedString = strings.TrimSpace(cleanedString)
-	cleanedString = strings.ReplaceAll(cleanedString, "\n\n", "\n")
+	keep := map[string]bool{}
+	drop := map[string]bool{}
+	for _, name := range names {
+		name, dropped := strings.CutPrefix(name, "-")
+		if !known[name] {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		if dropped {
+			drop[name] = true
+		} else {
+			keep[name] = true
+		}
+	}
 
-	// Remove all patterns like " 1...", " 2...", ..., " n..."
-	reNumber := regexp.MustCompile(` \d+\.\.\.`)
-	cleanedString = reNumber.ReplaceAllString(cleanedString, "")
+	var selected []column
+	for _, c := range columns {
+		if identityColumns[c.name] {
+			selected = append(selected, c)
+			continue
+		}
+		if drop[c.name] {
+			continue
+		}
+		if len(keep) > 0 && !keep[c.name] {
+			continue
+		}
+		selected = append(selected, c)
+	}
+	return selected, nil
+}
 
-	// Remove extra spaces at the end
-	cleanedString = strings.ReplaceAll(cleanedString, "  ", " ")
-	cleanedString = strings.ReplaceAll(cleanedString, ". ", ".")
+// normalizedColumns replaces the player, event and opening columns by
+// references to the players, events and openings tables (--normalized)
+func normalizedColumns(selected []column) []column {
+	var normalized []column
+	var opening bool
+	for _, c := range selected {
+		switch c.name {
+		case "white":
+			normalized = append(normalized, column{"white_id", "INTEGER REFERENCES players (id)", func(g *Game) any { return g.WhiteId }})
+		case "black":
+			normalized = append(normalized, column{"black_id", "INTEGER REFERENCES players (id)", func(g *Game) any { return g.BlackId }})
+		case "event":
+			normalized = append(normalized, column{"event_id", "INTEGER REFERENCES events (id)", func(g *Game) any { return g.EventId }})
+		case "eco", "opening":
+			if !opening {
+				opening = true
+				normalized = append(normalized, column{"opening_id", "INTEGER REFERENCES openings (id)", func(g *Game) any { return g.OpeningId }})
+			}
+		default:
+			normalized = append(normalized, c)
+		}
+	}
+	return normalized
+}
 
-	// Remove trailing spaces
-	cleanedString = strings.TrimSpace(cleanedString)
+// dimension is a table of names stored once (players, events, openings),
+// with the ids already looked up
+type dimension struct {
+	table   string
+	columns []string // unique together
 
-	return cleanedString
+	mu  sync.Mutex
+	ids map[string]int32
 }
 
-func parsePositionsFromPGN(data string) []string {
-	// Clean the PGN data from notations
-	cleanedData := clearFromNotations(data)
+// dimensionCache is the number of ids a dimension remembers, the cache
+// is emptied when it is full
+const dimensionCache = 1 << 20
 
-	ps := pgn.NewPGNScanner(strings.NewReader(cleanedData)) // Convert string to io.Reader
+var (
+	players  = &dimension{table: "players", columns: []string{"name"}}
+	events   = &dimension{table: "events", columns: []string{"name"}}
+	openings = &dimension{table: "openings", columns: []string{"eco", "name"}}
+)
 
-	var positions []string // Slice to store FEN positions
+// resolveDimensions looks up or creates the rows of the game's players,
+// event and opening
+func resolveDimensions(pool *pgxpool.Pool, game *Game) error {
+	if !cfg.normalized {
+		return nil
+	}
 
-	// Iterate over all games in the PGN data
-	for ps.Next() {
-		// Scan the next game
-		game, err := ps.Scan()
+	var err error
+	if hasColumn("white_id") {
+		if game.WhiteId, err = players.id(pool, game.White); err != nil {
+			return err
+		}
+	}
+	if hasColumn("black_id") {
+		if game.BlackId, err = players.id(pool, game.Black); err != nil {
+			return err
+		}
+	}
+	if hasColumn("event_id") {
+		if game.EventId, err = events.id(pool, game.Event); err != nil {
+			return err
+		}
+	}
+	if hasColumn("opening_id") {
+		if game.OpeningId, err = openings.id(pool, game.Eco, game.Opening); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// id returns the id of the row with these values, inserting it if needed,
+// nil when the values are all empty
+func (d *dimension) id(pool *pgxpool.Pool, values ...string) (*int32, error) {
+	key := strings.Join(values, "\x00")
+	if strings.Trim(key, "\x00") == "" {
+		return nil, nil
+	}
+
+	d.mu.Lock()
+	id, ok := d.ids[key]
+	d.mu.Unlock()
+	if ok {
+		return &id, nil
+	}
+
+	args := make([]any, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	// The select doesn't see a row inserted by a transaction committed while
+	// the insert waited for it, which the second try finds
+	for try := 0; try < 2; try++ {
+		err := pool.QueryRow(context.Background(), d.getOrCreateSQL(), args...).Scan(&id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
 		if err != nil {
-			fmt.Println("Failed with scanner:", err)
+			return nil, err
+		}
+
+		d.mu.Lock()
+		if d.ids == nil || len(d.ids) >= dimensionCache {
+			d.ids = make(map[string]int32)
+		}
+		d.ids[key] = id
+		d.mu.Unlock()
+		return &id, nil
+	}
+	return nil, fmt.Errorf("%s row %q not found after inserting it", d.table, strings.Join(values, " "))
+}
+
+// getOrCreateSQL inserts the row unless it exists and returns its id
+func (d *dimension) getOrCreateSQL() string {
+	placeholders := make([]string, len(d.columns))
+	conditions := make([]string, len(d.columns))
+	for i, c := range d.columns {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		conditions[i] = fmt.Sprintf("%s = $%d", c, i+1)
+	}
+	return fmt.Sprintf(`
+		WITH inserted AS (
+			INSERT INTO %s (%s) VALUES (%s) ON CONFLICT DO NOTHING RETURNING id
+		)
+		SELECT id FROM inserted
+		UNION ALL
+		SELECT id FROM %s WHERE %s
+		LIMIT 1
+	`, d.table, strings.Join(d.columns, ", "), strings.Join(placeholders, ", "), d.table, strings.Join(conditions, " AND "))
+}
+
+// withoutColumn returns the columns without the named one
+func withoutColumn(columns []column, name string) []column {
+	var kept []column
+	for _, c := range columns {
+		if c.name != name {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// hasColumn reports whether a column was selected
+func hasColumn(name string) bool {
+	for _, c := range cfg.columns {
+		if c.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// createTableSQL creates the table with the selected columns and adds
+// the ones missing from tables created by older versions
+func createTableSQL(tableName string) string {
+	var definitions, alters []string
+	for _, c := range cfg.columns {
+		definitions = append(definitions, c.name+" "+c.ddl)
+		alters = append(alters, fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s;", tableName, c.name, c.ddl))
+	}
+
+	// Keys of a partitioned table must include the partition key. Its
+	// partitions hold the rows, so they are the ones created unlogged.
+	primaryKey, partitioning, persistence := "id", "", ""
+	if cfg.hypertable {
+		primaryKey += ", played_at"
+	} else if cfg.partitionBy != "" {
+		primaryKey += ", " + partitionKey()
+		partitioning = "PARTITION BY LIST (" + partitionKey() + ")"
+	} else if cfg.fastLoad {
+		persistence = "UNLOGGED "
+	}
+
+	return fmt.Sprintf(`
+		%s
+		CREATE %sTABLE IF NOT EXISTS %s (
+			id %s,
+			%s,
+			created_at TIMESTAMPTZ DEFAULT now(),
+			updated_at TIMESTAMPTZ DEFAULT now(),
+			PRIMARY KEY (%s)
+		) %s;
+		%s
+		%s
+		CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (%s);
+		%s
+		%s
+		%s
+	`, citextExtensionSQL()+timescaleExtensionSQL(), persistence, tableName, cfg.dialect.idType, strings.Join(definitions, ",\n\t\t\t"), primaryKey, partitioning, strings.Join(alters, "\n\t\t"), movesArraySQL(tableName)+citextSQL(tableName),
+		suffixedName(tableName, "source_id"), tableName, uniqueKey("source, source_id"), contentIndexSQL(tableName), positionsTableSQL(tableName), hypertableSQL(tableName))
+}
+
+// timescaleExtensionSQL installs TimescaleDB for --table-layout=hypertable
+func timescaleExtensionSQL() string {
+	if !cfg.hypertable {
+		return ""
+	}
+	return "CREATE EXTENSION IF NOT EXISTS timescaledb;"
+}
+
+// hypertableSQL turns a new games table into a hypertable chunked on
+// played_at, and with --compress-after adds the compression policy once
+// (compressed chunks can't change their settings)
+func hypertableSQL(tableName string) string {
+	if !cfg.hypertable {
+		return ""
+	}
+	sql := fmt.Sprintf("SELECT create_hypertable(%s, 'played_at', chunk_time_interval => %s::interval, if_not_exists => TRUE);",
+		quoteLiteral(tableName), quoteLiteral(cfg.chunkInterval))
+	if cfg.compressAfter == "" {
+		return sql
+	}
+	settings := "timescaledb.compress, timescaledb.compress_orderby = 'played_at DESC'"
+	if cfg.compressSegmentBy != "" {
+		settings += ", timescaledb.compress_segmentby = " + quoteLiteral(cfg.compressSegmentBy)
+	}
+	return sql + fmt.Sprintf(`
+		DO $$ BEGIN
+			IF NOT EXISTS (SELECT 1 FROM timescaledb_information.jobs WHERE proc_name = 'policy_compression' AND hypertable_schema = current_schema() AND hypertable_name = %s) THEN
+				ALTER TABLE %s SET (%s);
+				PERFORM add_compression_policy(%s, %s::interval);
+			END IF;
+		END $$;`, quoteLiteral(unquoted(tableName)), tableName, settings, quoteLiteral(tableName), quoteLiteral(cfg.compressAfter))
+}
+
+// quoteLiteral quotes a string for SQL built with Sprintf
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// movesArraySQL converts the space-joined moves of tables created by older
+// versions to an array. It rewrites the table, once.
+func movesArraySQL(tableName string) string {
+	if !hasColumn("moves") || !cfg.dialect.doBlocks {
+		return ""
+	}
+	return fmt.Sprintf(`DO $$ BEGIN
+			IF EXISTS (SELECT 1 FROM pg_attribute WHERE attrelid = '%s'::regclass AND attname = 'moves' AND atttypid = 'text'::regtype) THEN
+				ALTER TABLE %s ALTER COLUMN moves TYPE TEXT[] USING string_to_array(moves, ' ');
+			END IF;
+		END $$;`, strings.ReplaceAll(tableName, "'", "''"), tableName)
+}
+
+// citextExtensionSQL installs citext, before the table uses it
+func citextExtensionSQL() string {
+	if !cfg.citextNames {
+		return ""
+	}
+	return "CREATE EXTENSION IF NOT EXISTS citext;"
+}
+
+// citextSQL converts the text player columns of existing tables to citext
+// (--case-insensitive-names). The values stay, the indexes are rebuilt but
+// the trigram ones, which need text: --ensure-indexes creates them again.
+func citextSQL(tableName string) string {
+	var sql string
+	for _, name := range []string{"white", "black"} {
+		if !cfg.citextNames || !hasColumn(name) {
 			continue
 		}
+		sql += fmt.Sprintf(`
+		DO $$ BEGIN
+			IF EXISTS (SELECT 1 FROM pg_attribute WHERE attrelid = '%s'::regclass AND attname = '%s' AND atttypid = 'text'::regtype) THEN
+				DROP INDEX IF EXISTS %s;
+				ALTER TABLE %s ALTER COLUMN %s TYPE CITEXT;
+			END IF;
+		END $$;`, strings.ReplaceAll(tableName, "'", "''"), name, suffixedName(tableName, name+"_trgm"), tableName, name)
+	}
+	return sql
+}
+
+// partitionKey is the column the games table is partitioned on
+func partitionKey() string {
+	if cfg.hypertable {
+		return "played_at"
+	}
+	if cfg.partitionBy == "month" {
+		return "played_month"
+	}
+	return "source"
+}
 
-		// Create a new board to get FEN positions
-		b := pgn.NewBoard()
-		for _, move := range game.Moves {
-			// Make the move on the board
-			b.MakeMove(move)
-			// Get the FEN string for each move in the game
-			fen := b.String()
-			// Store the FEN string in the slice
-			positions = append(positions, fen)
+// uniqueKey adds the partition key to the columns of a unique index. A game
+// always falls in one partition, so it stays unique in the table.
+func uniqueKey(columns string) string {
+	if cfg.partitionBy == "" && !cfg.hypertable || strings.Contains(columns, partitionKey()) {
+		return columns
+	}
+	return columns + ", " + partitionKey()
+}
+
+// partitionedColumns adds played_month, the partition key of
+// --partition-by=month, or played_at, the time of hypertables, and drops the
+// UNIQUE of lichess_id, which can't include the partition key (source and
+// source_id stay unique)
+func partitionedColumns(selected []column) []column {
+	partitioned := make([]column, 0, len(selected)+1)
+	for _, c := range selected {
+		if c.name == "lichess_id" {
+			c.ddl = "TEXT"
 		}
+		partitioned = append(partitioned, c)
+	}
+	if cfg.partitionBy == "month" {
+		partitioned = append(partitioned, column{"played_month", "INTEGER NOT NULL DEFAULT 0", func(g *Game) any { return playedMonth(g) }})
 	}
+	if cfg.hypertable {
+		// Chunks need a time, undated games share the first one
+		partitioned = append(partitioned, column{"played_at", "TIMESTAMPTZ NOT NULL DEFAULT 'epoch'", func(g *Game) any {
+			if g.PlayedAt == nil {
+				return time.Unix(0, 0).UTC()
+			}
+			return *g.PlayedAt
+		}})
+	}
+	return partitioned
+}
 
-	return positions
+// playedMonth is the month of the Date tag as 202401, 0 when unknown
+func playedMonth(game *Game) int {
+	if game.DateParts.Year == nil || game.DateParts.Month == nil {
+		return 0
+	}
+	return *game.DateParts.Year*100 + *game.DateParts.Month
 }
 
-func convertToInt(s string) int {
+// partitions lists the partitions created or found during the run
+var partitions = make(map[string]bool)
+
+// ensurePartition creates the partition of the game's month or source the
+// first time a game falls in it
+func ensurePartition(tableName string, game *Game) error {
+	if cfg.partitionBy == "" {
+		return nil
+	}
+
+	var suffix, value string
+	if cfg.partitionBy == "month" {
+		month := playedMonth(game)
+		suffix, value = "undated", "0"
+		if month != 0 {
+			suffix, value = fmt.Sprintf("%04d_%02d", month/100, month%100), fmt.Sprint(month)
+		}
+	} else {
+		suffix, value = tableNameOf(game.Source), "'"+strings.ReplaceAll(game.Source, "'", "''")+"'"
+	}
+	partition := suffixedName(tableName, suffix)
+
+	createTable.Lock()
+	defer createTable.Unlock()
+	if partitions[partition] {
+		return nil
+	}
+	persistence := ""
+	if cfg.fastLoad {
+		persistence = "UNLOGGED "
+	}
+	statement := fmt.Sprintf("CREATE %sTABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES IN (%s)", persistence, partition, tableName, value)
+	if err := output.EnsurePartition(context.Background(), statement); err != nil {
+		return err
+	}
+	partitions[partition] = true
+	return nil
+}
+
+// contentIndexSQL makes content_hash unique with --dedupe-content, so inserts
+// of a game already stored from another file or site do nothing
+func contentIndexSQL(tableName string) string {
+	if !cfg.dedupeContent {
+		return ""
+	}
+	return fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (%s);", suffixedName(tableName, "content_hash"), tableName, uniqueKey("content_hash"))
+}
+
+// positionsTableSQL creates the side table of --positions-storage=table:
+// the FENs of a game in order, positionsChunk per row
+func positionsTableSQL(tableName string) string {
+	if cfg.positionsAside != "table" {
+		return ""
+	}
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			game_id TEXT,
+			chunk INTEGER,
+			fens TEXT,
+			PRIMARY KEY (game_id, chunk)
+		);`, suffixedName(tableName, "positions"))
+}
+
+// suffixedName returns the quoted name of an index or side table of a quoted table
+func suffixedName(tableName string, suffix string) string {
+	return pgx.Identifier{unquoted(tableName) + "_" + suffix}.Sanitize()
+}
+
+// unquoted returns the name of a table quoted by tableFor
+func unquoted(tableName string) string {
+	return strings.ReplaceAll(strings.Trim(tableName, "\""), "\"\"", "\"")
+}
+
+// insertSQL inserts one game into the selected columns
+func insertSQL(tableName string) string {
+	names := make([]string, len(cfg.columns))
+	placeholders := make([]string, len(cfg.columns))
+	for i, c := range cfg.columns {
+		names[i] = c.name
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+
+	return fmt.Sprintf(`
+		INSERT INTO %s (%s)
+		VALUES (%s)
+		%s
+	`, tableName, strings.Join(names, ", "), strings.Join(placeholders, ", "), onConflictSQL())
+}
+
+// mutableColumns can change when a dump is republished: the termination,
+// result and moves, with everything computed from them
+var mutableColumns = map[string]bool{
+	"termination": true, "termination_detail": true, "result": true, "result_raw": true,
+	"moves": true, "moves_hash": true, "uci_moves": true, "game_moves": true, "moves_count": true, "ply_count": true,
+	"evals": true, "clocks": true, "analyzed": true,
+	"positions": true, "positions_oid": true, "zobrist": true, "final_fen": true, "material_signature": true, "max_imbalance": true,
+}
+
+// onConflictSQL skips games already stored, or with --on-conflict=update
+// refreshes their mutable columns
+func onConflictSQL() string {
+	if cfg.onConflict != "update" {
+		return "ON CONFLICT DO NOTHING"
+	}
+	var assignments []string
+	for _, c := range cfg.columns {
+		if mutableColumns[c.name] {
+			assignments = append(assignments, fmt.Sprintf("%s = EXCLUDED.%s", c.name, c.name))
+		}
+	}
+	assignments = append(assignments, "updated_at = now()")
+	return fmt.Sprintf("ON CONFLICT (%s) DO UPDATE SET %s", uniqueKey("source, source_id"), strings.Join(assignments, ", "))
+}
+
+// provenanceColumns say which file and run stored the game
+var provenanceColumns = map[string]bool{"source_file": true, "source_offset": true, "import_job_id": true}
+
+// updateSQL replaces the selected columns of the game with the same source and source_id
+func updateSQL(tableName string) string {
+	assignments := make([]string, len(cfg.columns))
+	for i, c := range cfg.columns {
+		assignments[i] = fmt.Sprintf("%s = $%d", c.name, i+1)
+		if provenanceColumns[c.name] {
+			// reparse doesn't know where the game came from
+			assignments[i] = fmt.Sprintf("%s = coalesce($%d, %s)", c.name, i+1, c.name)
+		}
+	}
+
+	n := len(cfg.columns)
+	return fmt.Sprintf(`
+		UPDATE %s SET %s, updated_at = now()
+		WHERE source = $%d AND source_id = $%d
+	`, tableName, strings.Join(assignments, ", "), n+1, n+2)
+}
+
+// applyDiff stores the game when it is new or its moves changed since the
+// stored version. Unchanged games are skipped.
+func applyDiff(pool *pgxpool.Pool, tableName string, game *Game) outcome {
+	ctx := context.Background()
+
+	var storedHash *string
+	err := pool.QueryRow(ctx, fmt.Sprintf("SELECT moves_hash FROM %s WHERE source = $1 AND source_id = $2", tableName),
+		game.Source, game.SourceId).Scan(&storedHash)
+
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		err = storeGame(ctx, pool, tableName, game, false)
+		if err == nil {
+			delta.added.Add(1)
+		}
+	case err != nil:
+	case storedHash != nil && *storedHash == game.MovesHash:
+		delta.unchanged.Add(1)
+		return skipped
+	default:
+		err = storeGame(ctx, pool, tableName, game, true)
+		if err == nil {
+			delta.changed.Add(1)
+			fmt.Println("Changed game", game.Source+":"+game.SourceId)
+		}
+	}
+
+	if err != nil {
+		fmt.Println("Failed to apply diff in PostgreSQL:", err)
+		failedGames.Add(1)
+		return failed
+	}
+	return stored
+}
+
+// insertArgs returns the values of the selected columns
+func insertArgs(game *Game) []any {
+	args := make([]any, len(cfg.columns))
+	for i, c := range cfg.columns {
+		args[i] = c.value(game)
+	}
+	return args
+}
+
+// needsReplay reports whether games are replayed: for --validate-moves,
+// --normalize-san or a selected column computed by replaying the game
+func needsReplay() bool {
+	if cfg.validateMoves || cfg.positionsAside != "" || cfg.normalizeSAN {
+		return true
+	}
+	for _, name := range []string{"positions", "uci_moves", "game_moves", "zobrist", "final_fen", "termination_detail", "material_signature", "max_imbalance"} {
+		if hasColumn(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// convertToOptionalInt returns nil for missing or non-numeric values
+func convertToOptionalInt(s string) *int {
 	var n int
-	fmt.Sscanf(s, "%d", &n)
-	return n
+	if _, err := fmt.Sscanf(s, "%d", &n); err != nil {
+		return nil
+	}
+	return &n
+}
+
+// printStats prints the aggregates and saves them to the stats file
+func printStats() {
+	snapshot := aggregates.Snapshot()
+	fmt.Print(snapshot)
+
+	if cfg.statsFile == "" {
+		return
+	}
+	if err := snapshot.WriteFile(cfg.statsFile); err != nil {
+		fmt.Println("Failed to write stats file:", err)
+	}
 }
@@ -0,0 +1,71 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"importGames/gamecheck"
+	"importGames/report"
+)
+
+// TestImportCorpus imports the bundled corpus into a throwaway Postgres
+// container with the default options and checks the stored games
+func TestImportCorpus(t *testing.T) {
+	ctx := context.Background()
+	url := startPostgres(t)
+	folder := writeCorpus(t)
+
+	if err := loadConfig(nil); err != nil {
+		t.Fatal(err)
+	}
+	// The corpus has a malformed game, which must be skipped
+	cfg.strict = false
+	importReport, _ = report.Open("")
+	checks = gamecheck.Checks{
+		ResultMismatch: cfg.resultMismatch,
+		EloCheck:       cfg.eloCheck,
+		EloLimits:      cfg.eloLimits,
+		Report:         importReport,
+	}
+
+	pool, err := connect(ctx, url)
+	if err != nil {
+		t.Fatal("Failed to connect to PostgreSQL:", err)
+	}
+	defer pool.Close()
+
+	if !migrate(pool) {
+		t.Fatal("Failed to migrate")
+	}
+	if output, err = openOutput(pool); err != nil {
+		t.Fatal("Failed to create output:", err)
+	}
+
+	importFolder(folder, pool)
+	closeOutput()
+
+	tableName := tableFor(selftestTable)
+	var count int
+	if err := pool.QueryRow(ctx, "SELECT count(*) FROM "+tableName).Scan(&count); err != nil {
+		t.Fatal("Failed to count games:", err)
+	}
+
+	verifyCorpus(t, count, func(c selftestCheck) (string, error) {
+		if !hasColumn(c.Column) {
+			return c.Want, nil // left out with --columns
+		}
+		var value *string
+		white := "white = $1"
+		if cfg.normalized {
+			white = "white_id = (SELECT id FROM players WHERE name = $1)"
+		}
+		err := pool.QueryRow(ctx, fmt.Sprintf("SELECT %s::text FROM %s WHERE %s", c.Column, tableName, white), c.White).Scan(&value)
+		if err != nil || value == nil {
+			return "", err
+		}
+		return *value, nil
+	})
+}
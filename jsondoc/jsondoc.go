@@ -0,0 +1,87 @@
+// Package jsondoc writes stored games as plain JSON, for the destinations
+// that aren't MongoDB: the document has the same fields in the same order,
+// with dates as RFC 3339 strings, ObjectIDs as hex and binaries as base64
+package jsondoc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Marshal encodes what bson.Marshal encodes, a Game or a mapped document
+func Marshal(doc any) ([]byte, error) {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var d bson.D
+	if err := bson.Unmarshal(data, &d); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := write(&buf, d); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func write(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case primitive.D:
+		buf.WriteByte('{')
+		for i, e := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, _ := json.Marshal(e.Key)
+			buf.Write(key)
+			buf.WriteByte(':')
+			if err := write(buf, e.Value); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+		return nil
+	case primitive.A:
+		buf.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := write(buf, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	case primitive.DateTime:
+		return write(buf, v.Time().UTC().Format(time.RFC3339Nano))
+	case primitive.ObjectID:
+		return write(buf, v.Hex())
+	case primitive.Binary:
+		return write(buf, v.Data)
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			buf.WriteString("null") // JSON has no NaN
+			return nil
+		}
+	case nil, primitive.Null, primitive.Undefined:
+		buf.WriteString("null")
+		return nil
+	case string, bool, int32, int64, []byte:
+	default:
+		return fmt.Errorf("no JSON for %T", v)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	buf.Write(data)
+	return nil
+}
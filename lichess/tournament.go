@@ -0,0 +1,168 @@
+// Package lichess reads tournament standings from the Lichess API
+package lichess
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// BaseURL of the Lichess API
+var BaseURL = "https://lichess.org"
+
+var tournamentRe = regexp.MustCompile(`lichess\.org/(tournament|swiss)/(\w+)`)
+
+// TournamentRef identifies an arena or swiss tournament
+type TournamentRef struct {
+	Kind string // "arena" or "swiss"
+	ID   string
+}
+
+// ParseEvent extracts the tournament from an Event tag like
+// "Rated Blitz tournament https://lichess.org/tournament/yc1WW2Ox",
+// false when the game was not played in an arena or swiss tournament
+func ParseEvent(event string) (TournamentRef, bool) {
+	match := tournamentRe.FindStringSubmatch(event)
+	if match == nil {
+		return TournamentRef{}, false
+	}
+	if match[1] == "tournament" {
+		return TournamentRef{Kind: "arena", ID: match[2]}, true
+	}
+	return TournamentRef{Kind: "swiss", ID: match[2]}, true
+}
+
+// ParseRef reads a tournament given on the command line: a tournament URL,
+// "swiss:ID" or a bare arena ID
+func ParseRef(value string) TournamentRef {
+	if ref, ok := ParseEvent(value); ok {
+		return ref
+	}
+	if id, found := strings.CutPrefix(value, "swiss:"); found {
+		return TournamentRef{Kind: "swiss", ID: id}
+	}
+	return TournamentRef{Kind: "arena", ID: strings.TrimPrefix(value, "arena:")}
+}
+
+// Tournament is an arena or swiss tournament with its final standings
+type Tournament struct {
+	ID        string     `json:"id" bson:"_id"`
+	Kind      string     `json:"kind" bson:"kind"`
+	Name      string     `json:"name" bson:"name"`
+	StartsAt  time.Time  `json:"startsAt" bson:"startsAt"`
+	Standings []Standing `json:"standings" bson:"standings"`
+}
+
+// Standing is the final place of one player
+type Standing struct {
+	Rank        int     `json:"rank" bson:"rank"`
+	Username    string  `json:"username" bson:"username"`
+	Title       string  `json:"title,omitempty" bson:"title,omitempty"`
+	Rating      int     `json:"rating" bson:"rating"`
+	Score       float64 `json:"score" bson:"score"` // arena score or swiss points
+	Performance int     `json:"performance" bson:"performance"`
+}
+
+// Client calls the Lichess API one request at a time, as Lichess asks
+type Client struct {
+	Token string // optional personal API token
+	HTTP  *http.Client
+}
+
+// Tournament fetches the tournament and its standings
+func (c *Client) Tournament(ref TournamentRef) (*Tournament, error) {
+	path := "tournament"
+	if ref.Kind == "swiss" {
+		path = "swiss"
+	}
+
+	var info struct {
+		FullName string `json:"fullName"` // arena
+		Name     string `json:"name"`     // swiss
+		StartsAt any    `json:"startsAt"` // arena: ISO string, swiss: ISO string or millis
+	}
+	if err := c.get(fmt.Sprintf("%s/api/%s/%s", BaseURL, path, ref.ID), func(resp *http.Response) error {
+		return json.NewDecoder(resp.Body).Decode(&info)
+	}); err != nil {
+		return nil, err
+	}
+
+	tournament := &Tournament{ID: ref.ID, Kind: ref.Kind, Name: info.FullName, StartsAt: parseTime(info.StartsAt)}
+	if tournament.Name == "" {
+		tournament.Name = info.Name
+	}
+
+	// Results are streamed as one JSON object per line
+	err := c.get(fmt.Sprintf("%s/api/%s/%s/results", BaseURL, path, ref.ID), func(resp *http.Response) error {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			var line struct {
+				Standing
+				Points float64 `json:"points"` // swiss
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				return err
+			}
+			if ref.Kind == "swiss" {
+				line.Score = line.Points
+			}
+			tournament.Standings = append(tournament.Standings, line.Standing)
+		}
+		return scanner.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tournament, nil
+}
+
+// get calls read with a successful response, waiting a minute when rate limited
+func (c *Client) get(url string, read func(resp *http.Response) error) error {
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		if c.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.Token)
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < 3 {
+			resp.Body.Close()
+			time.Sleep(time.Minute)
+			continue
+		}
+
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s: %s", url, resp.Status)
+		}
+		return read(resp)
+	}
+}
+
+func parseTime(value any) time.Time {
+	switch v := value.(type) {
+	case string:
+		t, _ := time.Parse(time.RFC3339, v)
+		return t
+	case float64:
+		return time.UnixMilli(int64(v)).UTC()
+	}
+	return time.Time{}
+}
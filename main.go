@@ -1,177 +1,1785 @@
 package main
 
 import (
-	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"math/rand"
 	"os"
-	"path/filepath"
 	"regexp"
+	"runtime/debug"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"importGames/deadletter"
+	"importGames/env"
+	"importGames/features"
+	"importGames/fieldmap"
+	"importGames/gamecheck"
+	"importGames/gameid"
+	"importGames/lichess"
+	"importGames/mongoconn"
+	"importGames/packed"
+	"importGames/pgnparse"
+	"importGames/pgnsource"
+	"importGames/progress"
+	"importGames/replay"
+	"importGames/report"
+	"importGames/routing"
+	"importGames/sink"
+	_ "importGames/sink/bq"
+	_ "importGames/sink/es"
+	_ "importGames/sink/mongodb"
+	_ "importGames/sink/nats"
+	_ "importGames/sink/ndjson"
+	_ "importGames/sink/neo4j"
+	_ "importGames/sink/parquet"
+	_ "importGames/sink/redis"
+	"importGames/stats"
 
 	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// Game struct represents a chess game
-type Game struct {
-	Opening     string `bson:"opening"`
-	Eco         string `bson:"eco"`
-	Result      string `bson:"result"`
-	White       string `bson:"white"`
-	Black       string `bson:"black"`
-	WhiteElo    int    `bson:"whiteElo"`
-	BlackElo    int    `bson:"blackElo"`
-	Moves       string `bson:"moves"`
-	MovesCount  int    `bson:"moves_count"`
-	Event       string `bson:"event"`
-	TimeControl string `bson:"time_control"`
-	Termination string `bson:"termination"`
-	Date        string `bson:"date"`
-	Time        string `bson:"time"`
-	Site        string `bson:"site"`
+// Game struct represents a chess game
+type Game struct {
+	// gameId or contentHash with --id, an ObjectID carrying playedAt with
+	// --collection-layout=clustered (see timeOrderedID), else set by the driver
+	ID any `bson:"_id,omitempty"`
+
+	Source   string `bson:"source"`   // lichess, chesscom or hash
+	SourceId string `bson:"sourceId"` // game ID within the source, unique with source
+	GameId   string `bson:"gameId"`   // "source:sourceId"
+
+	MovesHash   string `bson:"movesHash"`   // fingerprint of the main line, see diff-import
+	ContentHash string `bson:"contentHash"` // players, date and moves, see --dedupe-content
+
+	ImportId   string `bson:"importId"`   // run that stored the game, see rollback
+	ImportFile string `bson:"importFile"` // file the game was read from
+
+	Opening   string                `bson:"opening"`
+	Variation string                `bson:"variation"`
+	Eco       string                `bson:"eco"`
+	Result    pgnparse.Result       `bson:"result"`    // canonical: 1-0, 0-1, 1/2-1/2 or *
+	ResultRaw string                `bson:"resultRaw"` // Result tag as found in the file
+	White     string                `bson:"white"`
+	Black     string                `bson:"black"`
+	WhiteElo  *int                  `bson:"whiteElo,omitempty"` // absent when unrated
+	BlackElo  *int                  `bson:"blackElo,omitempty"`
+	Moves     []pgnparse.MoveDetail `bson:"moves"`               // san, uci, ply, clock, eval, comment
+	UciMoves  []string              `bson:"uci_moves,omitempty"` // e2e4 style, standard games only
+	Zobrist   []int64               `bson:"zobrist,omitempty"`   // hash of the position after every move, standard games only
+	Positions []string              `bson:"positions,omitempty"` // FEN after every move, standard games only (--positions)
+
+	// moves and positions with --compress-moves, read them with packed.Decode
+	MovesZstd     *primitive.Binary `bson:"movesZstd,omitempty"`
+	PositionsZstd *primitive.Binary `bson:"positionsZstd,omitempty"`
+
+	MovesCount int    `bson:"moves_count"` // full moves
+	PlyCount   int    `bson:"plyCount"`
+	FinalFen   string `bson:"finalFen,omitempty"` // position after the last move, standard games only
+
+	MaterialSignature string     `bson:"materialSignature,omitempty"` // final material, "KRPPvKRP"
+	MaxImbalance      int        `bson:"maxImbalance"`                // largest material difference in pawns, negative when black was ahead
+	Event             string     `bson:"event"`
+	Tournament        string     `bson:"tournamentId,omitempty"` // Lichess arena or swiss ID from the Event tag
+	EventDate         *time.Time `bson:"eventDate,omitempty"`    // complete EventDate tags only
+	EventType         string     `bson:"eventType,omitempty"`    // game, match, tourn, swiss, team, k.o., ...
+	Section           string     `bson:"section,omitempty"`
+	Stage             string     `bson:"stage,omitempty"`
+	Board             *int       `bson:"board,omitempty"`
+	Annotator         string     `bson:"annotator,omitempty"`
+	DataSource        string     `bson:"dataSource,omitempty"` // Source tag, not to be confused with source
+	TimeControl       string     `bson:"time_control"`
+	Termination       string     `bson:"termination"`
+	TerminationDetail string     `bson:"termination_detail"` // normalized, see pgnparse.TerminationDetail
+	Variant           string     `bson:"variant"`
+
+	Round           string `bson:"round"`
+	WhiteTitle      string `bson:"whiteTitle"`
+	BlackTitle      string `bson:"blackTitle"`
+	WhiteRatingDiff *int   `bson:"whiteRatingDiff,omitempty"`
+	BlackRatingDiff *int   `bson:"blackRatingDiff,omitempty"`
+
+	Date     string     `bson:"date"`
+	Time     string     `bson:"time"`
+	PlayedAt *time.Time `bson:"playedAt,omitempty"` // UTCDate (or Date) + UTCTime, start of the period for partial dates
+
+	PlayedAtPrecision string `bson:"playedAtPrecision,omitempty"` // time, day, month or year
+	Site              string `bson:"site"`
+
+	// Date, UTCDate and UTCTime as found in the file, and original values of
+	// tags changed by normalization (--keep-original-tags)
+	ExtraTags map[string]string `bson:"extra_tags,omitempty"`
+
+	// Only with --features, layout described by features.Names
+	Features []float64 `bson:"features,omitempty"`
+
+	// Original PGN in the <collection>_pgn GridFS bucket (--raw-pgn): the
+	// game's own file, or the source file and where the game starts in it
+	RawPgn       primitive.ObjectID `bson:"rawPgn,omitempty"`
+	RawPgnOffset *int64             `bson:"rawPgnOffset,omitempty"`
+
+	pgn                      string // uploaded once the game is stored
+	replayErr                error  // illegal move found replaying the game, see --validate-moves
+	whiteEloRaw, blackEloRaw string // Elo tags as read, for --elo-check
+}
+
+// config holds the import options (flags default to their .env values)
+type config struct {
+	duplicateTags     pgnparse.DuplicatePolicy
+	skipVariants      []string
+	stateFile         string
+	reportFile        string
+	resultMismatch    string
+	validateMoves     bool
+	invalidGames      string
+	quarantineFile    string
+	features          bool
+	encoding          string
+	normalizeTags     bool
+	keepOriginals     bool
+	eloCheck          string
+	eloLimits         pgnparse.EloLimits
+	minEloRequired    int
+	routes            []routing.Rule
+	schemaValidation  string
+	shardKey          string // "", hashed or range
+	rawPgn            string // "", game or file
+	ratingsCollection string
+	searchIndex       string // Atlas Search index name
+	retention         time.Duration
+	perMonth          bool
+	compressMoves     bool
+	eventsCollection  string
+	verifySample      float64 // fraction of games read back
+	eventsSize        int64   // MB
+	cappedSize        int64   // MB
+	cappedMax         int64
+	id                string // objectid, gameId or contentHash
+	fields            fieldmap.Map
+	initialChunks     int
+	statsFile         string
+	maxOpenFiles      int
+	dirBatch          int
+	layout            string
+	uciMoves          bool
+	zobrist           bool
+	positions         bool
+	tournaments       []string
+	diff              bool // diff-import
+	strict            bool
+	batchSize         int
+	upsert            bool
+	ensureIndexes     bool
+	dedupeContent     bool
+	importId          string
+	importIdSet       bool
+	importFile        string   // rollback
+	backends          []string // registered destinations, mongodb by default
+
+	mongo         mongoconn.Config // MongoDB client
+	normalizeSAN  bool
+	tagProcessors []pgnparse.TagProcessor
+}
+
+var cfg config
+
+// failedGames counts games that could not be parsed or stored
+var failedGames atomic.Int64
+
+// importReport lists problems with individual games
+var importReport *report.Report
+
+// aggregates are running statistics of the stored games
+var aggregates = stats.New()
+
+// delta counts what diff-import did
+var delta struct {
+	added, changed, unchanged atomic.Int64
+}
+
+// parseErrors receives the games that could not be parsed, when importing
+var parseErrors *mongo.Collection
+
+// ratings receives a compact record of every stored game, with --ratings-collection
+var ratings *mongo.Collection
+
+// events receives a slim event per stored game, with --events-collection
+var events *mongo.Collection
+
+// quarantine receives games with illegal moves when --invalid-games=quarantine
+var quarantine *deadletter.Queue
+
+// checks are run on every game before it is stored
+var checks gamecheck.Checks
+
+func loadConfig(args []string) error {
+	duplicateTags := flag.String("duplicate-tags", env.String("DUPLICATE_TAGS", "last"), "value kept for repeated tags in a game: first, last or reject")
+	skipVariants := flag.String("skip-variants", env.String("SKIP_VARIANTS", ""), "comma separated variants to leave out, e.g. Crazyhouse,Atomic")
+	stateFile := flag.String("state-file", env.String("STATE_FILE", ".import_state.json"), "file keeping the last 24h of throughput history, empty to disable")
+	reportFile := flag.String("report-file", env.String("REPORT_FILE", "import_report.jsonl"), "JSON lines file listing problems with individual games, empty to only count them")
+	resultMismatch := flag.String("result-mismatch", env.String("RESULT_MISMATCH", "flag"), "games whose movetext terminator differs from the Result tag: flag (report and import), skip or ignore")
+	validateMoves := flag.Bool("validate-moves", env.Bool("VALIDATE_MOVES", false), "replay every game with a chess rules engine and leave out games with illegal moves")
+	invalidGames := flag.String("invalid-games", env.String("INVALID_GAMES", "reject"), "what to do with games with illegal moves: reject or quarantine")
+	quarantineFile := flag.String("quarantine-file", env.String("QUARANTINE_FILE", "quarantine.jsonl"), "JSON lines file receiving quarantined games")
+	withFeatures := flag.Bool("features", env.Bool("FEATURES", false), "store a numeric feature vector per game for ML (see features.Names)")
+	encoding := flag.String("encoding", env.String("ENCODING", "auto"), "input encoding: auto (UTF-8, else Windows-1252), utf-8, latin1 or windows-1252")
+	normalizeTags := flag.Bool("normalize-tags", env.Bool("NORMALIZE_TAGS", true), "trim, collapse spaces, NFC-normalize and strip control characters from tag values")
+	flag.BoolVar(&cfg.normalizeSAN, "normalize-san", env.Bool("NORMALIZE_SAN", true), "store the moves of standard games in canonical SAN (O-O, check marks, no e.p.), whatever notation the file uses")
+	tagProcessors := flag.String("tag-processors", env.String("TAG_PROCESSORS", ""), "comma separated tag processors run on every game, e.g. drop-placeholders,titles-from-names")
+	keepOriginals := flag.Bool("keep-original-tags", env.Bool("KEEP_ORIGINAL_TAGS", false), "keep the original value of normalized tags in extra_tags")
+	eloCheck := flag.String("elo-check", env.String("ELO_CHECK", "skip"), "games with impossible ratings: skip (report, don't import), flag (report and import) or off")
+	flag.IntVar(&cfg.eloLimits.Min, "min-elo", env.Int("MIN_ELO", 100), "lowest plausible rating")
+	flag.IntVar(&cfg.eloLimits.Max, "max-elo", env.Int("MAX_ELO", 3500), "highest plausible rating")
+	flag.IntVar(&cfg.minEloRequired, "min-elo-required", env.Int("MIN_ELO_REQUIRED", 0), "skip games where a player is unrated or rated below this, 0 to import all")
+	flag.IntVar(&cfg.eloLimits.MaxGap, "max-elo-gap", env.Int("MAX_ELO_GAP", 1500), "largest plausible rating difference between the players, 0 to disable")
+	flag.StringVar(&cfg.statsFile, "stats-file", env.String("STATS_FILE", ""), "JSON file receiving the aggregates of the imported games")
+	flag.IntVar(&cfg.maxOpenFiles, "max-open-files", env.Int("MAX_OPEN_FILES", 64), "number of PGN files read at the same time")
+	flag.IntVar(&cfg.dirBatch, "dir-batch", env.Int("DIR_BATCH", 1000), "directory entries read at a time when listing input folders")
+	flag.BoolVar(&cfg.zobrist, "zobrist", env.Bool("ZOBRIST", true), "store the 64-bit Zobrist hash of every position of standard games (zobrist)")
+	flag.BoolVar(&cfg.positions, "positions", env.Bool("POSITIONS", false), "store the FEN of every position of standard games (positions), several KB per game")
+	flag.BoolVar(&cfg.uciMoves, "uci-moves", env.Bool("UCI_MOVES", true), "also store the moves of standard games in UCI notation (uci_moves)")
+	tournaments := flag.String("tournaments", env.String("TOURNAMENTS", ""), "import-tournaments: comma separated tournament URLs or IDs (swiss:ID for swiss), default all tournaments of the imported games")
+	flag.IntVar(&cfg.batchSize, "batch-size", env.Int("BATCH_SIZE", 1000), "games inserted per InsertMany")
+	flag.BoolVar(&cfg.upsert, "upsert", env.Bool("UPSERT", false), "replace games already stored (matched by source and sourceId) instead of skipping them")
+	flag.BoolVar(&cfg.ensureIndexes, "ensure-indexes", env.Bool("ENSURE_INDEXES", false), "create the usual query indexes (eco, opening, players, ratings, date, gameId) after the import")
+	flag.BoolVar(&cfg.dedupeContent, "dedupe-content", env.Bool("DEDUPE_CONTENT", false), "make contentHash (players, date and moves) unique, skipping the same game from other files or sites")
+	flag.StringVar(&cfg.importId, "import-id", env.String("IMPORT_ID", ""), "ID stored in importId on every game of this run (default: a new one), or the import to roll back")
+	flag.StringVar(&cfg.importFile, "import-file", env.String("IMPORT_FILE", ""), "rollback: only delete the games of this file")
+	backends := flag.String("backend", env.String("BACKEND", "mongodb"), "comma separated destinations of the games, written in one pass: mongodb, elasticsearch (also OpenSearch), ndjson (a file), bigquery, nats (JetStream), parquet, redis or neo4j")
+	cfg.mongo.RegisterFlags()
+	sink.RegisterFlags()
+	routes := flag.String("routes", env.String("ROUTES", ""), "rules sending games to other collections, e.g. speed=bullet:games_bullet,elo=2200-:games_master")
+	flag.StringVar(&cfg.schemaValidation, "schema-validation", env.String("SCHEMA_VALIDATION", "off"), "$jsonSchema validator on the collection: off, strict (every write) or moderate (not updates of invalid documents)")
+	fieldMap := flag.String("field-map", env.String("FIELD_MAP", ""), "rename stored fields, e.g. whiteElo=white_elo,moves_count=movesCount, or a JSON file of such pairs")
+	flag.StringVar(&cfg.id, "id", env.String("ID", "objectid"), "_id of the game documents: objectid, gameId (source:sourceId) or contentHash")
+	flag.Int64Var(&cfg.cappedSize, "capped-size", int64(env.Int("CAPPED_SIZE", 0)), "create the collection capped at this many MB, evicting the oldest games once full, 0 for uncapped")
+	flag.Int64Var(&cfg.cappedMax, "capped-max", int64(env.Int("CAPPED_MAX", 0)), "with --capped-size, also keep at most this many games, 0 for no limit")
+	flag.Float64Var(&cfg.verifySample, "verify-sample", env.Float("VERIFY_SAMPLE", 0), "fraction of stored games read back and compared field by field with what was sent, e.g. 0.001, 0 to disable")
+	flag.StringVar(&cfg.eventsCollection, "events-collection", env.String("EVENTS_COLLECTION", ""), "capped collection receiving a slim event (gameId, players, eco, result) per stored game, for consumers tailing new imports")
+	flag.Int64Var(&cfg.eventsSize, "events-size", int64(env.Int("EVENTS_SIZE", 64)), "size in MB of the events collection when the importer creates it")
+	flag.BoolVar(&cfg.compressMoves, "compress-moves", env.Bool("COMPRESS_MOVES", false), "store moves and positions as zstd-compressed binaries (movesZstd, positionsZstd), see packed.Decode")
+	flag.BoolVar(&cfg.perMonth, "collection-per-month", env.Bool("COLLECTION_PER_MONTH", false), "store games in a collection per played month, <collection>_2024_01, created on first use")
+	retention := flag.String("retention", env.String("RETENTION", ""), "delete games automatically once playedAt is older than this, e.g. 365d or 720h, empty to keep them")
+	flag.StringVar(&cfg.searchIndex, "search-index", env.String("SEARCH_INDEX", ""), "name of an Atlas Search index over players, events and openings to create after importing, empty for none")
+	flag.StringVar(&cfg.ratingsCollection, "ratings-collection", env.String("RATINGS_COLLECTION", ""), "also write a compact rating record of every game with a date into this time series collection")
+	flag.StringVar(&cfg.rawPgn, "raw-pgn", env.String("RAW_PGN", ""), "keep the original PGN in GridFS: game (one file per game), file (the whole source file) or empty")
+	flag.StringVar(&cfg.shardKey, "shard-key", env.String("SHARD_KEY", ""), "shard the collection on gameId before importing: hashed or range, empty for unsharded")
+	flag.IntVar(&cfg.initialChunks, "initial-chunks", env.Int("INITIAL_CHUNKS", 0), "chunks an empty collection is pre-split into with --shard-key=hashed, 0 for the server default")
+	flag.BoolVar(&cfg.strict, "strict", env.Bool("STRICT", false), "abort on the first malformed game instead of skipping it")
+	layout := flag.String("collection-layout", env.String("COLLECTION_LAYOUT", "plain"), "layout of a newly created collection: plain, clustered (by playedAt) or timeseries (monthly buckets on playedAt)")
+	flag.CommandLine.Parse(args)
+
+	policy, err := pgnparse.ParseDuplicatePolicy(*duplicateTags)
+	if err != nil {
+		return err
+	}
+	cfg.duplicateTags = policy
+	cfg.skipVariants = env.SplitList(*skipVariants)
+	cfg.tournaments = env.SplitList(*tournaments)
+	cfg.stateFile = *stateFile
+	cfg.reportFile = *reportFile
+
+	switch *resultMismatch {
+	case "flag", "skip", "ignore":
+		cfg.resultMismatch = *resultMismatch
+	default:
+		return fmt.Errorf("unknown result mismatch policy %q", *resultMismatch)
+	}
+
+	cfg.validateMoves = *validateMoves
+	cfg.features = *withFeatures
+
+	if err := pgnparse.CheckEncoding(*encoding); err != nil {
+		return err
+	}
+	cfg.encoding = *encoding
+	cfg.normalizeTags = *normalizeTags
+	cfg.keepOriginals = *keepOriginals
+	if cfg.tagProcessors, err = pgnparse.TagProcessors(env.SplitList(*tagProcessors)); err != nil {
+		return err
+	}
+
+	switch *eloCheck {
+	case "skip", "flag", "off":
+		cfg.eloCheck = *eloCheck
+	default:
+		return fmt.Errorf("unknown elo check policy %q", *eloCheck)
+	}
+
+	cfg.quarantineFile = *quarantineFile
+	switch *invalidGames {
+	case "reject", "quarantine":
+		cfg.invalidGames = *invalidGames
+	default:
+		return fmt.Errorf("unknown invalid games policy %q", *invalidGames)
+	}
+
+	if cfg.maxOpenFiles < 1 || cfg.dirBatch < 1 || cfg.batchSize < 1 {
+		return fmt.Errorf("--max-open-files, --dir-batch and --batch-size must be at least 1")
+	}
+
+	if err := cfg.mongo.Parse(); err != nil {
+		return err
+	}
+
+	if cfg.fields, err = fieldmap.Parse(*fieldMap); err != nil {
+		return err
+	}
+
+	switch cfg.rawPgn {
+	case "", "game", "file":
+	default:
+		return fmt.Errorf("unknown raw PGN mode %q", cfg.rawPgn)
+	}
+
+	switch cfg.schemaValidation {
+	case "off", "strict", "moderate":
+	default:
+		return fmt.Errorf("unknown schema validation %q", cfg.schemaValidation)
+	}
+
+	if cfg.routes, err = routing.Parse(*routes); err != nil {
+		return err
+	}
+
+	cfg.importIdSet = cfg.importId != ""
+	if !cfg.importIdSet {
+		cfg.importId = primitive.NewObjectID().Hex()
+	}
+
+	switch *layout {
+	case "plain", "clustered", "timeseries":
+		cfg.layout = *layout
+	default:
+		return fmt.Errorf("unknown collection layout %q", *layout)
+	}
+	if *retention != "" {
+		if cfg.retention, err = env.ParseDuration(*retention); err != nil || cfg.retention <= 0 {
+			return fmt.Errorf("invalid retention %q", *retention)
+		}
+		if cfg.layout == "clustered" {
+			return fmt.Errorf("--retention needs --collection-layout=plain or timeseries")
+		}
+	}
+
+	// Capped collections can't be sharded, have no TTL and games can't grow
+	if cfg.cappedSize > 0 && (cfg.layout != "plain" || cfg.upsert || cfg.shardKey != "" || cfg.retention > 0) {
+		return fmt.Errorf("--capped-size needs --collection-layout=plain and no --upsert, --shard-key or --retention")
+	}
+	if cfg.verifySample < 0 || cfg.verifySample > 1 {
+		return fmt.Errorf("--verify-sample must be between 0 and 1")
+	}
+	if cfg.eventsSize < 1 {
+		return fmt.Errorf("--events-size must be at least 1")
+	}
+	if cfg.cappedSize < 0 || cfg.cappedMax < 0 || cfg.cappedMax > 0 && cfg.cappedSize == 0 {
+		return fmt.Errorf("--capped-max needs --capped-size, and both must be positive")
+	}
+
+	switch cfg.id {
+	case "objectid":
+	case "gameId", "contentHash":
+		if cfg.layout != "plain" {
+			return fmt.Errorf("--id=%s needs --collection-layout=plain", cfg.id)
+		}
+	default:
+		return fmt.Errorf("unknown id %q", cfg.id)
+	}
+	// Clustered _ids can't be replaced and time series have no unique index
+	if cfg.upsert && cfg.layout != "plain" {
+		return fmt.Errorf("--upsert needs --collection-layout=plain")
+	}
+
+	switch cfg.shardKey {
+	case "":
+	case "hashed", "range":
+		if cfg.layout != "plain" || cfg.dedupeContent {
+			return fmt.Errorf("--shard-key needs --collection-layout=plain and no --dedupe-content")
+		}
+	default:
+		return fmt.Errorf("unknown shard key %q", cfg.shardKey)
+	}
+
+	for _, backend := range env.SplitList(*backends) {
+		if _, ok := sink.Lookup(backend); !ok {
+			return fmt.Errorf("unknown backend %q", backend)
+		}
+		if !slices.Contains(cfg.backends, backend) {
+			cfg.backends = append(cfg.backends, backend)
+		}
+	}
+	switch {
+	case len(cfg.backends) == 0:
+		return fmt.Errorf("--backend needs a destination")
+	case slices.Contains(cfg.backends, "parquet") && cfg.compressMoves:
+		return fmt.Errorf("--backend=parquet needs the moves, not --compress-moves")
+	}
+	mongoOnly := cfg.layout != "plain" || cfg.id != "objectid" || len(cfg.routes) > 0 || cfg.perMonth || cfg.rawPgn != "" ||
+		cfg.shardKey != "" || cfg.cappedSize > 0 || cfg.retention > 0 || cfg.ratingsCollection != "" || cfg.eventsCollection != "" ||
+		cfg.searchIndex != "" || cfg.verifySample > 0 || cfg.schemaValidation != "off"
+	if mongoOnly && !slices.Equal(cfg.backends, []string{"mongodb"}) {
+		return fmt.Errorf("the MongoDB collection options (--collection-layout, --id, --routes, --raw-pgn, ...) need --backend=mongodb alone")
+	}
+
+	return nil
+}
+
+func main() {
+	if err := godotenv.Load(); err != nil {
+		fmt.Println("No .env file found")
+	}
+
+	// Optional command before the flags
+	command, args := "import", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	if err := loadConfig(args); err != nil {
+		fmt.Println("Invalid config:", err)
+		return
+	}
+
+	var err error
+	importReport, err = report.Open(cfg.reportFile)
+	if err != nil {
+		fmt.Println("Failed to create report file:", err)
+		return
+	}
+
+	if cfg.validateMoves && cfg.invalidGames == "quarantine" && command == "import" {
+		quarantine, err = deadletter.Open(cfg.quarantineFile)
+		if err != nil {
+			fmt.Println("Failed to open quarantine file:", err)
+			return
+		}
+		defer quarantine.Close()
+	}
+	checks = gamecheck.Checks{
+		ResultMismatch: cfg.resultMismatch,
+		EloCheck:       cfg.eloCheck,
+		EloLimits:      cfg.eloLimits,
+		Report:         importReport,
+		Quarantine:     quarantine,
+	}
+
+	// get .env params
+	mongoUri := os.Getenv("MONGODB_URI")
+	mongoDatabase := os.Getenv("MONGODB_DATABASE")
+	mongoCollection := os.Getenv("MONGODB_COLLECTION")
+
+	// Folder Path with Games
+	folderPath := os.Getenv("FOLDER_PATH")
+
+	if !slices.Equal(cfg.backends, []string{"mongodb"}) && command != "import" {
+		fmt.Println("--backend other than mongodb only works with import")
+		return
+	}
+	if !slices.Contains(cfg.backends, "mongodb") {
+		if output, err = openOutput(nil); err != nil {
+			fmt.Println("Failed to open output:", err)
+			return
+		}
+		fmt.Println("Import ID:", cfg.importId)
+		importFolder(folderPath, nil)
+		closeOutput()
+		if err := importReport.Close(); err != nil {
+			fmt.Println("Failed to write report file:", err)
+		}
+		fmt.Println("Report:", importReport.Summary())
+		return
+	}
+
+	// MongoDB Client
+	client, err := mongo.Connect(context.Background(), cfg.mongo.ClientOptions(mongoUri))
+	if err != nil {
+		fmt.Println("Failed to connect to MongoDB:", err)
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	// Collection
+	collection, err := prepareCollection(client.Database(mongoDatabase), mongoCollection)
+	for _, name := range routing.Collections(cfg.routes) {
+		if err == nil {
+			_, err = prepareCollection(client.Database(mongoDatabase), name)
+		}
+	}
+	if err != nil {
+		fmt.Println("Failed to create collection:", err)
+		return
+	}
+
+	started := time.Now()
+	if command == "import" || command == "reprocess-dead-letters" || command == "diff-import" {
+		for _, c := range withRoutes(collection) {
+			if err := setupCollection(c); err != nil {
+				fmt.Printf("Failed to set up collection %s: %s\n", c.Name(), err)
+				return
+			}
+		}
+		parseErrors = client.Database(mongoDatabase).Collection(env.String("MONGODB_ERRORS_COLLECTION", mongoCollection+"_errors"))
+		if cfg.ratingsCollection != "" {
+			if ratings, err = prepareRatings(client.Database(mongoDatabase), cfg.ratingsCollection); err != nil {
+				fmt.Println("Failed to create ratings collection:", err)
+				return
+			}
+		}
+		if cfg.eventsCollection != "" {
+			if events, err = prepareEvents(client.Database(mongoDatabase), cfg.eventsCollection); err != nil {
+				fmt.Println("Failed to create events collection:", err)
+				return
+			}
+		}
+		if output, err = openOutput(client.Database(mongoDatabase)); err != nil {
+			fmt.Println("Failed to open output:", err)
+			return
+		}
+		fmt.Println("Import ID:", cfg.importId)
+	}
+
+	switch command {
+	case "import":
+		importFolder(folderPath, collection)
+	case "diff-import":
+		cfg.diff = true
+		importFolder(folderPath, collection)
+		fmt.Printf("Diff: %d added, %d changed, %d unchanged\n", delta.added.Load(), delta.changed.Load(), delta.unchanged.Load())
+	case "reprocess-dead-letters":
+		reprocessDeadLetters(collection)
+	case "ensure-indexes":
+		cfg.ensureIndexes = true
+	case "stats":
+		snapshot, err := collectionStats(collection)
+		if err != nil {
+			fmt.Println("Failed to aggregate games:", err)
+			return
+		}
+		fmt.Print(snapshot)
+		if cfg.statsFile != "" {
+			if err := snapshot.WriteFile(cfg.statsFile); err != nil {
+				fmt.Println("Failed to write stats file:", err)
+			}
+		}
+	case "rollback":
+		if !cfg.importIdSet {
+			fmt.Println("rollback needs --import-id")
+			return
+		}
+		deleted, err := rollback(collection, cfg.importId, cfg.importFile)
+		if err != nil {
+			fmt.Println("Failed to roll back:", err)
+			return
+		}
+		fmt.Printf("Rolled back %d games\n", deleted)
+	case "backfill-openings":
+		backfillOpenings(folderPath, collection)
+	case "import-tournaments":
+		importTournaments(collection, client.Database(mongoDatabase).Collection(env.String("MONGODB_TOURNAMENTS_COLLECTION", "tournaments")))
+	default:
+		fmt.Println("Unknown command:", command)
+		return
+	}
+	closeOutput()
+
+	if cfg.ensureIndexes {
+		if err := ensureIndexes(collection); err != nil {
+			fmt.Println("Failed to create indexes:", err)
+		}
+	}
+	if cfg.searchIndex != "" && command != "rollback" {
+		if err := ensureSearchIndex(collection); err != nil {
+			fmt.Println("Failed to create search index:", err)
+		}
+	}
+
+	if err := importReport.Close(); err != nil {
+		fmt.Println("Failed to write report file:", err)
+	}
+	fmt.Println("Report:", importReport.Summary())
+
+	if command == "import" || command == "reprocess-dead-letters" || command == "diff-import" {
+		jobs := client.Database(mongoDatabase).Collection(env.String("MONGODB_JOBS_COLLECTION", "import_jobs"))
+		if err := recordJob(jobs, command, folderPath, collection.Name(), started); err != nil {
+			fmt.Println("Failed to record import job:", err)
+		}
+	}
+}
+
+// maxJobFiles is how many file names a job document lists
+const maxJobFiles = 1000
+
+// jobFiles are the files read by this run, for the job document
+var jobFiles struct {
+	names []string
+	count int
+}
+
+// recordJob writes a document describing the run (what was read, counts,
+// timing, parser version and a hash of the options) into import_jobs, so the
+// provenance of every importId can be looked up later
+func recordJob(jobs *mongo.Collection, command string, folder string, collection string, started time.Time) error {
+	// Options that differ between runs, whatever their source
+	var settings []string
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name != "import-id" && f.Name != "import-file" {
+			settings = append(settings, f.Name+"="+f.Value.String())
+		}
+	})
+	sum := sha256.Sum256([]byte(strings.Join(settings, "\n")))
+
+	revision := ""
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				revision = setting.Value
+			}
+		}
+	}
+
+	finished := time.Now()
+	_, err := jobs.InsertOne(context.Background(), bson.M{
+		"importId":        cfg.importId,
+		"command":         command,
+		"folder":          folder,
+		"collection":      collection,
+		"files":           jobFiles.names, // the first maxJobFiles
+		"fileCount":       jobFiles.count,
+		"games":           aggregates.Snapshot().Games,
+		"failed":          failedGames.Load(),
+		"problems":        importReport.Counts(),
+		"startedAt":       started,
+		"finishedAt":      finished,
+		"durationSeconds": finished.Sub(started).Seconds(),
+		"parserVersion":   pgnparse.Version,
+		"revision":        revision,
+		"configHash":      hex.EncodeToString(sum[:]),
+	})
+	return err
+}
+
+// importFolder imports every file in the folder
+func importFolder(folderPath string, collection *mongo.Collection) {
+	// Process files in the folder concurrently
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var totalGames int
+
+	// Rolling throughput history
+	started := time.Now()
+	history := loadHistory()
+	stopTracking := func() {}
+	if history != nil {
+		stopTracking = history.Track(time.Minute, func() (int, int) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return totalGames, int(failedGames.Load())
+		})
+	}
+
+	// A fixed number of workers keeps the number of open files bounded,
+	// even for folders with millions of one-game files
+	paths := make(chan string, cfg.maxOpenFiles)
+	for i := 0; i < cfg.maxOpenFiles; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			batch := newGameBatch(collection, cfg.batchSize, &totalGames, &mutex)
+			for filePath := range paths {
+				processFile(filePath, batch)
+			}
+			batch.flush()
+		}()
+	}
+
+	err := pgnsource.Walk(folderPath, cfg.dirBatch, func(path string) {
+		if jobFiles.count++; jobFiles.count <= maxJobFiles {
+			jobFiles.names = append(jobFiles.names, path)
+		}
+		paths <- path
+	})
+	close(paths)
+	if err != nil {
+		fmt.Println("Error processing files:", err)
+	}
+
+	wg.Wait()
+	stopTracking()
+
+	fmt.Printf("Finished. Total Games: %d\n", totalGames)
+	if cfg.verifySample > 0 {
+		fmt.Printf("Verified %d sampled games, %d mismatches\n", verified.games.Load(), verified.mismatches.Load())
+	}
+	if history != nil {
+		printThroughput(history, started)
+	}
+	printStats()
+}
+
+// reprocessDeadLetters retries quarantined games with the current parser
+// and keeps only the entries that still fail. Games the current options
+// leave out are cleared too, retrying them would never store them.
+func reprocessDeadLetters(collection *mongo.Collection) {
+	entries, err := deadletter.Load(cfg.quarantineFile)
+	if err != nil {
+		fmt.Println("Failed to read quarantine file:", err)
+		return
+	}
+
+	// Games were quarantined for illegal moves, never import them unchecked
+	cfg.validateMoves = true
+
+	var mutex sync.Mutex
+	var recovered, cleared int
+	var queued, remaining []deadletter.Entry
+
+	// The batch keeps what became of every game, to know which entries succeeded
+	batch := newGameBatch(collection, cfg.batchSize, &recovered, &mutex)
+	batch.outcomes = make(map[gameKey]outcome)
+	for _, entry := range entries {
+		switch processGame(entry.PGN, entry.File, entry.Game, -1, batch) {
+		case stored:
+		case pending:
+			queued = append(queued, entry)
+		case skipped:
+			cleared++
+		default:
+			remaining = append(remaining, entry)
+		}
+	}
+
+	batch.flush()
+	for _, entry := range queued {
+		switch batch.outcomes[gameKey{entry.File, entry.Game}] {
+		case stored:
+		case skipped:
+			cleared++
+		default:
+			remaining = append(remaining, entry)
+		}
+	}
+
+	if err := deadletter.Rewrite(cfg.quarantineFile, remaining); err != nil {
+		fmt.Println("Failed to rewrite quarantine file:", err)
+		return
+	}
+
+	fmt.Printf("Finished. Recovered %d of %d quarantined games, %d left out by the current options\n", recovered, len(entries), cleared)
+}
+
+// importTournaments stores the standings of the Lichess tournaments the
+// imported games were played in (or of --tournaments), skipping known ones
+func importTournaments(games *mongo.Collection, tournaments *mongo.Collection) {
+	var refs []lichess.TournamentRef
+	if len(cfg.tournaments) > 0 {
+		for _, value := range cfg.tournaments {
+			refs = append(refs, lichess.ParseRef(value))
+		}
+	} else {
+		events, err := games.Distinct(context.Background(), cfg.fields.Name("event"), cfg.fields.Filter(bson.M{"tournamentId": bson.M{"$exists": true}}))
+		if err != nil {
+			fmt.Println("Failed to list tournaments:", err)
+			return
+		}
+		seen := make(map[string]bool)
+		for _, event := range events {
+			name, _ := event.(string)
+			ref, ok := lichess.ParseEvent(name)
+			if ok && !seen[ref.ID] {
+				seen[ref.ID] = true
+				refs = append(refs, ref)
+			}
+		}
+	}
+
+	client := &lichess.Client{Token: os.Getenv("LICHESS_TOKEN")}
+	var stored int
+
+	for _, ref := range refs {
+		if len(cfg.tournaments) == 0 {
+			known, err := tournaments.CountDocuments(context.Background(), bson.M{"_id": ref.ID})
+			if err == nil && known > 0 {
+				continue
+			}
+		}
+
+		tournament, err := client.Tournament(ref)
+		if err != nil {
+			fmt.Printf("Failed to fetch tournament %s: %s\n", ref.ID, err)
+			continue
+		}
+
+		_, err = tournaments.ReplaceOne(context.Background(), bson.M{"_id": tournament.ID}, tournament, options.Replace().SetUpsert(true))
+		if err != nil {
+			fmt.Println("Failed to store tournament in MongoDB:", err)
+			continue
+		}
+		stored++
+		fmt.Printf("Stored %s (%d players)\n", tournament.Name, len(tournament.Standings))
+	}
+
+	fmt.Printf("Finished. Stored %d of %d tournaments\n", stored, len(refs))
+}
+
+// backfillOpenings re-reads the source files and sets opening and variation
+// on already imported documents (matched by site) that don't have them
+func backfillOpenings(folderPath string, collection *mongo.Collection) {
+	var updated int
+
+	err := pgnsource.Walk(folderPath, cfg.dirBatch, func(path string) {
+		err := pgnsource.Each(path, func(name string, r io.Reader) error {
+			scanner := pgnparse.NewScanner(r)
+			for scanner.Scan() {
+				game, err := parseGame(scanner.Text())
+				if err != nil || game.Site == "" || game.Opening == "" {
+					continue
+				}
+
+				filter := cfg.fields.Filter(bson.M{"site": game.Site, "opening": bson.M{"$in": bson.A{"", nil}}})
+				update := bson.M{"$set": cfg.fields.Filter(bson.M{"opening": game.Opening, "variation": game.Variation})}
+				result, err := collection.UpdateMany(context.Background(), filter, update)
+				if err != nil {
+					fmt.Println("Failed to update documents in MongoDB:", err)
+					continue
+				}
+				updated += int(result.ModifiedCount)
+			}
+			if err := scanner.Err(); err != nil {
+				fmt.Printf("Error reading file %s: %s\n", name, err)
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("Failed to read file %s: %s\n", path, err)
+		}
+	})
+	if err != nil {
+		fmt.Println("Error processing files:", err)
+	}
+
+	fmt.Printf("Finished. Backfilled opening of %d documents\n", updated)
+}
+
+// loadHistory opens the state file, nil when disabled or unreadable
+func loadHistory() *progress.History {
+	if cfg.stateFile == "" {
+		return nil
+	}
+
+	history, err := progress.Load(cfg.stateFile)
+	if err != nil {
+		fmt.Printf("Failed to load state file %s: %s\n", cfg.stateFile, err)
+		return nil
+	}
+	return history
+}
+
+// printThroughput compares this run with the rolling 24h history
+func printThroughput(history *progress.History, started time.Time) {
+	rate, errorRate := history.Rate(started)
+	dayRate, dayErrorRate := history.Rate(time.Now().Add(-progress.Window))
+	fmt.Printf("Throughput: %.1f games/s, %.2f%% errors (last 24h: %.1f games/s, %.2f%% errors)\n",
+		rate, errorRate*100, dayRate, dayErrorRate*100)
+}
+
+func processFile(filePath string, batch *gameBatch) int {
+	var gamesProcessed int
+
+	// Read file, or every file of a tar archive
+	err := pgnsource.Each(filePath, func(name string, r io.Reader) error {
+		// Keep the file in GridFS as it's read
+		var upload *gridfs.UploadStream
+		if cfg.rawPgn == "file" && batch.rawPgn != nil {
+			var err error
+			upload, err = batch.rawPgn.OpenUploadStream(name, rawPgnMetadata(name))
+			if err != nil {
+				fmt.Printf("Failed to store %s in GridFS: %s\n", name, err)
+			} else {
+				r = io.TeeReader(r, upload)
+				batch.rawFile = upload.FileID.(primitive.ObjectID)
+			}
+		}
+
+		// Split file into games
+		scanner := pgnparse.NewScanner(r)
+		var index int
+
+		// Start Parsing
+		for scanner.Scan() {
+			index++
+			processGame(scanner.Text(), name, index, scanner.Offset(), batch)
+		}
+		gamesProcessed += index
+		batch.rawFile = primitive.NilObjectID
+
+		if err := scanner.Err(); err != nil {
+			fmt.Printf("Error reading file %s: %s\n", name, err)
+			if upload != nil {
+				upload.Abort()
+			}
+			rollbackFile(batch, name)
+			return nil
+		}
+		if upload != nil {
+			if err := upload.Close(); err != nil {
+				fmt.Printf("Failed to store %s in GridFS: %s\n", name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Failed to read file %s: %s\n", filePath, err)
+		rollbackFile(batch, filePath)
+	}
+
+	return gamesProcessed
+}
+
+// rollbackFile deletes what this import stored from a file that failed
+// halfway, so it can simply be imported again
+func rollbackFile(batch *gameBatch, file string) {
+	batch.flush()
+	if !slices.Equal(cfg.backends, []string{"mongodb"}) {
+		fmt.Printf("Can't roll back %s outside MongoDB, its games read before the error stay in the other destinations\n", file)
+	}
+	if batch.collection == nil {
+		return
+	}
+	// Replaced games were stored by earlier imports, deleting them would lose them
+	if cfg.upsert || cfg.diff {
+		fmt.Printf("Not rolling back %s: --upsert and diff-import replace games of earlier imports\n", file)
+		return
+	}
+	deleted, err := rollback(batch.collection, cfg.importId, file)
+	if err != nil {
+		fmt.Printf("Failed to roll back %s: %s\n", file, err)
+		return
+	}
+	fmt.Printf("Rolled back %d games of %s\n", deleted, file)
+}
+
+// rollback deletes the games stored by an import, only those of file (or of
+// the entries of archive file) unless file is empty
+func rollback(collection *mongo.Collection, importId string, file string) (int64, error) {
+	var deleted int64
+	for _, c := range withRoutes(collection) {
+		result, err := c.DeleteMany(context.Background(), cfg.fields.Filter(importFilter("", importId, file)))
+		if err != nil {
+			return deleted, err
+		}
+		deleted += result.DeletedCount
+	}
+
+	// And their original PGN, see --raw-pgn
+	bucket, err := rawPgnBucket(collection)
+	if err != nil {
+		return deleted, err
+	}
+	cursor, err := bucket.Find(importFilter("metadata.", importId, file))
+	if err != nil {
+		return deleted, err
+	}
+	defer cursor.Close(context.Background())
+	for cursor.Next(context.Background()) {
+		if err := bucket.Delete(cursor.Current.Lookup("_id").ObjectID()); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, cursor.Err()
+}
+
+// importFilter matches what an import stored, from file (or the entries of
+// archive file) unless file is empty. prefix is where importId and importFile are.
+func importFilter(prefix string, importId string, file string) bson.M {
+	filter := bson.M{prefix + "importId": importId}
+	if file != "" {
+		filter["$or"] = bson.A{
+			bson.M{prefix + "importFile": file},
+			bson.M{prefix + "importFile": bson.M{"$regex": "^" + regexp.QuoteMeta(file+"/")}},
+		}
+	}
+	return filter
+}
+
+// rawPgnBucket is the GridFS bucket keeping the original PGN, <collection>_pgn
+func rawPgnBucket(collection *mongo.Collection) (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(collection.Database(), options.GridFSBucket().SetName(collection.Name()+"_pgn"))
+}
+
+// rawPgnMetadata tags GridFS files like games, so rollback finds them
+func rawPgnMetadata(file string) *options.UploadOptions {
+	return options.GridFSUpload().SetMetadata(bson.M{"importId": cfg.importId, "importFile": file})
+}
+
+// outcome tells what processGame did with a game
+type outcome int
+
+const (
+	stored  outcome = iota
+	skipped         // left out on purpose, e.g. by --skip-variants
+	failed          // not stored, worth another try
+	pending         // queued in a batch, stored or not once the batch is flushed
+)
+
+// processGame stores one game, or queues it in the batch. offset is where
+// the game starts in the file, -1 when unknown.
+func processGame(data string, filePath string, index int, offset int64, batch *gameBatch) outcome {
+	raw := data
+	data, err := pgnparse.ToUTF8(data, cfg.encoding)
+	if err == nil {
+		err = pgnparse.Validate(data)
+	}
+	if err != nil {
+		rejectGame(filePath, index, offset, err)
+		return failed
+	}
+
+	game, err := parseGame(data)
+	if err != nil {
+		rejectGame(filePath, index, offset, err)
+		return failed
+	}
+
+	if pgnparse.VariantIn(game.Variant, cfg.skipVariants) {
+		return skipped
+	}
+
+	if !checks.Result(data, game.Result, filePath, index) {
+		return skipped
+	}
+
+	if !checks.Elo(game.whiteEloRaw, game.blackEloRaw, filePath, index) {
+		return skipped
+	}
+
+	if cfg.minEloRequired > 0 && !pgnparse.Rated(game.WhiteElo, game.BlackElo, cfg.minEloRequired) {
+		return skipped
+	}
+
+	// It would expire right away
+	if cfg.retention > 0 && game.PlayedAt != nil && time.Since(*game.PlayedAt) > cfg.retention {
+		return skipped
+	}
+
+	if cfg.validateMoves && !checks.Moves(data, game.replayErr, filePath, index) {
+		failedGames.Add(1)
+		return failed
+	}
+
+	switch {
+	case cfg.layout == "timeseries" && game.PlayedAt == nil:
+		importReport.Add(filePath, index, "missing_played_at", "time series collections need a Date with at least a year")
+		return skipped
+	case cfg.layout == "clustered" && game.PlayedAt != nil:
+		game.ID = timeOrderedID(*game.PlayedAt)
+	case cfg.id == "gameId":
+		game.ID = game.GameId
+	case cfg.id == "contentHash":
+		game.ID = game.ContentHash
+	}
+	game.ImportId, game.ImportFile = cfg.importId, filePath
+
+	switch {
+	case batch.rawPgn == nil:
+	case cfg.rawPgn == "game":
+		game.RawPgn, game.pgn = primitive.NewObjectID(), raw
+	case !batch.rawFile.IsZero() && offset >= 0:
+		game.RawPgn, game.RawPgnOffset = batch.rawFile, &offset
+	}
+
+	if cfg.compressMoves {
+		compressMoves(game)
+	}
+
+	if !cfg.diff {
+		batch.add(game, filePath, index)
+		return pending
+	}
+
+	collection := route(batch.collection, game, filePath)
+	result := applyDiff(collection, game)
+	if result == stored {
+		batch.stored(game, collection)
+	}
+	return result
+}
+
+// compressMoves replaces moves and positions by zstd-compressed binaries,
+// keeping them as they are if that fails
+func compressMoves(game *Game) {
+	if len(game.Moves) > 0 {
+		moves, err := packed.Encode(game.Moves)
+		if err != nil {
+			fmt.Printf("Failed to compress the moves of %s: %s\n", game.GameId, err)
+			return
+		}
+		game.MovesZstd, game.Moves = moves, nil
+	}
+	if len(game.Positions) > 0 {
+		positions, err := packed.Encode(game.Positions)
+		if err != nil {
+			fmt.Printf("Failed to compress the positions of %s: %s\n", game.GameId, err)
+			return
+		}
+		game.PositionsZstd, game.Positions = positions, nil
+	}
+}
+
+// gameBatch buffers parsed games and inserts them with one unordered bulk
+// write per --batch-size games. Every worker has its own.
+type gameBatch struct {
+	collection *mongo.Collection
+	size       int
+	queued     []queuedGame
+	outcomes   map[gameKey]outcome // what became of every flushed game, only kept when set
+
+	// GridFS buckets aren't safe for concurrent uploads, so one per worker
+	rawPgn  *gridfs.Bucket
+	rawFile primitive.ObjectID // file being read, with --raw-pgn=file
+
+	records []any // for the ratings collection
+	events  []any // for the events collection
+
+	totalProcessed *int
+	mutex          *sync.Mutex
+}
+
+type queuedGame struct {
+	game       *Game
+	doc        any               // game with --field-map applied
+	collection *mongo.Collection // see --routes
+	file       string
+	index      int
+}
+
+// gameKey identifies a game by its file and index in the file
+type gameKey struct {
+	file  string
+	index int
+}
+
+func newGameBatch(collection *mongo.Collection, size int, totalProcessed *int, mutex *sync.Mutex) *gameBatch {
+	b := &gameBatch{collection: collection, size: size, totalProcessed: totalProcessed, mutex: mutex}
+	if cfg.rawPgn != "" {
+		var err error
+		if b.rawPgn, err = rawPgnBucket(collection); err != nil {
+			fmt.Println("Failed to open GridFS bucket, not keeping the original PGN:", err)
+		}
+	}
+	return b
+}
+
+// add queues a game and inserts the batch once it is full
+func (b *gameBatch) add(game *Game, file string, index int) {
+	doc, err := cfg.fields.Document(game)
+	if err != nil {
+		fmt.Printf("Failed to map fields of game %d of %s: %s\n", index, file, err)
+		importReport.Add(file, index, "insert_error", err.Error())
+		failedGames.Add(1)
+		b.record(queuedGame{game: game, file: file, index: index}, failed)
+		return
+	}
+
+	b.queued = append(b.queued, queuedGame{game, doc, route(b.collection, game, file), file, index})
+	if len(b.queued) >= b.size {
+		b.flush()
+	}
+}
+
+// flush writes the queued games and returns how many were stored
+func (b *gameBatch) flush() int {
+	defer b.writeCopies()
+	if len(b.queued) == 0 {
+		return 0
+	}
+
+	// One write per collection (see --routes), games have none without
+	// --backend=mongodb
+	groups := make(map[string][]queuedGame)
+	for _, q := range b.queued {
+		name := ""
+		if q.collection != nil {
+			name = q.collection.Name()
+		}
+		groups[name] = append(groups[name], q)
+	}
+	b.queued = nil
+
+	var count int
+	for _, queued := range groups {
+		count += b.write(queued[0].collection, queued)
+	}
+	return count
+}
+
+// write sends games of one collection to every destination of --backend
+// at once. Failed games go to the report; a game failed by any destination
+// isn't counted as stored.
+func (b *gameBatch) write(collection *mongo.Collection, queued []queuedGame) int {
+	// Without --backend=mongodb games go to the table of MONGODB_COLLECTION
+	name := env.String("MONGODB_COLLECTION", "games")
+	if collection != nil {
+		name = collection.Name()
+	}
+	batch := make([]sink.Record, len(queued))
+	for i, q := range queued {
+		batch[i] = record(q, name)
+	}
+
+	var failures map[int]error
+	err := ensureSchema(name)
+	if err == nil {
+		failures, err = output.WriteBatch(context.Background(), batch)
+	}
+	if err != nil {
+		// Nothing is known to be written, e.g. the connection dropped
+		fmt.Printf("Failed to store %d games: %s\n", len(queued), err)
+		for _, q := range queued {
+			importReport.Add(q.file, q.index, "insert_error", err.Error())
+			b.record(q, failed)
+		}
+		failedGames.Add(int64(len(queued)))
+		return 0
+	}
+
+	// Games not stored, by index in the batch
+	rejected := make(map[int]outcome)
+	for i, q := range queued {
+		err, ok := failures[i]
+		switch {
+		case !ok:
+			b.stored(q.game, collection)
+			b.record(q, stored)
+			continue
+		case errors.Is(err, sink.ErrDuplicate):
+			fmt.Println("Skipping duplicate game", q.game.GameId)
+			rejected[i] = skipped
+		default:
+			fmt.Printf("Failed to store game %d of %s: %s\n", q.index, q.file, err)
+			importReport.Add(q.file, q.index, "insert_error", err.Error())
+			failedGames.Add(1)
+			rejected[i] = failed
+		}
+		b.record(q, rejected[i])
+	}
+	if cfg.verifySample > 0 {
+		verifySample(collection, queued, rejected)
+	}
+	return len(queued) - len(rejected)
+}
+
+// record keeps what became of a flushed game, when outcomes are kept
+func (b *gameBatch) record(q queuedGame, result outcome) {
+	if b.outcomes != nil {
+		b.outcomes[gameKey{q.file, q.index}] = result
+	}
+}
+
+// output writes the batches to the destinations of --backend, all at once
+var output *sink.Fanout
+
+// openOutput opens the destinations of --backend, db is nil without
+// --backend=mongodb
+func openOutput(db *mongo.Database) (*sink.Fanout, error) {
+	f := &sink.Fanout{}
+	for _, backend := range cfg.backends {
+		s, _ := sink.Lookup(backend)
+		f.Add(backend, s)
+	}
+	err := f.Open(context.Background(), sink.Options{
+		ImportId:      cfg.importId,
+		Workers:       cfg.maxOpenFiles,
+		BatchSize:     cfg.batchSize,
+		Upsert:        cfg.upsert,
+		DedupeContent: cfg.dedupeContent,
+		Lost:          lost,
+		Mongo:         db,
+		Fields:        cfg.fields,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func closeOutput() {
+	if output == nil {
+		return
+	}
+	if err := output.Close(); err != nil {
+		fmt.Println("Failed to close output:", err)
+	}
 }
 
-func main() {
-	if err := godotenv.Load(); err != nil {
-		fmt.Println("No .env file found")
+// lost reports games a destination lost after they were counted as stored
+func lost(batch []sink.Record, err error) {
+	for _, r := range batch {
+		importReport.Add(r.File, r.Index, "insert_error", err.Error())
 	}
+	failedGames.Add(int64(len(batch)))
+}
 
-	// get .env params
-	mongoUri := os.Getenv("MONGODB_URI")
-	mongoDatabase := os.Getenv("MONGODB_DATABASE")
-	mongoCollection := os.Getenv("MONGODB_COLLECTION")
+// schemas are the collections the destinations were prepared for
+var schemas = struct {
+	sync.Mutex
+	ready map[string]bool
+}{ready: make(map[string]bool)}
 
-	// Folder Path with Games
-	folderPath := os.Getenv("FOLDER_PATH")
+// ensureSchema prepares the destinations for a collection, the first time
+// games go to it
+func ensureSchema(name string) error {
+	schemas.Lock()
+	defer schemas.Unlock()
+	if schemas.ready[name] {
+		return nil
+	}
+	if err := output.EnsureSchema(context.Background(), sink.Table{Name: name}); err != nil {
+		return err
+	}
+	schemas.ready[name] = true
+	return nil
+}
 
-	// MongoDB Client
-	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(mongoUri))
-	if err != nil {
-		fmt.Println("Failed to connect to MongoDB:", err)
-		return
+// record is what the destinations get of a queued game
+func record(q queuedGame, name string) sink.Record {
+	// Sharded upserts must target one shard by the shard key
+	key := bson.M{"source": q.game.Source, "sourceId": q.game.SourceId}
+	switch {
+	case cfg.shardKey != "":
+		key = bson.M{"gameId": q.game.GameId}
+	case cfg.id != "objectid":
+		key = bson.M{"_id": q.game.ID}
 	}
-	defer client.Disconnect(context.Background())
+	return sink.Record{Table: name, File: q.file, Index: q.index, Game: sinkGame(q.game), Doc: q.doc, Key: cfg.fields.Filter(key)}
+}
 
-	// Collection
-	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+// sinkGame converts a game for the destinations, the parts of its date
+// from playedAt, and evals and clocks per ply like the Postgres importer's
+func sinkGame(g *Game) *sink.Game {
+	game := &sink.Game{
+		Source: g.Source, SourceId: g.SourceId, MovesHash: g.MovesHash, ContentHash: g.ContentHash,
+		ImportId: g.ImportId, SourceFile: g.ImportFile, SourceOffset: g.RawPgnOffset,
+		Event: g.Event, TournamentId: g.Tournament, EventDate: g.EventDate, Site: g.Site, Round: g.Round,
+		White: g.White, Black: g.Black, WhiteElo: g.WhiteElo, BlackElo: g.BlackElo,
+		WhiteTitle: g.WhiteTitle, BlackTitle: g.BlackTitle, WhiteRatingDiff: g.WhiteRatingDiff, BlackRatingDiff: g.BlackRatingDiff,
+		Result: string(g.Result), Eco: g.Eco, Opening: g.Opening, TimeControl: g.TimeControl, Termination: g.Termination, Variant: g.Variant,
+		Time: g.Time, PlayedAt: g.PlayedAt,
+		UciMoves: g.UciMoves, Positions: g.Positions, Zobrist: g.Zobrist, MovesCount: g.MovesCount, PlyCount: g.PlyCount,
+		FinalFen: g.FinalFen, MaxImbalance: g.MaxImbalance, Features: g.Features, Tags: g.ExtraTags,
+	}
+	if g.PlayedAt != nil {
+		year := g.PlayedAt.Year()
+		game.Year = &year
+		if g.PlayedAtPrecision != "year" {
+			month := int(g.PlayedAt.Month())
+			game.Month = &month
+		}
+		if g.PlayedAtPrecision == "time" || g.PlayedAtPrecision == "day" {
+			date := g.PlayedAt.Truncate(24 * time.Hour)
+			day := date.Day()
+			game.Date, game.Day = &date, &day
+		}
+	}
+	for _, m := range g.Moves {
+		game.Moves = append(game.Moves, m.SAN)
+		var eval *float32
+		var clock *int32
+		if m.Eval != nil {
+			e := float32(*m.Eval)
+			eval, game.Analyzed = &e, true
+		}
+		if m.Clock != nil {
+			c := int32(*m.Clock * 100)
+			clock = &c
+		}
+		game.Evals, game.Clocks = append(game.Evals, eval), append(game.Clocks, clock)
+	}
+	return game
+}
 
-	// Process files in the folder concurrently
-	var wg sync.WaitGroup
-	var mutex sync.Mutex
-	var totalGames int
+// verified counts the games read back by --verify-sample
+var verified struct {
+	games, mismatches atomic.Int64
+}
 
-	err = filepath.Walk(folderPath, func(path string, info os.FileInfo, err error) error {
+// verifySample reads back a random sample of the games just written and
+// compares them field by field with what was sent, to catch silent
+// truncation or encoding problems
+func verifySample(collection *mongo.Collection, queued []queuedGame, rejected map[int]outcome) {
+	for i, q := range queued {
+		if _, ok := rejected[i]; ok || rand.Float64() >= cfg.verifySample {
+			continue
+		}
+		sent, err := bson.Marshal(q.doc)
 		if err != nil {
-			fmt.Printf("Error accessing file %s: %s\n", path, err)
-			return nil
+			continue
 		}
 
-		if info.IsDir() {
-			// Skip directories
-			return nil
+		var stored bson.Raw
+		filter := cfg.fields.Filter(bson.M{"source": q.game.Source, "sourceId": q.game.SourceId})
+		err = collection.FindOne(context.Background(), filter).Decode(&stored)
+
+		var fields []string
+		if err != nil {
+			fields = []string{"not found: " + err.Error()}
+		} else {
+			fields = differentFields(sent, stored)
 		}
 
-		wg.Add(1)
-		go func(filePath string) {
-			defer wg.Done()
-			processFile(filePath, collection, &totalGames, &mutex)
-		}(path)
+		verified.games.Add(1)
+		if len(fields) > 0 {
+			verified.mismatches.Add(1)
+			fmt.Printf("Stored game %d of %s differs: %s\n", q.index, q.file, strings.Join(fields, ", "))
+			importReport.Add(q.file, q.index, "verify_mismatch", strings.Join(fields, ", "))
+		}
+	}
+}
 
-		return nil
-	})
+// differentFields lists the fields of sent that aren't stored as they were
+// sent. _id is set by the server and fields only in stored are ignored.
+func differentFields(sent, stored bson.Raw) []string {
+	elements, err := sent.Elements()
 	if err != nil {
-		fmt.Println("Error processing files:", err)
+		return []string{err.Error()}
 	}
 
-	wg.Wait()
+	var fields []string
+	for _, e := range elements {
+		if e.Key() == "_id" {
+			continue
+		}
+		value, err := stored.LookupErr(e.Key())
+		if err != nil || !value.Equal(e.Value()) {
+			fields = append(fields, e.Key())
+		}
+	}
+	return fields
+}
 
-	fmt.Printf("Finished. Total Games: %d\n", totalGames)
+// route returns the collection --routes sends the game to, collection when no
+// rule matches, or its monthly collection with --collection-per-month
+func route(collection *mongo.Collection, game *Game, file string) *mongo.Collection {
+	if len(cfg.routes) > 0 {
+		name := routing.Route(cfg.routes, routing.Game{
+			Speed:    pgnparse.Speed(game.TimeControl),
+			Variant:  game.Variant,
+			WhiteElo: game.WhiteElo,
+			BlackElo: game.BlackElo,
+			File:     file,
+		})
+		if name != "" {
+			collection = collection.Database().Collection(name)
+		}
+	}
+	if cfg.perMonth && game.PlayedAt != nil {
+		collection = monthCollection(collection, *game.PlayedAt)
+	}
+	return collection
 }
 
-func processFile(filePath string, collection *mongo.Collection, totalProcessed *int, mutex *sync.Mutex) int {
-	// Read file
-	file, err := os.Open(filePath)
-	if err != nil {
-		fmt.Printf("Failed to open file %s: %s\n", filePath, err)
-		return 0
+// withRoutes returns the collection and the collections of --routes, and
+// the existing monthly collections of both with --collection-per-month
+func withRoutes(collection *mongo.Collection) []*mongo.Collection {
+	collections := []*mongo.Collection{collection}
+	for _, name := range routing.Collections(cfg.routes) {
+		if name != collection.Name() {
+			collections = append(collections, collection.Database().Collection(name))
+		}
+	}
+	if !cfg.perMonth {
+		return collections
 	}
-	defer file.Close()
 
-	// Create scanner with buffer
-	scanner := bufio.NewScanner(file)
+	for _, c := range collections {
+		filter := bson.M{"name": bson.M{"$regex": "^" + regexp.QuoteMeta(c.Name()) + `_\d{4}_\d{2}$`}}
+		names, err := c.Database().ListCollectionNames(context.Background(), filter)
+		if err != nil {
+			fmt.Println("Failed to list monthly collections:", err)
+			continue
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			collections = append(collections, c.Database().Collection(name))
+		}
+	}
+	return collections
+}
 
-	// Make buffer
-	var gameData strings.Builder
-	var gamesProcessed int
+// setupCollection shards the collection and creates what imports rely on:
+// the game id index and the --retention TTL
+func setupCollection(c *mongo.Collection) error {
+	if cfg.shardKey != "" {
+		if err := shardCollection(c); err != nil {
+			return err
+		}
+	}
+	if err := ensureGameIdIndex(c); err != nil {
+		return err
+	}
+	if cfg.retention > 0 {
+		return ensureRetention(c)
+	}
+	return nil
+}
 
-	// Start Parsing
-	for scanner.Scan() {
-		line := scanner.Text()
+// monthCollections are the --collection-per-month collections set up by this run
+var monthCollections = struct {
+	sync.Mutex
+	ready map[string]bool
+}{ready: make(map[string]bool)}
 
-		if strings.HasPrefix(line, "[Event ") {
-			// Start New Game
-			if gameData.Len() > 0 {
-				processGame(gameData.String(), collection, totalProcessed, mutex)
-				gamesProcessed++
-				gameData.Reset()
-			}
+// monthCollection returns the collection of the month the game was played,
+// <collection>_2024_01, creating it like the main one on first use
+func monthCollection(collection *mongo.Collection, playedAt time.Time) *mongo.Collection {
+	name := fmt.Sprintf("%s_%d_%02d", collection.Name(), playedAt.Year(), playedAt.Month())
+
+	monthCollections.Lock()
+	defer monthCollections.Unlock()
+	if !monthCollections.ready[name] {
+		monthCollections.ready[name] = true
+		c, err := prepareCollection(collection.Database(), name)
+		if err == nil {
+			err = setupCollection(c)
 		}
+		if err != nil {
+			fmt.Printf("Failed to set up collection %s: %s\n", name, err)
+		}
+	}
+	return collection.Database().Collection(name)
+}
 
-		gameData.WriteString(line + "\n")
+// stored counts a game that made it into collection and queues its copies
+func (b *gameBatch) stored(game *Game, collection *mongo.Collection) {
+	// Only stored games get their PGN uploaded, so duplicates leave nothing behind
+	if game.pgn != "" {
+		err := b.rawPgn.UploadFromStreamWithID(game.RawPgn, game.GameId, strings.NewReader(game.pgn), rawPgnMetadata(game.ImportFile))
+		if err != nil {
+			fmt.Printf("Failed to store the PGN of %s in GridFS: %s\n", game.GameId, err)
+		}
 	}
 
-	// Processing Last game
-	if gameData.Len() > 0 {
-		processGame(gameData.String(), collection, totalProcessed, mutex)
-		gamesProcessed++
+	aggregates.Add(pgnparse.Speed(game.TimeControl), game.Eco, pgnparse.Elo(game.WhiteElo), pgnparse.Elo(game.BlackElo))
+
+	if ratings != nil && game.PlayedAt != nil {
+		b.records = append(b.records, newRatingRecord(game))
+	}
+	if events != nil {
+		b.events = append(b.events, bson.M{
+			"gameId":     game.GameId,
+			"white":      game.White,
+			"black":      game.Black,
+			"eco":        game.Eco,
+			"result":     game.Result,
+			"importId":   game.ImportId,
+			"collection": collection.Name(),
+			"createdAt":  time.Now(),
+		})
 	}
+	if len(b.records) >= b.size || len(b.events) >= b.size {
+		b.writeCopies()
+	}
+
+	b.mutex.Lock()
+	*b.totalProcessed++
+	fmt.Printf("Total games processed: %d\n", *b.totalProcessed)
+	b.mutex.Unlock()
+}
+
+// ratingRecord is the compact copy of a game kept in the --ratings-collection
+// time series, for rating trends without reading whole games
+type ratingRecord struct {
+	PlayedAt time.Time `bson:"playedAt"`
+	Meta     struct {
+		Speed       string `bson:"speed"`
+		Variant     string `bson:"variant"`
+		TimeControl string `bson:"timeControl"`
+	} `bson:"meta"`
+	GameId          string          `bson:"gameId"`
+	White           string          `bson:"white"`
+	Black           string          `bson:"black"`
+	WhiteElo        *int            `bson:"whiteElo,omitempty"`
+	BlackElo        *int            `bson:"blackElo,omitempty"`
+	WhiteRatingDiff *int            `bson:"whiteRatingDiff,omitempty"`
+	BlackRatingDiff *int            `bson:"blackRatingDiff,omitempty"`
+	Result          pgnparse.Result `bson:"result"`
+}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Printf("Error reading file %s: %s\n", filePath, err)
+func newRatingRecord(game *Game) ratingRecord {
+	record := ratingRecord{
+		PlayedAt:        *game.PlayedAt,
+		GameId:          game.GameId,
+		White:           game.White,
+		Black:           game.Black,
+		WhiteElo:        game.WhiteElo,
+		BlackElo:        game.BlackElo,
+		WhiteRatingDiff: game.WhiteRatingDiff,
+		BlackRatingDiff: game.BlackRatingDiff,
+		Result:          game.Result,
 	}
+	record.Meta.Speed = pgnparse.Speed(game.TimeControl)
+	record.Meta.Variant = game.Variant
+	record.Meta.TimeControl = game.TimeControl
+	return record
+}
 
-	return gamesProcessed
+// writeCopies inserts the queued rating records and events. They are
+// derived copies, so failures are only printed.
+func (b *gameBatch) writeCopies() {
+	insertCopies(ratings, b.records, "rating records")
+	insertCopies(events, b.events, "events")
+	b.records, b.events = nil, nil
 }
 
-func processGame(data string, collection *mongo.Collection, totalProcessed *int, mutex *sync.Mutex) {
-	game := parseGame(data)
+func insertCopies(collection *mongo.Collection, docs []any, what string) {
+	if len(docs) == 0 {
+		return
+	}
+	_, err := collection.InsertMany(context.Background(), docs, options.InsertMany().SetOrdered(false))
+	if err != nil && !errors.Is(err, mongo.ErrUnacknowledgedWrite) {
+		fmt.Printf("Failed to insert %d %s: %s\n", len(docs), what, err)
+	}
+}
 
-	// Import to MongoDB
-	_, err := collection.InsertOne(context.Background(), game)
+// prepareEvents creates the events collection, capped so consumers can tail
+// it and it never grows past --events-size
+func prepareEvents(db *mongo.Database, name string) (*mongo.Collection, error) {
+	existing, err := db.ListCollectionNames(context.Background(), bson.M{"name": name})
 	if err != nil {
-		fmt.Println("Failed to insert game into MongoDB:", err)
-		return
+		return nil, err
+	}
+	if len(existing) == 0 {
+		opts := options.CreateCollection().SetCapped(true).SetSizeInBytes(cfg.eventsSize << 20)
+		if err := db.CreateCollection(context.Background(), name, opts); err != nil {
+			return nil, err
+		}
+		fmt.Println("Created capped events collection", name)
+	}
+	return db.Collection(name), nil
+}
+
+// prepareRatings creates the ratings time series, by playedAt with the
+// speed, variant and time control as metadata
+func prepareRatings(db *mongo.Database, name string) (*mongo.Collection, error) {
+	existing, err := db.ListCollectionNames(context.Background(), bson.M{"name": name})
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) == 0 {
+		opts := options.CreateCollection().SetTimeSeriesOptions(options.TimeSeries().
+			SetTimeField("playedAt").
+			SetMetaField("meta").
+			SetGranularity("hours"))
+		if err := db.CreateCollection(context.Background(), name, opts); err != nil {
+			return nil, err
+		}
+		fmt.Println("Created ratings time series", name)
 	}
+	return db.Collection(name), nil
+}
+
+// rejectGame records a game that could not be parsed in the report and the
+// errors collection, or stops the import with --strict
+func rejectGame(filePath string, index int, offset int64, err error) {
+	failedGames.Add(1)
+
+	where := fmt.Sprintf("game %d of %s", index, filePath)
+	message := err.Error()
+	if offset >= 0 {
+		where += fmt.Sprintf(" (byte %d)", offset)
+		message = fmt.Sprintf("byte %d: %s", offset, err)
+	}
+	importReport.Add(filePath, index, "parse_error", message)
 
-	mutex.Lock()
-	*totalProcessed++
-	fmt.Printf("Total games processed: %d\n", *totalProcessed)
-	mutex.Unlock()
+	if parseErrors != nil {
+		_, insertErr := parseErrors.InsertOne(context.Background(), bson.M{
+			"file":          filePath,
+			"game":          index,
+			"offset":        offset,
+			"error":         err.Error(),
+			"parserVersion": pgnparse.Version,
+			"createdAt":     time.Now(),
+		})
+		if insertErr != nil {
+			fmt.Println("Failed to record parse error:", insertErr)
+		}
+	}
+
+	if cfg.strict {
+		fmt.Printf("Malformed %s: %s\n", where, err)
+		importReport.Close()
+		os.Exit(1)
+	}
+	fmt.Printf("Skipping malformed %s: %s\n", where, err)
 }
 
 // ParseGame from PGN
-func parseGame(data string) *Game {
+func parseGame(data string) (*Game, error) {
 	game := &Game{}
 
-	re := regexp.MustCompile(`\[(\w+) "([^"]*)"\]`)
-	matches := re.FindAllStringSubmatch(data, -1)
+	tags, duplicates, err := pgnparse.ParseTags(data, cfg.duplicateTags)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.normalizeTags {
+		originals := pgnparse.NormalizeTags(tags)
+		if cfg.keepOriginals {
+			game.ExtraTags = originals
+		}
+	}
+	tags = pgnparse.ProcessTags(tags, cfg.tagProcessors)
 
-	for _, match := range matches {
-		tag := match[1]
-		value := match[2]
+	var link string    // Chess.com keeps the game URL here
+	var utcDate string // see playedAt
+	for _, tag := range tags {
+		value := tag.Value
 
-		switch tag {
+		switch tag.Name {
 		case "Opening":
 			game.Opening = value
+		case "Variation":
+			game.Variation = value
 		case "Event":
 			game.Event = value
+		case "EventDate":
+			if eventDate, ok := pgnparse.ParseDate(value).Time(); ok {
+				game.EventDate = &eventDate
+			}
+		case "EventType":
+			game.EventType = value
+		case "Section":
+			game.Section = value
+		case "Stage":
+			game.Stage = value
+		case "Board":
+			game.Board = convertToOptionalInt(value)
+		case "Annotator":
+			game.Annotator = value
+		case "Source":
+			game.DataSource = value
 		case "Site":
 			game.Site = value
+		case "Link":
+			link = value
 		case "Date":
 			game.Date = value
+		case "UTCDate":
+			utcDate = value
 		case "UTCTime":
 			game.Time = value
 		case "White":
@@ -179,68 +1787,578 @@ func parseGame(data string) *Game {
 		case "Black":
 			game.Black = value
 		case "Result":
-			game.Result = value
+			game.ResultRaw = value
 		case "WhiteElo":
-			game.WhiteElo = convertToInt(value)
+			game.WhiteElo, game.whiteEloRaw = pgnparse.ParseElo(value), value
 		case "BlackElo":
-			game.BlackElo = convertToInt(value)
+			game.BlackElo, game.blackEloRaw = pgnparse.ParseElo(value), value
 		case "ECO":
 			game.Eco = value
 		case "TimeControl":
 			game.TimeControl = value
 		case "Termination":
 			game.Termination = value
+		case "Variant":
+			game.Variant = value
+		case "Round":
+			game.Round = value
+		case "WhiteTitle":
+			game.WhiteTitle = value
+		case "BlackTitle":
+			game.BlackTitle = value
+		case "WhiteRatingDiff":
+			game.WhiteRatingDiff = convertToOptionalInt(value)
+		case "BlackRatingDiff":
+			game.BlackRatingDiff = convertToOptionalInt(value)
 		}
 	}
 
-	game.Moves = parseMovesFromPGN(data)
-	game.MovesCount = getMoveCount(data)
+	if len(duplicates) > 0 {
+		fmt.Printf("Duplicate tags in game %s: %s\n", game.Site, strings.Join(duplicates, ", "))
+	}
 
-	return game
+	// UTCDate goes with UTCTime, Date may be local
+	date := utcDate
+	if !pgnparse.ParseDate(date).Complete() && game.Date != "" {
+		date = game.Date
+	}
+	if playedAt, precision, ok := pgnparse.ApproxPlayedAt(date, game.Time); ok {
+		game.PlayedAt, game.PlayedAtPrecision = &playedAt, precision
+	}
+
+	// Keep the date tags as found in the file
+	for _, tag := range tags {
+		if tag.Name != "Date" && tag.Name != "UTCDate" && tag.Name != "UTCTime" {
+			continue
+		}
+		if game.ExtraTags == nil {
+			game.ExtraTags = make(map[string]string)
+		}
+		if _, kept := game.ExtraTags[tag.Name]; !kept {
+			game.ExtraTags[tag.Name] = tag.Value
+		}
+	}
+
+	if tournament, ok := lichess.ParseEvent(game.Event); ok {
+		game.Tournament = tournament.ID
+	}
+
+	result, ok := pgnparse.NormalizeResult(game.ResultRaw)
+	if !ok && game.ResultRaw != "" {
+		fmt.Printf("Unknown result %q in game %s\n", game.ResultRaw, game.Site)
+	}
+	game.Result = result
+
+	moves := pgnparse.Moves(data)
+
+	// Other variants can't be replayed with standard rules. The error is
+	// kept for --validate-moves
+	var summary replay.Summary
+	if pgnparse.IsStandardVariant(game.Variant) {
+		summary, game.replayErr = replay.Summarize(pgnparse.SANs(moves))
+		if game.replayErr != nil && !cfg.validateMoves {
+			fmt.Println("Failed to replay game:", game.replayErr)
+		}
+	}
+	if cfg.normalizeSAN {
+		// Store and hash the same SAN whatever notation the source used
+		for i, san := range summary.SANs {
+			moves[i].SAN = san
+		}
+	}
+
+	id, ok := gameid.FromURL(game.Site)
+	if !ok {
+		id, ok = gameid.FromURL(link)
+	}
+	if !ok {
+		id = gameid.Hash(game.White, game.Black, game.Date, game.Round, pgnparse.SANs(moves))
+	}
+	game.Source, game.SourceId, game.GameId = id.Source, id.Value, id.String()
+	game.MovesHash = gameid.MovesHash(pgnparse.SANs(moves))
+	game.ContentHash = gameid.ContentHash(game.White, game.Black, game.Date, pgnparse.SANs(moves))
+
+	game.PlyCount = len(moves)
+	game.MovesCount = (len(moves) + 1) / 2
+
+	if cfg.uciMoves {
+		game.UciMoves = summary.UCI
+	}
+	if cfg.zobrist {
+		game.Zobrist = summary.Zobrist
+	}
+	if cfg.positions {
+		game.Positions = summary.Positions
+	}
+	game.FinalFen = summary.FinalFEN
+	game.MaterialSignature = summary.MaterialSignature
+	game.MaxImbalance = summary.MaxImbalance
+	game.TerminationDetail = pgnparse.TerminationDetail(game.Termination, game.Result, summary.Ending)
+	game.Moves = pgnparse.Details(moves, summary.UCI)
+
+	if cfg.features {
+		game.Features = features.Vector(features.Game{
+			WhiteElo:    pgnparse.Elo(game.WhiteElo),
+			BlackElo:    pgnparse.Elo(game.BlackElo),
+			TimeControl: game.TimeControl,
+			Eco:         game.Eco,
+			Moves:       moves,
+		})
+	}
+
+	return game, nil
+}
+
+// convertToOptionalInt returns nil for missing or non-numeric values
+func convertToOptionalInt(s string) *int {
+	var n int
+	if _, err := fmt.Sscanf(s, "%d", &n); err != nil {
+		return nil
+	}
+	return &n
 }
 
-func getMoveCount(data string) int {
-	var moves []string
+// prepareCollection creates the collection with the --collection-layout,
+// existing collections are used as they are
+func prepareCollection(db *mongo.Database, name string) (*mongo.Collection, error) {
+	if cfg.layout == "plain" && cfg.schemaValidation == "off" && cfg.cappedSize == 0 {
+		return db.Collection(name), nil
+	}
 
-	// Regex to find moves
-	re := regexp.MustCompile(`\d+\.\s+([^\d]+)`)
-	matches := re.FindAllStringSubmatch(data, -1)
+	existing, err := db.ListCollectionNames(context.Background(), bson.M{"name": name})
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 {
+		if cfg.layout != "plain" || cfg.cappedSize > 0 {
+			fmt.Printf("Collection %s already exists, keeping its layout\n", name)
+		}
+		if cfg.schemaValidation != "off" {
+			// Validate the documents written from now on
+			err := db.RunCommand(context.Background(), bson.D{
+				{Key: "collMod", Value: name},
+				{Key: "validator", Value: bson.M{"$jsonSchema": gameSchema()}},
+				{Key: "validationLevel", Value: cfg.schemaValidation},
+				{Key: "validationAction", Value: "error"},
+			}).Err()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return db.Collection(name), nil
+	}
 
-	for _, match := range matches {
-		move := match[1]
-		// Trim Spaces
-		move = strings.TrimSpace(move)
-		// Split moves
-		moveParts := strings.Fields(move)
-		for _, part := range moveParts {
-			moves = append(moves, part)
+	opts := options.CreateCollection()
+	if cfg.schemaValidation != "off" {
+		opts.SetValidator(bson.M{"$jsonSchema": gameSchema()}).
+			SetValidationLevel(cfg.schemaValidation).
+			SetValidationAction("error")
+	}
+	// Capped collections drop their oldest games once full
+	if cfg.cappedSize > 0 {
+		opts.SetCapped(true).SetSizeInBytes(cfg.cappedSize << 20)
+		if cfg.cappedMax > 0 {
+			opts.SetMaxDocuments(cfg.cappedMax)
 		}
 	}
+	switch cfg.layout {
+	case "clustered":
+		// MongoDB only clusters on _id, so _id carries playedAt (timeOrderedID)
+		opts.SetClusteredIndex(bson.D{{Key: "key", Value: bson.D{{Key: "_id", Value: 1}}}, {Key: "unique", Value: true}})
+	case "timeseries":
+		// One bucket per ~month of games (MongoDB 6.3+)
+		month := 30 * 24 * time.Hour
+		opts.SetTimeSeriesOptions(options.TimeSeries().SetTimeField(cfg.fields.Name("playedAt")).SetBucketMaxSpan(month).SetBucketRounding(month))
+	}
+
+	if err := db.CreateCollection(context.Background(), name, opts); err != nil {
+		return nil, err
+	}
+	fmt.Printf("Created %s collection %s\n", cfg.layout, name)
+	return db.Collection(name), nil
+}
+
+// gameSchema is the $jsonSchema of Game documents for --schema-validation.
+// It checks the fields queries rely on; other fields are allowed, so a newer
+// importer can add some.
+func gameSchema() bson.M {
+	str := bson.M{"bsonType": "string"}
+	integer := bson.M{"bsonType": bson.A{"int", "long"}}
+	optionalDate := bson.M{"bsonType": bson.A{"date", "null"}}
+	stringArray := bson.M{"bsonType": bson.A{"array", "null"}, "items": str}
+
+	var required bson.A
+	for _, name := range []string{"source", "sourceId", "gameId", "white", "black", "result", "moves", "plyCount"} {
+		required = append(required, cfg.fields.Name(name))
+	}
 
-	moveCount := len(moves)
-	return moveCount - 1
+	return bson.M{
+		"bsonType": "object",
+		"required": required,
+		"properties": cfg.fields.Filter(bson.M{
+			"source":      bson.M{"enum": bson.A{"lichess", "chesscom", "hash"}},
+			"sourceId":    bson.M{"bsonType": "string", "minLength": 1},
+			"gameId":      bson.M{"bsonType": "string", "pattern": "^[a-z]+:.+$"},
+			"movesHash":   str,
+			"contentHash": str,
+			"white":       str,
+			"black":       str,
+			"result":      bson.M{"enum": bson.A{"1-0", "0-1", "1/2-1/2", "*"}},
+			"whiteElo":    bson.M{"bsonType": bson.A{"int", "long"}, "minimum": 1},
+			"blackElo":    bson.M{"bsonType": bson.A{"int", "long"}, "minimum": 1},
+			"moves": bson.M{
+				"bsonType": bson.A{"array", "null"},
+				"items": bson.M{
+					"bsonType": "object",
+					"required": bson.A{"ply", "san"},
+					"properties": bson.M{
+						"ply":     bson.M{"bsonType": bson.A{"int", "long"}, "minimum": 1},
+						"san":     bson.M{"bsonType": "string", "minLength": 1},
+						"uci":     str,
+						"clock":   bson.M{"bsonType": "double"},
+						"eval":    bson.M{"bsonType": "double"},
+						"comment": str,
+					},
+				},
+			},
+			"movesZstd":          bson.M{"bsonType": "binData"},
+			"positionsZstd":      bson.M{"bsonType": "binData"},
+			"uci_moves":          stringArray,
+			"positions":          stringArray,
+			"zobrist":            bson.M{"bsonType": bson.A{"array", "null"}, "items": bson.M{"bsonType": "long"}},
+			"plyCount":           bson.M{"bsonType": bson.A{"int", "long"}, "minimum": 0},
+			"moves_count":        integer,
+			"maxImbalance":       integer,
+			"termination_detail": str,
+			"playedAt":           optionalDate,
+			"playedAtPrecision":  bson.M{"enum": bson.A{"time", "day", "month", "year"}},
+			"eventDate":          optionalDate,
+			"board":              integer,
+			"importId":           str,
+		}),
+	}
 }
 
-func parseMovesFromPGN(gameString string) string {
-	lines := strings.Split(gameString, "\n")
-	var moves string
-	for _, line := range lines {
-		// Check start with int
-		if len(line) > 0 && line[0] >= '0' && line[0] <= '9' {
-			// Add to Line
-			moves += line + " "
+// applyDiff stores the game when it is new or its moves changed since the
+// stored version. Unchanged games are skipped.
+func applyDiff(collection *mongo.Collection, game *Game) outcome {
+	var existing bson.M
+	movesHash := cfg.fields.Name("movesHash")
+	filter := cfg.fields.Filter(bson.M{"source": game.Source, "sourceId": game.SourceId})
+	err := collection.FindOne(context.Background(), filter, options.FindOne().SetProjection(bson.M{movesHash: 1})).Decode(&existing)
+
+	var doc any
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		if doc, err = cfg.fields.Document(game); err == nil {
+			_, err = collection.InsertOne(context.Background(), doc)
+		}
+		if err == nil {
+			delta.added.Add(1)
+		}
+	case err != nil:
+	case existing[movesHash] == game.MovesHash:
+		delta.unchanged.Add(1)
+		return skipped
+	default:
+		game.ID = existing["_id"] // _id can't change
+		if doc, err = cfg.fields.Document(game); err == nil {
+			// gameId is the shard key with --shard-key
+			_, err = collection.ReplaceOne(context.Background(), cfg.fields.Filter(bson.M{"_id": game.ID, "gameId": game.GameId}), doc)
+		}
+		if err == nil {
+			delta.changed.Add(1)
+			fmt.Println("Changed game", game.GameId)
 		}
 	}
 
-	// Clean notations && Number moves
-	moves = regexp.MustCompile(`\{[^}]*\}|\b\d+\.|\d+-\d+|\.`).ReplaceAllString(moves, "")
-	moves = strings.Join(strings.Fields(moves), " ")
-	return moves
+	if err != nil {
+		fmt.Println("Failed to apply diff in MongoDB:", err)
+		failedGames.Add(1)
+		return failed
+	}
+	return stored
 }
 
-// ConvertToInt
-func convertToInt(s string) int {
-	var n int
-	fmt.Sscanf(s, "%d", &n)
-	return n
+// ensureGameIdIndex makes source + sourceId unique, so a game is stored once
+// however often it is imported (gameId, the same thing, on sharded collections).
+// Time series collections can't have unique indexes.
+func ensureGameIdIndex(collection *mongo.Collection) error {
+	if cfg.layout == "timeseries" {
+		return nil
+	}
+
+	// Unique indexes of sharded collections must start with the shard key.
+	// Games imported before ids existed have none; unfiltered they would all
+	// index as null and collide
+	keys, id := bson.D{{Key: "source", Value: 1}, {Key: "sourceId", Value: 1}}, "sourceId"
+	if cfg.shardKey != "" {
+		keys, id = bson.D{{Key: "gameId", Value: 1}}, "gameId"
+	}
+	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    cfg.fields.Keys(keys),
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(cfg.fields.Filter(bson.M{id: bson.M{"$exists": true}})),
+	})
+	if err != nil || !cfg.dedupeContent {
+		return err
+	}
+
+	// The same game from another file or site, e.g. overlapping TWIC issues.
+	// Games imported before content hashes existed have none.
+	_, err = collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    cfg.fields.Keys(bson.D{{Key: "contentHash", Value: 1}}),
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(cfg.fields.Filter(bson.M{"contentHash": bson.M{"$exists": true}})),
+	})
+	return err
+}
+
+// queryIndexes are created by --ensure-indexes
+var queryIndexes = []bson.D{
+	{{Key: "eco", Value: 1}},
+	{{Key: "opening", Value: 1}},
+	{{Key: "white", Value: 1}},
+	{{Key: "black", Value: 1}},
+	{{Key: "whiteElo", Value: 1}, {Key: "blackElo", Value: 1}},
+	{{Key: "date", Value: 1}},
+	{{Key: "playedAt", Value: 1}},
+	{{Key: "gameId", Value: 1}},
+}
+
+// ensureIndexes creates the indexes most queries need. It runs after the
+// import, so inserts don't have to maintain them; existing indexes are kept.
+func ensureIndexes(collection *mongo.Collection) error {
+	var models []mongo.IndexModel
+	for _, keys := range queryIndexes {
+		// The TTL index of --retention is the playedAt index
+		if cfg.retention > 0 && keys[0].Key == "playedAt" {
+			continue
+		}
+		models = append(models, mongo.IndexModel{Keys: cfg.fields.Keys(keys)})
+	}
+
+	for _, c := range withRoutes(collection) {
+		started := time.Now()
+		names, err := c.Indexes().CreateMany(context.Background(), models)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Indexes of %s ready in %s: %s\n", c.Name(), time.Since(started).Round(time.Second), strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// ensureRetention makes MongoDB delete games once playedAt is older than
+// --retention: a TTL index, or the expiry of a time series collection.
+// Changing --retention updates the existing index.
+func ensureRetention(collection *mongo.Collection) error {
+	ctx := context.Background()
+	db := collection.Database()
+	seconds := int32(cfg.retention.Seconds())
+
+	if cfg.layout == "timeseries" {
+		return db.RunCommand(ctx, bson.D{{Key: "collMod", Value: collection.Name()}, {Key: "expireAfterSeconds", Value: seconds}}).Err()
+	}
+
+	keys := cfg.fields.Keys(bson.D{{Key: "playedAt", Value: 1}})
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: keys, Options: options.Index().SetExpireAfterSeconds(seconds)})
+	var commandErr mongo.CommandError
+	if errors.As(err, &commandErr) && (commandErr.Code == 85 || commandErr.Code == 86) {
+		// IndexOptionsConflict / IndexKeySpecsConflict: the index exists
+		// with another expiry or none (MongoDB 5.1+ converts it)
+		err = db.RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: collection.Name()},
+			{Key: "index", Value: bson.D{{Key: "keyPattern", Value: keys}, {Key: "expireAfterSeconds", Value: seconds}}},
+		}).Err()
+	}
+	return err
+}
+
+// ensureSearchIndex creates or updates the Atlas Search index of
+// --search-index over player names, events and openings. Names can also be
+// searched as you type (autocomplete). Atlas builds it in the background.
+func ensureSearchIndex(collection *mongo.Collection) error {
+	ctx := context.Background()
+	text := bson.M{"type": "string"}
+	name := bson.A{text, bson.M{"type": "autocomplete", "tokenization": "edgeGram", "minGrams": 2, "maxGrams": 15, "foldDiacritics": true}}
+	definition := bson.M{"mappings": bson.M{
+		"dynamic": false,
+		"fields": cfg.fields.Filter(bson.M{
+			"white":     name,
+			"black":     name,
+			"event":     text,
+			"opening":   text,
+			"variation": text,
+			"eco":       bson.M{"type": "token"},
+		}),
+	}}
+
+	for _, c := range withRoutes(collection) {
+		cursor, err := c.SearchIndexes().List(ctx, options.SearchIndexes().SetName(cfg.searchIndex))
+		if err != nil {
+			return err
+		}
+		exists := cursor.Next(ctx)
+		cursor.Close(ctx)
+
+		if exists {
+			err = c.SearchIndexes().UpdateOne(ctx, cfg.searchIndex, definition)
+		} else {
+			_, err = c.SearchIndexes().CreateOne(ctx, mongo.SearchIndexModel{
+				Definition: definition,
+				Options:    options.SearchIndexes().SetName(cfg.searchIndex),
+			})
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Search index %s of %s submitted\n", cfg.searchIndex, c.Name())
+	}
+	return nil
+}
+
+// shardCollection shards the collection on gameId, hashed or ranged, before
+// the bulk load. Hashed keys spread the inserts of every batch over all
+// shards; empty collections are pre-split into --initial-chunks chunks.
+func shardCollection(collection *mongo.Collection) error {
+	ctx := context.Background()
+	db := collection.Database()
+	admin := db.Client().Database("admin")
+
+	if err := admin.RunCommand(ctx, bson.D{{Key: "enableSharding", Value: db.Name()}}).Err(); err != nil {
+		return err
+	}
+
+	key := bson.D{{Key: "gameId", Value: "hashed"}}
+	if cfg.shardKey == "range" {
+		key = bson.D{{Key: "gameId", Value: 1}}
+	}
+	command := bson.D{{Key: "shardCollection", Value: db.Name() + "." + collection.Name()}, {Key: "key", Value: cfg.fields.Keys(key)}}
+	if cfg.shardKey == "hashed" && cfg.initialChunks > 0 {
+		command = append(command, bson.E{Key: "numInitialChunks", Value: cfg.initialChunks})
+	}
+	if err := admin.RunCommand(ctx, command).Err(); err != nil {
+		return err
+	}
+	fmt.Printf("Sharded %s on gameId (%s)\n", collection.Name(), cfg.shardKey)
+	return nil
+}
+
+// timeOrderedID is an ObjectID whose timestamp is the time the game was played
+// instead of the insert time, so a clustered collection is stored in playedAt
+// order and date ranges become _id ranges. Games before 1970 share timestamp 0.
+func timeOrderedID(playedAt time.Time) primitive.ObjectID {
+	id := primitive.NewObjectID()
+	binary.BigEndian.PutUint32(id[0:4], uint32(max(playedAt.Unix(), 0)))
+	return id
+}
+
+// collectionStats aggregates the stored games (and those of --routes) like
+// the import summary, plus ECO codes, time controls, results and duplicates
+func collectionStats(collection *mongo.Collection) (stats.Snapshot, error) {
+	snapshot := stats.NewSnapshot()
+	snapshot.Ecos = make(map[string]int)
+	snapshot.TimeControls = make(map[string]int)
+	snapshot.Results = make(map[string]int)
+
+	field := func(name string) string { return "$" + cfg.fields.Name(name) }
+	countBy := func(name string) bson.A {
+		return bson.A{bson.M{"$group": bson.M{"_id": field(name), "n": bson.M{"$sum": 1}}}}
+	}
+	pipeline := mongo.Pipeline{{{Key: "$facet", Value: bson.M{
+		"total":       bson.A{bson.M{"$count": "n"}},
+		"eco":         countBy("eco"),
+		"timeControl": countBy("time_control"),
+		"result":      countBy("result"),
+		"elo": bson.A{
+			bson.M{"$project": bson.M{"elo": bson.A{field("whiteElo"), field("blackElo")}}},
+			bson.M{"$unwind": "$elo"},
+			bson.M{"$match": bson.M{"elo": bson.M{"$gt": 0}}},
+			bson.M{"$group": bson.M{
+				"_id": bson.M{"$toInt": bson.M{"$subtract": bson.A{"$elo", bson.M{"$mod": bson.A{"$elo", stats.EloBucket}}}}},
+				"n":   bson.M{"$sum": 1},
+			}},
+		},
+		// Every copy after the first of the same content
+		"duplicates": bson.A{
+			bson.M{"$group": bson.M{"_id": field("contentHash"), "n": bson.M{"$sum": 1}}},
+			bson.M{"$match": bson.M{"n": bson.M{"$gt": 1}}},
+			bson.M{"$group": bson.M{"_id": nil, "n": bson.M{"$sum": bson.M{"$subtract": bson.A{"$n", 1}}}}},
+		},
+	}}}}
+
+	type count struct {
+		ID any `bson:"_id"`
+		N  int `bson:"n"`
+	}
+	for _, c := range withRoutes(collection) {
+		cursor, err := c.Aggregate(context.Background(), pipeline, options.Aggregate().SetAllowDiskUse(true))
+		if err != nil {
+			return snapshot, err
+		}
+		var facets []struct {
+			Total       []count `bson:"total"`
+			Eco         []count `bson:"eco"`
+			TimeControl []count `bson:"timeControl"`
+			Result      []count `bson:"result"`
+			Elo         []struct {
+				ID int `bson:"_id"`
+				N  int `bson:"n"`
+			} `bson:"elo"`
+			Duplicates []count `bson:"duplicates"`
+		}
+		if err := cursor.All(context.Background(), &facets); err != nil {
+			return snapshot, err
+		}
+		if len(facets) == 0 {
+			continue
+		}
+		f := facets[0]
+
+		for _, t := range f.Total {
+			snapshot.Games += t.N
+		}
+		for _, e := range f.Eco {
+			eco, _ := e.ID.(string)
+			family := "?"
+			if eco != "" {
+				family = strings.ToUpper(eco[:1])
+				snapshot.Ecos[eco] += e.N
+			}
+			snapshot.EcoFamilies[family] += e.N
+		}
+		for _, t := range f.TimeControl {
+			timeControl, _ := t.ID.(string)
+			speed := pgnparse.Speed(timeControl)
+			if speed == "" {
+				speed = "unknown"
+			}
+			snapshot.Speeds[speed] += t.N
+			if timeControl != "" {
+				snapshot.TimeControls[timeControl] += t.N
+			}
+		}
+		for _, r := range f.Result {
+			snapshot.Results[fmt.Sprint(r.ID)] += r.N
+		}
+		for _, e := range f.Elo {
+			snapshot.EloHistogram[e.ID] += e.N
+		}
+		for _, d := range f.Duplicates {
+			snapshot.Duplicates += d.N
+		}
+	}
+	return snapshot, nil
+}
+
+// printStats prints the aggregates and saves them to the stats file
+func printStats() {
+	snapshot := aggregates.Snapshot()
+	fmt.Print(snapshot)
+
+	if cfg.statsFile == "" {
+		return
+	}
+	if err := snapshot.WriteFile(cfg.statsFile); err != nil {
+		fmt.Println("Failed to write stats file:", err)
+	}
 }
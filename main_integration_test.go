@@ -0,0 +1,75 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"importGames/gamecheck"
+	"importGames/report"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TestImportCorpus imports the bundled corpus into a throwaway MongoDB
+// container with the default options and checks the stored games
+func TestImportCorpus(t *testing.T) {
+	ctx := context.Background()
+	uri := startMongo(t)
+	folder := writeCorpus(t)
+
+	if err := loadConfig(nil); err != nil {
+		t.Fatal(err)
+	}
+	// The corpus has a malformed game, which must be skipped
+	cfg.strict = false
+	importReport, _ = report.Open("")
+	cfg.routes = nil
+	cfg.fields = nil
+	cfg.perMonth = false
+	checks = gamecheck.Checks{
+		ResultMismatch: cfg.resultMismatch,
+		EloCheck:       cfg.eloCheck,
+		EloLimits:      cfg.eloLimits,
+		Report:         importReport,
+	}
+
+	client, err := mongo.Connect(ctx, cfg.mongo.ClientOptions(uri))
+	if err != nil {
+		t.Fatal("Failed to connect to MongoDB:", err)
+	}
+	defer client.Disconnect(ctx)
+
+	collection, err := prepareCollection(client.Database(selftestTable), "games")
+	if err == nil {
+		err = ensureGameIdIndex(collection)
+	}
+	if err != nil {
+		t.Fatal("Failed to create collection:", err)
+	}
+	if output, err = openOutput(collection.Database()); err != nil {
+		t.Fatal("Failed to open output:", err)
+	}
+
+	importFolder(folder, collection)
+	closeOutput()
+
+	count, err := collection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		t.Fatal("Failed to count games:", err)
+	}
+
+	verifyCorpus(t, int(count), func(c selftestCheck) (string, error) {
+		var doc bson.M
+		if err := collection.FindOne(ctx, bson.M{"white": c.White}).Decode(&doc); err != nil {
+			return "", err
+		}
+		if value, ok := doc[c.Field]; ok && value != nil {
+			return fmt.Sprint(value), nil
+		}
+		return "", nil
+	})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		err  bool
+	}{
+		{"defaults", nil, false},
+		{"hashed shard key", []string{"--shard-key=hashed"}, false},
+		{"range shard key", []string{"--shard-key=range"}, false},
+		{"unknown shard key", []string{"--shard-key=zoned"}, true},
+		{"sharded clustered collection", []string{"--shard-key=hashed", "--collection-layout=clustered"}, true},
+		{"sharded with content dedupe", []string{"--shard-key=range", "--dedupe-content"}, true},
+		{"upsert into time series", []string{"--upsert", "--collection-layout=timeseries"}, true},
+		{"unknown layout", []string{"--collection-layout=capped"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// loadConfig defines its flags on the global flag set
+			flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+			cfg = config{}
+
+			err := loadConfig(tt.args)
+			if (err != nil) != tt.err {
+				t.Errorf("loadConfig(%q) = %v, want error: %v", tt.args, err, tt.err)
+			}
+		})
+	}
+}
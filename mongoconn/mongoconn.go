@@ -0,0 +1,129 @@
+// Package mongoconn holds the MongoDB client flags shared by the tools
+// (main.go and update_moves.go): write concern, pool, timeouts, read preference
+package mongoconn
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	"importGames/env"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// Config is read from the flags, which default to their .env values
+type Config struct {
+	WriteConcern           *writeconcern.WriteConcern // nil for the server default
+	RetryWrites            bool
+	Compressors            []string
+	MaxPoolSize            int
+	ConnectTimeout         time.Duration // 0 for the driver defaults
+	SocketTimeout          time.Duration
+	ServerSelectionTimeout time.Duration
+	ReadPreference         *readpref.ReadPref // nil for primary
+
+	// Raw flag values, checked by Parse
+	writeConcern, journal, compressors, readPreference *string
+}
+
+// RegisterFlags defines the flags on the default flag set. Call Parse once
+// they are parsed.
+func (c *Config) RegisterFlags() {
+	c.writeConcern = flag.String("write-concern", env.String("WRITE_CONCERN", ""), "write concern: majority or a number of nodes (1, 0 for unacknowledged), default the server's")
+	c.journal = flag.String("journal", env.String("JOURNAL", ""), "true to wait for the on-disk journal, false not to, default the server's")
+	flag.BoolVar(&c.RetryWrites, "retry-writes", env.Bool("RETRY_WRITES", true), "retry writes once after network errors and elections")
+	c.compressors = flag.String("compressors", env.String("COMPRESSORS", ""), "wire compression, in order of preference: zstd, snappy, zlib")
+	flag.IntVar(&c.MaxPoolSize, "max-pool-size", env.Int("MAX_POOL_SIZE", 0), "largest number of connections to MongoDB, 0 for the driver default (100)")
+	flag.DurationVar(&c.ConnectTimeout, "connect-timeout", duration("CONNECT_TIMEOUT"), "timeout for opening a connection, 0 for the driver default (30s)")
+	flag.DurationVar(&c.SocketTimeout, "socket-timeout", duration("SOCKET_TIMEOUT"), "timeout for a read or write on a connection, 0 for none")
+	flag.DurationVar(&c.ServerSelectionTimeout, "server-selection-timeout", duration("SERVER_SELECTION_TIMEOUT"), "how long to wait for a suitable server, 0 for the driver default (30s)")
+	c.readPreference = flag.String("read-preference", env.String("READ_PREFERENCE", ""), "where reads go: primary, primaryPreferred, secondary, secondaryPreferred or nearest, default primary")
+}
+
+// duration reads a duration setting, 0 when it is not set or invalid
+func duration(key string) time.Duration {
+	d, err := time.ParseDuration(env.String(key, "0s"))
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// Parse checks the flags
+func (c *Config) Parse() error {
+	if *c.writeConcern != "" || *c.journal != "" {
+		c.WriteConcern = &writeconcern.WriteConcern{}
+		switch w := *c.writeConcern; w {
+		case "":
+		case "majority":
+			c.WriteConcern.W = w
+		default:
+			n, err := strconv.Atoi(w)
+			if err != nil || n < 0 {
+				return fmt.Errorf("unknown write concern %q", w)
+			}
+			c.WriteConcern.W = n
+		}
+		if *c.journal != "" {
+			j, err := strconv.ParseBool(*c.journal)
+			if err != nil {
+				return fmt.Errorf("invalid --journal %q", *c.journal)
+			}
+			c.WriteConcern.Journal = &j
+		}
+	}
+
+	c.Compressors = env.SplitList(*c.compressors)
+	for _, compressor := range c.Compressors {
+		switch compressor {
+		case "zstd", "snappy", "zlib":
+		default:
+			return fmt.Errorf("unknown compressor %q", compressor)
+		}
+	}
+	if c.MaxPoolSize < 0 || c.ConnectTimeout < 0 || c.SocketTimeout < 0 || c.ServerSelectionTimeout < 0 {
+		return fmt.Errorf("--max-pool-size and the timeouts can't be negative")
+	}
+
+	if *c.readPreference != "" {
+		mode, err := readpref.ModeFromString(*c.readPreference)
+		if err == nil {
+			c.ReadPreference, err = readpref.New(mode)
+		}
+		if err != nil {
+			return fmt.Errorf("unknown read preference %q", *c.readPreference)
+		}
+	}
+	return nil
+}
+
+// ClientOptions applies the flags over the URI options
+func (c *Config) ClientOptions(uri string) *options.ClientOptions {
+	opts := options.Client().ApplyURI(uri).SetRetryWrites(c.RetryWrites)
+	if c.WriteConcern != nil {
+		opts.SetWriteConcern(c.WriteConcern)
+	}
+	if len(c.Compressors) > 0 {
+		opts.SetCompressors(c.Compressors)
+	}
+	if c.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(uint64(c.MaxPoolSize))
+	}
+	if c.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(c.ConnectTimeout)
+	}
+	if c.SocketTimeout > 0 {
+		opts.SetSocketTimeout(c.SocketTimeout)
+	}
+	if c.ServerSelectionTimeout > 0 {
+		opts.SetServerSelectionTimeout(c.ServerSelectionTimeout)
+	}
+	if c.ReadPreference != nil {
+		opts.SetReadPreference(c.ReadPreference)
+	}
+	return opts
+}
@@ -0,0 +1,60 @@
+// Package packed stores the large arrays of a game (moves, positions) as
+// zstd-compressed BSON binaries, and decodes them back for readers. Compress
+// and Decompress are plain zstd, for raw bytes like the PGN in Postgres.
+package packed
+
+import (
+	"errors"
+
+	"github.com/klauspost/compress/zstd"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Subtype marks the binaries, from the user defined range
+const Subtype = 0x80
+
+// Both are safe for concurrent EncodeAll / DecodeAll calls
+var (
+	encoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+	decoder, _ = zstd.NewReader(nil)
+)
+
+// Encode compresses an array (or any value) into a binary. nil stays nil.
+func Encode(v any) (*primitive.Binary, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := bson.Marshal(bson.M{"v": v})
+	if err != nil {
+		return nil, err
+	}
+	return &primitive.Binary{Subtype: Subtype, Data: encoder.EncodeAll(data, nil)}, nil
+}
+
+// Decode reads a binary made by Encode into v, e.g. a *[]pgnparse.MoveDetail
+// for movesZstd or a *[]string for positionsZstd
+func Decode(b primitive.Binary, v any) error {
+	if b.Subtype != Subtype {
+		return errors.New("not a packed binary")
+	}
+	data, err := decoder.DecodeAll(b.Data, nil)
+	if err != nil {
+		return err
+	}
+	raw, err := bson.Raw(data).LookupErr("v")
+	if err != nil {
+		return err
+	}
+	return raw.Unmarshal(v)
+}
+
+// Compress returns data as one zstd frame
+func Compress(data []byte) []byte {
+	return encoder.EncodeAll(data, nil)
+}
+
+// Decompress reads what Compress returned
+func Decompress(data []byte) ([]byte, error) {
+	return decoder.DecodeAll(data, nil)
+}
@@ -0,0 +1,73 @@
+package packed
+
+import (
+	"reflect"
+	"testing"
+
+	"importGames/pgnparse"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRoundTrip(t *testing.T) {
+	clock := 59.5
+	moves := []pgnparse.MoveDetail{{Ply: 1, SAN: "e4", UCI: "e2e4", Clock: &clock}, {Ply: 2, SAN: "e5", UCI: "e7e5", Comment: "book"}}
+	positions := []string{
+		"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1",
+		"rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2",
+	}
+
+	tests := []struct {
+		name string
+		in   any
+		out  any // pointer to a zero value of the decoded type
+	}{
+		{"moves", moves, &[]pgnparse.MoveDetail{}},
+		{"positions", positions, &[]string{}},
+		{"empty", []string{}, &[]string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := Encode(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.Subtype != Subtype {
+				t.Errorf("Encode subtype = %#x, want %#x", b.Subtype, Subtype)
+			}
+			if err := Decode(*b, tt.out); err != nil {
+				t.Fatal(err)
+			}
+			if got := reflect.ValueOf(tt.out).Elem().Interface(); !reflect.DeepEqual(got, tt.in) {
+				t.Errorf("Decode = %v, want %v", got, tt.in)
+			}
+		})
+	}
+}
+
+func TestEncodeNil(t *testing.T) {
+	b, err := Encode(nil)
+	if b != nil || err != nil {
+		t.Errorf("Encode(nil) = %v, %v, want nil, nil", b, err)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		b    primitive.Binary
+	}{
+		{"other subtype", primitive.Binary{Subtype: 0x00, Data: []byte{1, 2, 3}}},
+		{"not zstd", primitive.Binary{Subtype: Subtype, Data: []byte("not compressed")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var positions []string
+			if err := Decode(tt.b, &positions); err == nil {
+				t.Error("Decode succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+// Package parquetdir writes games into Hive partitioned Parquet files,
+// <dir>/<table>/<key>=<value>/<name>.parquet, which DuckDB, pandas and
+// Spark read without a database server
+package parquetdir
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"importGames/eco"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// rowGroupRows are buffered per file before they are written as a row
+// group, bounding the memory of partitions receiving many games
+const rowGroupRows = 50_000
+
+// Row is a game, with the column names of the Postgres games table.
+// Moves and tags are lists and maps, evals and clocks lists with nulls
+// for the moves without them.
+type Row struct {
+	GameId          string            `parquet:"game_id"`
+	Source          string            `parquet:"source"`
+	SourceId        string            `parquet:"source_id"`
+	LichessId       *string           `parquet:"lichess_id"`
+	SourceFile      *string           `parquet:"source_file"`
+	SourceOffset    *int64            `parquet:"source_offset"`
+	ImportJobId     *string           `parquet:"import_job_id"`
+	Event           string            `parquet:"event"`
+	TournamentId    *string           `parquet:"tournament_id"`
+	EventDate       *time.Time        `parquet:"event_date,date"`
+	Round           string            `parquet:"round"`
+	Date            *time.Time        `parquet:"date,date"`
+	DateYear        *int32            `parquet:"date_year"`
+	DateMonth       *int32            `parquet:"date_month"`
+	DateDay         *int32            `parquet:"date_day"`
+	Time            *string           `parquet:"time"`
+	PlayedAt        *time.Time        `parquet:"played_at,timestamp(millisecond)"`
+	White           string            `parquet:"white"`
+	Black           string            `parquet:"black"`
+	WhiteElo        *int32            `parquet:"white_elo"`
+	BlackElo        *int32            `parquet:"black_elo"`
+	WhiteTitle      string            `parquet:"white_title"`
+	BlackTitle      string            `parquet:"black_title"`
+	WhiteRatingDiff *int32            `parquet:"white_rating_diff"`
+	BlackRatingDiff *int32            `parquet:"black_rating_diff"`
+	Result          string            `parquet:"result"`
+	Termination     string            `parquet:"termination"`
+	TimeControl     string            `parquet:"time_control"`
+	Variant         string            `parquet:"variant"`
+	Eco             *string           `parquet:"eco"` // see Eco
+	Opening         string            `parquet:"opening"`
+	MovesCount      int32             `parquet:"moves_count"`
+	PlyCount        int32             `parquet:"ply_count"`
+	Moves           []string          `parquet:"moves,list"`
+	UciMoves        []string          `parquet:"uci_moves,list"`
+	Evals           []*float32        `parquet:"evals,list"`
+	Clocks          []*int32          `parquet:"clocks,list"`
+	Analyzed        bool              `parquet:"analyzed"`
+	FinalFen        *string           `parquet:"final_fen"`
+	MaxImbalance    int32             `parquet:"max_imbalance"`
+	MovesHash       string            `parquet:"moves_hash"`
+	ContentHash     string            `parquet:"content_hash"`
+	Features        []float64         `parquet:"features,list"`
+	Tags            map[string]string `parquet:"tags"`
+}
+
+// Eco is the eco column of a game with this ECO tag: nil for "?", "" and
+// made up codes, so it always joins with eco_codes
+func Eco(code string) *string {
+	if _, ok := eco.Name(code); !ok {
+		return nil
+	}
+	return &code
+}
+
+// Dir is a directory of partitioned tables, safe for concurrent writes
+type Dir struct {
+	path string
+	key  string // partition column, month or eco_code
+	name string // file name in every partition, so runs add files
+
+	mu    sync.Mutex
+	files map[string]*file // by table/key=value
+}
+
+type file struct {
+	mu       sync.Mutex
+	table    string
+	f        *os.File
+	writer   *parquet.GenericWriter[Row]
+	buffered int
+}
+
+// Open creates the directory, with tables partitioned by "month" or "eco".
+// Every partition gets a name.parquet file.
+func Open(path string, partitionBy string, name string) (*Dir, error) {
+	key := "month"
+	switch partitionBy {
+	case "month":
+	case "eco":
+		key = "eco_code" // eco is a column already
+	default:
+		return nil, fmt.Errorf("unknown Parquet partitioning %q", partitionBy)
+	}
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, err
+	}
+	return &Dir{path: path, key: key, name: name, files: make(map[string]*file)}, nil
+}
+
+// Write appends rows to their partitions of a table. Nothing finds rows
+// written before, duplicates are left to the queries.
+func (d *Dir) Write(table string, rows []Row) error {
+	partitions := make(map[string][]Row)
+	for _, row := range rows {
+		value := d.partition(row)
+		partitions[value] = append(partitions[value], row)
+	}
+	for value, partition := range partitions {
+		if err := d.write(table, value, partition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// partition is the value of the partition column for a row: its ECO code,
+// "unknown" without a known one, or the month of its date, "undated"
+// without a year and month
+func (d *Dir) partition(row Row) string {
+	if d.key == "eco_code" {
+		if row.Eco == nil {
+			return "unknown"
+		}
+		return *row.Eco
+	}
+	if row.DateYear == nil || row.DateMonth == nil {
+		return "undated"
+	}
+	return fmt.Sprintf("%04d-%02d", *row.DateYear, *row.DateMonth)
+}
+
+// write appends rows to the partition of a table with the given value
+func (d *Dir) write(table string, value string, rows []Row) error {
+	f, err := d.file(table, value)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.writer.Write(rows); err != nil {
+		return err
+	}
+	f.buffered += len(rows)
+	if f.buffered < rowGroupRows {
+		return nil
+	}
+	f.buffered = 0
+	return f.writer.Flush()
+}
+
+// file opens the file of a partition, the first time
+func (d *Dir) file(table string, value string) (*file, error) {
+	partition := filepath.Join(table, d.key+"="+value)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if f := d.files[partition]; f != nil {
+		return f, nil
+	}
+
+	if err := os.MkdirAll(filepath.Join(d.path, partition), 0o755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(d.path, partition, d.name+".parquet")
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("%s exists, use another name", path)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	d.files[partition] = &file{table: table, f: f, writer: parquet.NewGenericWriter[Row](f, parquet.Compression(&parquet.Zstd))}
+	return d.files[partition], nil
+}
+
+// Tables lists the tables written so far
+func (d *Dir) Tables() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var tables []string
+	for _, f := range d.files {
+		if !slices.Contains(tables, f.table) {
+			tables = append(tables, f.table)
+		}
+	}
+	slices.Sort(tables)
+	return tables
+}
+
+// Glob matches the files of a table, for read_parquet
+func (d *Dir) Glob(table string) string {
+	return filepath.Join(d.path, table, "*", "*.parquet")
+}
+
+// LoadDuckDB loads the tables (with the files of earlier runs too) and
+// eco_codes into a DuckDB database with the duckdb command, replacing the
+// tables it had. Tables rather than views over the files, so the database
+// is complete on its own.
+func (d *Dir) LoadDuckDB(database string) error {
+	var script strings.Builder
+	script.WriteString("CREATE OR REPLACE TABLE eco_codes (code VARCHAR PRIMARY KEY, name VARCHAR NOT NULL);\n")
+	for _, c := range eco.Codes() {
+		fmt.Fprintf(&script, "INSERT INTO eco_codes VALUES (%s, %s);\n", literal(c.Code), literal(c.Name))
+	}
+	for _, table := range d.Tables() {
+		fmt.Fprintf(&script, "CREATE OR REPLACE TABLE \"%s\" AS SELECT * FROM read_parquet(%s, hive_partitioning = true);\n",
+			strings.ReplaceAll(table, `"`, `""`), literal(filepath.ToSlash(d.Glob(table))))
+	}
+	cmd := exec.Command("duckdb", database)
+	cmd.Stdin = strings.NewReader(script.String())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("duckdb: %w: %s", err, out)
+	}
+	return nil
+}
+
+// literal quotes a SQL string
+func literal(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// Close writes the footers. A Parquet file without one can't be read.
+func (d *Dir) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var errs []error
+	for _, f := range d.files {
+		errs = append(errs, f.writer.Close(), f.f.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,39 @@
+package parquetdir
+
+import "testing"
+
+func TestPartition(t *testing.T) {
+	year, month := int32(2024), int32(1)
+
+	tests := []struct {
+		name        string
+		partitionBy string
+		row         Row
+		want        string
+	}{
+		{"month", "month", Row{DateYear: &year, DateMonth: &month}, "2024-01"},
+		{"year only", "month", Row{DateYear: &year}, "undated"},
+		{"no date", "month", Row{}, "undated"},
+		{"eco", "eco", Row{Eco: Eco("B90")}, "B90"},
+		{"unknown eco", "eco", Row{Eco: Eco("?")}, "unknown"},
+		{"made up eco", "eco", Row{Eco: Eco("Z99")}, "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Open(t.TempDir(), tt.partitionBy, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := d.partition(tt.row); got != tt.want {
+				t.Errorf("partition = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenPartitioning(t *testing.T) {
+	if _, err := Open(t.TempDir(), "source", "test"); err == nil {
+		t.Error("Open accepted partitions by source")
+	}
+}
@@ -0,0 +1,135 @@
+// Package pgcopy writes rows in the formats COPY FROM reads (csv and text),
+// so tables can be loaded with psql's \copy or any COPY tooling
+package pgcopy
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Writer writes rows of one table
+type Writer struct {
+	w      *bufio.Writer
+	format string // "csv" or "text"
+}
+
+// NewWriter checks the format, csv or text (tab separated)
+func NewWriter(w io.Writer, format string) (*Writer, error) {
+	if format != "csv" && format != "text" {
+		return nil, fmt.Errorf("unknown COPY format %q", format)
+	}
+	return &Writer{bufio.NewWriter(w), format}, nil
+}
+
+// Options are the COPY options reading what the writer wrote
+func Options(format string) string {
+	if format == "csv" {
+		return "FORMAT csv, HEADER"
+	}
+	return "FORMAT text"
+}
+
+// Header writes the column names, csv only: text has no header before
+// Postgres 15
+func (w *Writer) Header(names []string) error {
+	if w.format != "csv" {
+		return nil
+	}
+	_, err := w.w.WriteString(strings.Join(names, ",") + "\n")
+	return err
+}
+
+// Write writes one row. types are the SQL types of the columns, which say
+// how byte slices (bytea or JSON) and times are written.
+func (w *Writer) Write(values []any, types []string) error {
+	separator := ","
+	if w.format == "text" {
+		separator = "\t"
+	}
+	for i, v := range values {
+		if i > 0 {
+			w.w.WriteString(separator)
+		}
+		text, ok := Value(v, types[i])
+		switch {
+		case w.format == "text" && !ok:
+			w.w.WriteString(`\N`)
+		case w.format == "text":
+			w.w.WriteString(textEscaper.Replace(text))
+		case ok:
+			// Quoted, so empty strings aren't read as NULL
+			w.w.WriteString(`"` + strings.ReplaceAll(text, `"`, `""`) + `"`)
+		}
+	}
+	_, err := w.w.WriteString("\n")
+	return err
+}
+
+// Flush writes the buffered rows
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// Value is the text Postgres reads for v in a column of the SQL type, false
+// for NULL
+func Value(v any, sqlType string) (string, bool) {
+	sqlType = strings.ToUpper(sqlType)
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return "", false
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return "", false
+	}
+
+	switch value := rv.Interface().(type) {
+	case []byte:
+		if value == nil {
+			return "", false
+		}
+		if strings.HasPrefix(sqlType, "BYTEA") {
+			return `\x` + hex.EncodeToString(value), true
+		}
+		return string(value), true // JSON
+	case time.Time:
+		switch {
+		case strings.HasPrefix(sqlType, "DATE"):
+			return value.Format("2006-01-02"), true
+		case strings.HasPrefix(sqlType, "TIMESTAMP"):
+			return value.Format(time.RFC3339Nano), true
+		case strings.HasPrefix(sqlType, "TIME"):
+			return value.Format("15:04:05.999999"), true
+		}
+		return value.Format(time.RFC3339Nano), true
+	}
+
+	if rv.Kind() == reflect.Slice {
+		if rv.IsNil() {
+			return "", false
+		}
+		elementType, _, _ := strings.Cut(sqlType, "[]")
+		elements := make([]string, rv.Len())
+		for i := range elements {
+			text, ok := Value(rv.Index(i).Interface(), elementType)
+			if !ok {
+				elements[i] = "NULL"
+				continue
+			}
+			elements[i] = `"` + arrayEscaper.Replace(text) + `"`
+		}
+		return "{" + strings.Join(elements, ",") + "}", true
+	}
+	return fmt.Sprint(rv.Interface()), true
+}
+
+var arrayEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
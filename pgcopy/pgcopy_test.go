@@ -0,0 +1,87 @@
+package pgcopy
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValue(t *testing.T) {
+	played := time.Date(2024, 3, 9, 14, 5, 30, 0, time.UTC)
+	elo := 1500
+	var noElo *int
+
+	tests := []struct {
+		name    string
+		v       any
+		sqlType string
+		want    string
+		ok      bool
+	}{
+		{"nil", nil, "TEXT", "", false},
+		{"nil pointer", noElo, "INTEGER", "", false},
+		{"pointer", &elo, "INTEGER", "1500", true},
+		{"string", "Carlsen, Magnus", "TEXT", "Carlsen, Magnus", true},
+		{"empty string", "", "TEXT", "", true},
+		{"bool", true, "BOOLEAN", "true", true},
+		{"date", played, "DATE", "2024-03-09", true},
+		{"timestamp", played, "TIMESTAMPTZ", "2024-03-09T14:05:30Z", true},
+		{"time", played, "TIME", "14:05:30", true},
+		{"bytea", []byte{0xde, 0xad}, "BYTEA", `\xdead`, true},
+		{"json", []byte(`{"a":1}`), "JSONB", `{"a":1}`, true},
+		{"nil bytes", []byte(nil), "BYTEA", "", false},
+		{"text array", []string{"e4", `say "hi"`, `a\b`}, "TEXT[]", `{"e4","say \"hi\"","a\\b"}`, true},
+		{"array with NULL", []*int{&elo, nil}, "INTEGER[]", `{"1500",NULL}`, true},
+		{"nil array", []string(nil), "TEXT[]", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Value(tt.v, tt.sqlType)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("Value = %q, %v, want %q, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	types := []string{"TEXT", "TEXT", "INTEGER"}
+	row := []any{"Smith, John", "line\twith \"tab\"\n", nil}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"csv", "white,comment,elo\n\"Smith, John\",\"line\twith \"\"tab\"\"\n\",\n"},
+		{"text", "Smith, John\tline\\twith \"tab\"\\n\t\\N\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var out strings.Builder
+			w, err := NewWriter(&out, tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Header([]string{"white", "comment", "elo"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Write(row, types); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("wrote %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestNewWriterFormat(t *testing.T) {
+	if _, err := NewWriter(&strings.Builder{}, "binary"); err == nil {
+		t.Error("NewWriter accepted the binary format")
+	}
+}
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS import_errors (
+	id SERIAL PRIMARY KEY,
+	file TEXT,
+	game INTEGER,
+	byte_offset BIGINT,
+	error TEXT,
+	parser_version TEXT,
+	created_at TIMESTAMPTZ DEFAULT now()
+);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS tournaments (
+	id TEXT PRIMARY KEY,
+	kind TEXT,
+	name TEXT,
+	starts_at TIMESTAMPTZ
+);
+CREATE TABLE IF NOT EXISTS tournament_standings (
+	tournament_id TEXT REFERENCES tournaments (id) ON DELETE CASCADE,
+	rank INTEGER,
+	username TEXT,
+	title TEXT,
+	rating INTEGER,
+	score DOUBLE PRECISION,
+	performance INTEGER,
+	PRIMARY KEY (tournament_id, username)
+);
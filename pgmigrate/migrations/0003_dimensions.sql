@@ -0,0 +1,15 @@
+-- Players, events and openings of --normalized
+CREATE TABLE IF NOT EXISTS players (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE
+);
+CREATE TABLE IF NOT EXISTS events (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE
+);
+CREATE TABLE IF NOT EXISTS openings (
+	id SERIAL PRIMARY KEY,
+	eco TEXT NOT NULL,
+	name TEXT NOT NULL,
+	UNIQUE (eco, name)
+);
@@ -0,0 +1,9 @@
+-- One row per position of --positions-table, searchable by Zobrist hash
+CREATE TABLE IF NOT EXISTS game_positions (
+	game_id TEXT NOT NULL,
+	ply INTEGER NOT NULL,
+	fen TEXT NOT NULL,
+	zobrist BIGINT,
+	PRIMARY KEY (game_id, ply)
+);
+CREATE INDEX IF NOT EXISTS game_positions_zobrist ON game_positions (zobrist);
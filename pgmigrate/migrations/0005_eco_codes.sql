@@ -0,0 +1,5 @@
+-- ECO codes and names, filled from the eco package after migrating
+CREATE TABLE IF NOT EXISTS eco_codes (
+	code TEXT PRIMARY KEY,
+	name TEXT NOT NULL
+);
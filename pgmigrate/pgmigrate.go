@@ -0,0 +1,140 @@
+// Package pgmigrate applies the versioned schema changes of the Postgres
+// importer, shipped in the binary and recorded in schema_migrations, so every
+// database has the same schema whatever version first created it
+package pgmigrate
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Migrations are named NNNN_description.sql and run in version order. Never
+// edit one that was released, add the next one.
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// lockKey is the advisory lock held while migrating, so importers started
+// together don't run the same migration twice
+const lockKey = 7306185
+
+const createMigrationsSQL = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name TEXT,
+	applied_at TIMESTAMPTZ DEFAULT now()
+)`
+
+// Migration is one embedded file
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// List returns the embedded migrations in order
+func List() ([]Migration, error) {
+	return list(migrations)
+}
+
+// list reads the migrations folder of fsys
+func list(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.ReadDir(fsys, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Migration
+	for _, file := range files {
+		prefix, _, found := strings.Cut(file.Name(), "_")
+		version, err := strconv.Atoi(prefix)
+		if !found || err != nil {
+			return nil, fmt.Errorf("migration %s is not named NNNN_description.sql", file.Name())
+		}
+		data, err := fs.ReadFile(fsys, path.Join("migrations", file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, Migration{version, strings.TrimSuffix(file.Name(), ".sql"), string(data)})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// Apply runs the migrations not recorded yet, each in its own transaction,
+// and returns them. Without lock (databases lacking advisory locks, like
+// CockroachDB) importers must not be started together on a new database.
+func Apply(ctx context.Context, pool *pgxpool.Pool, lock bool) ([]Migration, error) {
+	list, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	if lock {
+		if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+			return nil, err
+		}
+		defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey)
+	}
+
+	if _, err := conn.Exec(ctx, createMigrationsSQL); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]bool)
+	rows, err := conn.Query(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	for _, m := range list {
+		if applied[m.Version] {
+			continue
+		}
+
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return ran, err
+		}
+		if _, err := tx.Exec(ctx, m.SQL); err != nil {
+			tx.Rollback(ctx)
+			return ran, fmt.Errorf("migration %s: %w", m.Name, err)
+		}
+		if _, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name); err != nil {
+			tx.Rollback(ctx)
+			return ran, err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return ran, err
+		}
+		ran = append(ran, m)
+	}
+	return ran, nil
+}
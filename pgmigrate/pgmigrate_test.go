@@ -0,0 +1,63 @@
+package pgmigrate
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestList(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []string
+		versions []int
+		err      bool
+	}{
+		{"in version order", []string{"0002_b.sql", "0010_c.sql", "0001_a.sql"}, []int{1, 2, 10}, false},
+		{"no migrations", nil, nil, false},
+		{"no description", []string{"0001.sql"}, nil, true},
+		{"no version", []string{"initial_schema.sql"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{"migrations": &fstest.MapFile{Mode: fs.ModeDir | 0o755}}
+			for _, name := range tt.files {
+				fsys["migrations/"+name] = &fstest.MapFile{Data: []byte("SELECT 1;")}
+			}
+
+			list, err := list(fsys)
+			if (err != nil) != tt.err {
+				t.Fatalf("list error = %v, want error: %v", err, tt.err)
+			}
+			if len(list) != len(tt.versions) {
+				t.Fatalf("list returned %d migrations, want %d", len(list), len(tt.versions))
+			}
+			for i, m := range list {
+				if m.Version != tt.versions[i] {
+					t.Errorf("migration %d is version %d, want %d", i, m.Version, tt.versions[i])
+				}
+			}
+		})
+	}
+}
+
+// The embedded migrations must be numbered 1, 2, 3... with no gap or repeat,
+// or a database could skip one
+func TestEmbedded(t *testing.T) {
+	list, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) == 0 {
+		t.Fatal("no embedded migrations")
+	}
+	for i, m := range list {
+		if m.Version != i+1 {
+			t.Errorf("%s is version %d, want %d", m.Name, m.Version, i+1)
+		}
+		if m.SQL == "" {
+			t.Errorf("%s is empty", m.Name)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package pgnparse
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	evalRe    = regexp.MustCompile(`\[%eval\s+([^\]\s]+)`)
+	clockRe   = regexp.MustCompile(`\[%clk\s+(\d+):(\d+):(\d+(?:\.\d+)?)\]`)
+	commandRe = regexp.MustCompile(`\[%[^\]]*\]`)
+)
+
+// MateScore is the evaluation in pawns used for forced mates
+const MateScore = 100.0
+
+// Eval returns the [%eval] annotation of a comment in pawns, from white's point of view
+func Eval(comment string) (float64, bool) {
+	match := evalRe.FindStringSubmatch(comment)
+	if match == nil {
+		return 0, false
+	}
+
+	value := match[1]
+	if mate, ok := strings.CutPrefix(value, "#"); ok {
+		if strings.HasPrefix(mate, "-") {
+			return -MateScore, true
+		}
+		return MateScore, true
+	}
+
+	eval, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false
+	}
+	return eval, true
+}
+
+// Clock returns the [%clk h:mm:ss] annotation of a comment in seconds
+func Clock(comment string) (float64, bool) {
+	match := clockRe.FindStringSubmatch(comment)
+	if match == nil {
+		return 0, false
+	}
+
+	hours, _ := strconv.Atoi(match[1])
+	minutes, _ := strconv.Atoi(match[2])
+	seconds, _ := strconv.ParseFloat(match[3], 64)
+	return float64(hours*3600+minutes*60) + seconds, true
+}
+
+// CommentText returns a comment without its [%...] annotations
+func CommentText(comment string) string {
+	return strings.Join(strings.Fields(commandRe.ReplaceAllString(comment, " ")), " ")
+}
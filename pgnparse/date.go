@@ -0,0 +1,101 @@
+package pgnparse
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PartialDate is a PGN date where any component may be unknown ("??")
+type PartialDate struct {
+	Year  *int
+	Month *int
+	Day   *int
+}
+
+// ParseDate reads "2024.04.16", "1997.??.??" or "????.??.??"
+func ParseDate(value string) PartialDate {
+	var date PartialDate
+
+	parts := strings.Split(strings.TrimSpace(value), ".")
+	if len(parts) != 3 {
+		return date
+	}
+
+	date.Year = component(parts[0], 1, 9999)
+	date.Month = component(parts[1], 1, 12)
+	date.Day = component(parts[2], 1, 31)
+	return date
+}
+
+// Complete reports whether year, month and day are all known
+func (d PartialDate) Complete() bool {
+	return d.Year != nil && d.Month != nil && d.Day != nil
+}
+
+// Time returns the full date, false when it is incomplete or invalid
+func (d PartialDate) Time() (time.Time, bool) {
+	if !d.Complete() {
+		return time.Time{}, false
+	}
+
+	t := time.Date(*d.Year, time.Month(*d.Month), *d.Day, 0, 0, 0, 0, time.UTC)
+	if t.Day() != *d.Day {
+		return time.Time{}, false // e.g. 2023.02.30
+	}
+	return t, true
+}
+
+// PlayedAt combines a Date and a UTCTime ("15:04:05") tag into one instant,
+// false when the date is incomplete. A missing time counts as midnight.
+func PlayedAt(date string, utcTime string) (time.Time, bool) {
+	t, ok := ParseDate(date).Time()
+	if !ok {
+		return time.Time{}, false
+	}
+
+	if clock, err := time.Parse("15:04:05", strings.TrimSpace(utcTime)); err == nil {
+		t = t.Add(time.Duration(clock.Hour())*time.Hour +
+			time.Duration(clock.Minute())*time.Minute +
+			time.Duration(clock.Second())*time.Second)
+	}
+	return t, true
+}
+
+// Precisions of ApproxPlayedAt, from most to least precise
+const (
+	PrecisionTime  = "time"
+	PrecisionDay   = "day"
+	PrecisionMonth = "month"
+	PrecisionYear  = "year"
+)
+
+// ApproxPlayedAt is PlayedAt for partial dates too: "1997.05.??" is the
+// 1st of May and "1997.??.??" January 1st, with the precision saying so.
+// False when even the year is unknown.
+func ApproxPlayedAt(date string, utcTime string) (time.Time, string, bool) {
+	if t, ok := PlayedAt(date, utcTime); ok {
+		if _, err := time.Parse("15:04:05", strings.TrimSpace(utcTime)); err == nil {
+			return t, PrecisionTime, true
+		}
+		return t, PrecisionDay, true
+	}
+
+	parts := ParseDate(date)
+	switch {
+	case parts.Year == nil:
+		return time.Time{}, "", false
+	case parts.Month == nil:
+		return time.Date(*parts.Year, time.January, 1, 0, 0, 0, 0, time.UTC), PrecisionYear, true
+	default:
+		return time.Date(*parts.Year, time.Month(*parts.Month), 1, 0, 0, 0, 0, time.UTC), PrecisionMonth, true
+	}
+}
+
+func component(s string, min, max int) *int {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < min || n > max {
+		return nil
+	}
+	return &n
+}
@@ -0,0 +1,53 @@
+package pgnparse
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		value            string
+		year, month, day int // 0 for unknown
+		time             time.Time
+	}{
+		{value: "2024.04.16", year: 2024, month: 4, day: 16, time: time.Date(2024, 4, 16, 0, 0, 0, 0, time.UTC)},
+		{value: " 2024.04.16 ", year: 2024, month: 4, day: 16, time: time.Date(2024, 4, 16, 0, 0, 0, 0, time.UTC)},
+		{value: "1997.??.??", year: 1997},
+		{value: "1997.05.??", year: 1997, month: 5},
+		{value: "????.??.??"},
+		{value: "2023.02.30", year: 2023, month: 2, day: 30},
+		{value: "2024.13.01", year: 2024, day: 1},
+		{value: "2024.00.00", year: 2024},
+		{value: "2024-04-16"},
+		{value: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			date := ParseDate(tt.value)
+			if got := orZero(date.Year); got != tt.year {
+				t.Errorf("Year = %d, want %d", got, tt.year)
+			}
+			if got := orZero(date.Month); got != tt.month {
+				t.Errorf("Month = %d, want %d", got, tt.month)
+			}
+			if got := orZero(date.Day); got != tt.day {
+				t.Errorf("Day = %d, want %d", got, tt.day)
+			}
+
+			got, ok := date.Time()
+			if ok != !tt.time.IsZero() || !got.Equal(tt.time) {
+				t.Errorf("Time() = %v, %v, want %v", got, ok, tt.time)
+			}
+		})
+	}
+}
+
+// orZero is 0 for an unknown component
+func orZero(n *int) int {
+	if n == nil {
+		return 0
+	}
+	return *n
+}
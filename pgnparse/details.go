@@ -0,0 +1,31 @@
+package pgnparse
+
+// MoveDetail is one move as stored, with the annotations of its comment
+type MoveDetail struct {
+	Ply     int      `json:"ply" bson:"ply"` // 1-based
+	SAN     string   `json:"san" bson:"san"`
+	UCI     string   `json:"uci,omitempty" bson:"uci,omitempty"`
+	Clock   *float64 `json:"clock,omitempty" bson:"clock,omitempty"` // seconds left after the move
+	Eval    *float64 `json:"eval,omitempty" bson:"eval,omitempty"`   // pawns, white's point of view
+	Comment string   `json:"comment,omitempty" bson:"comment,omitempty"`
+}
+
+// Details builds the stored moves. ucis may be shorter than moves (replay
+// stopped at an illegal move) or nil.
+func Details(moves []Move, ucis []string) []MoveDetail {
+	details := make([]MoveDetail, len(moves))
+	for i, move := range moves {
+		detail := MoveDetail{Ply: i + 1, SAN: move.SAN, Comment: CommentText(move.Comment)}
+		if i < len(ucis) {
+			detail.UCI = ucis[i]
+		}
+		if clock, ok := Clock(move.Comment); ok {
+			detail.Clock = &clock
+		}
+		if eval, ok := Eval(move.Comment); ok {
+			detail.Eval = &eval
+		}
+		details[i] = detail
+	}
+	return details
+}
@@ -0,0 +1,78 @@
+package pgnparse
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EloLimits are the sanity bounds for ratings, a zero bound is not checked
+type EloLimits struct {
+	Min    int
+	Max    int
+	MaxGap int
+}
+
+// RatingMissing reports whether an Elo tag value means "no rating"
+func RatingMissing(value string) bool {
+	switch strings.TrimSpace(value) {
+	case "", "?", "-":
+		return true
+	}
+	return false
+}
+
+// ParseElo reads an Elo tag value, nil when the player is unrated ("?", "-",
+// "0" or no number at all)
+func ParseElo(value string) *int {
+	rating, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || rating <= 0 {
+		return nil
+	}
+	return &rating
+}
+
+// Elo returns the rating, 0 for unrated players
+func Elo(rating *int) int {
+	if rating == nil {
+		return 0
+	}
+	return *rating
+}
+
+// Rated reports whether both players have a rating of at least min
+func Rated(white, black *int, min int) bool {
+	return white != nil && black != nil && *white >= min && *black >= min
+}
+
+// CheckElo validates WhiteElo and BlackElo tag values, missing ratings are not checked
+func (l EloLimits) CheckElo(white, black string) error {
+	sides := []struct{ name, value string }{{"WhiteElo", white}, {"BlackElo", black}}
+
+	ratings := make(map[string]int)
+	for _, side := range sides {
+		if RatingMissing(side.value) {
+			continue
+		}
+		rating, err := strconv.Atoi(strings.TrimSpace(side.value))
+		if err != nil {
+			return fmt.Errorf("%s %q is not a number", side.name, side.value)
+		}
+		if (l.Min > 0 && rating < l.Min) || (l.Max > 0 && rating > l.Max) {
+			return fmt.Errorf("%s %d is outside %d-%d", side.name, rating, l.Min, l.Max)
+		}
+		ratings[side.name] = rating
+	}
+
+	if len(ratings) == 2 && l.MaxGap > 0 {
+		gap := ratings["WhiteElo"] - ratings["BlackElo"]
+		if gap < 0 {
+			gap = -gap
+		}
+		if gap > l.MaxGap {
+			return fmt.Errorf("rating gap %d is above %d", gap, l.MaxGap)
+		}
+	}
+
+	return nil
+}
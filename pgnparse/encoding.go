@@ -0,0 +1,45 @@
+package pgnparse
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// CheckEncoding validates an --encoding value
+func CheckEncoding(name string) error {
+	_, err := decoder(name)
+	return err
+}
+
+// ToUTF8 converts a game from the given encoding. With "auto" valid UTF-8
+// is kept and anything else is read as Windows-1252 (a superset of Latin-1),
+// which is what old ChessBase and TWIC files use.
+func ToUTF8(data string, name string) (string, error) {
+	dec, err := decoder(name)
+	if err != nil {
+		return data, err
+	}
+	if dec == nil || (strings.EqualFold(name, "auto") && utf8.ValidString(data)) {
+		return data, nil
+	}
+	return dec.NewDecoder().String(data)
+}
+
+// decoder returns nil for UTF-8 input
+func decoder(name string) (encoding.Encoding, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "utf-8", "utf8":
+		return nil, nil
+	case "auto", "windows-1252", "cp1252":
+		return charmap.Windows1252, nil
+	case "latin1", "latin-1", "iso-8859-1":
+		return charmap.ISO8859_1, nil
+	case "latin9", "iso-8859-15":
+		return charmap.ISO8859_15, nil
+	}
+	return nil, fmt.Errorf("unknown encoding %q", name)
+}
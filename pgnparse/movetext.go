@@ -0,0 +1,132 @@
+package pgnparse
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Move is one half-move of the main line
+type Move struct {
+	SAN     string
+	Comment string // text of the {comments} following the move
+}
+
+var moveNumberRe = regexp.MustCompile(`^\d+\.+`)
+
+// Moves returns the main line of a game. Move numbers, NAGs, variations and
+// the game terminator are dropped and comments are attached to the move before them.
+func Moves(data string) []Move {
+	text := movetext(data)
+
+	var moves []Move
+	addComment := func(comment string) {
+		comment = strings.TrimSpace(comment)
+		if comment == "" || len(moves) == 0 {
+			return
+		}
+		last := &moves[len(moves)-1]
+		if last.Comment != "" {
+			last.Comment += " "
+		}
+		last.Comment += comment
+	}
+
+	for i := 0; i < len(text); {
+		switch c := text[i]; {
+		case c == '{':
+			end := strings.IndexByte(text[i:], '}')
+			if end < 0 {
+				end = len(text) - i
+			}
+			addComment(strings.TrimPrefix(text[i:i+end], "{"))
+			i += end + 1
+		case c == ';':
+			end := strings.IndexByte(text[i:], '\n')
+			if end < 0 {
+				end = len(text) - i
+			}
+			addComment(text[i+1 : i+end])
+			i += end
+		case c == '(':
+			i = skipVariation(text, i)
+		case c == ')' || c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		default:
+			end := i
+			for end < len(text) && !strings.ContainsRune(" \t\r\n{}();", rune(text[end])) {
+				end++
+			}
+			if san := moveToken(text[i:end]); san != "" {
+				moves = append(moves, Move{SAN: san})
+			}
+			i = end
+		}
+	}
+
+	return moves
+}
+
+// SANs returns only the SAN of each move
+func SANs(moves []Move) []string {
+	sans := make([]string, len(moves))
+	for i, move := range moves {
+		sans[i] = move.SAN
+	}
+	return sans
+}
+
+// movetext drops the tag section and escaped lines
+func movetext(data string) string {
+	var text strings.Builder
+	for _, line := range strings.Split(data, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") || strings.HasPrefix(line, "%") {
+			continue
+		}
+		text.WriteString(line + "\n")
+	}
+	return text.String()
+}
+
+// skipVariation returns the index after the variation starting at i
+func skipVariation(text string, i int) int {
+	depth := 0
+	for ; i < len(text); i++ {
+		switch text[i] {
+		case '{':
+			end := strings.IndexByte(text[i:], '}')
+			if end < 0 {
+				return len(text)
+			}
+			i += end
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(text)
+}
+
+// moveToken strips move numbers and annotation glyphs and normalizes the
+// move, "" for non-moves
+func moveToken(token string) string {
+	if strings.HasPrefix(token, "$") || glyphRe.MatchString(token) {
+		return ""
+	}
+
+	switch token {
+	case "1-0", "0-1", "1/2-1/2", "*", "e.p.":
+		return ""
+	}
+
+	token = moveNumberRe.ReplaceAllString(token, "")
+	if token == "" {
+		return ""
+	}
+
+	return NormalizeSAN(strings.TrimRight(token, "!?"))
+}
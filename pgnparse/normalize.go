@@ -0,0 +1,69 @@
+package pgnparse
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeValue cleans a tag value for storage: Unicode NFC, control
+// characters removed, whitespace trimmed and runs of spaces collapsed
+func NormalizeValue(value string) string {
+	value = norm.NFC.String(value)
+
+	value = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, value)
+
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// NormalizeTags normalizes every tag value in place and returns the
+// original values of the tags that changed
+func NormalizeTags(tags []Tag) map[string]string {
+	var originals map[string]string
+	for i, tag := range tags {
+		normalized := NormalizeValue(tag.Value)
+		if normalized == tag.Value {
+			continue
+		}
+		if originals == nil {
+			originals = make(map[string]string)
+		}
+		originals[tag.Name] = tag.Value
+		tags[i].Value = normalized
+	}
+	return originals
+}
+
+// NormalizeName unifies the ways sources write a player's name: spaces
+// collapsed, one space after commas ("Carlsen,M" is "Carlsen, M") and no dot
+// after initials ("Carlsen, M." is "Carlsen, M"). Names of non-usernames also
+// get underscores as spaces ("carlsen_magnus" is "carlsen magnus"). Case is
+// kept, compare names case-insensitively.
+func NormalizeName(name string, username bool) string {
+	if !username {
+		name = strings.ReplaceAll(name, "_", " ")
+	}
+
+	var parts []string
+	for _, part := range strings.Split(name, ",") {
+		words := strings.Fields(part)
+		for i, word := range words {
+			if r := []rune(word); len(r) == 2 && r[1] == '.' && unicode.IsLetter(r[0]) {
+				words[i] = string(r[0])
+			}
+		}
+		if len(words) > 0 {
+			parts = append(parts, strings.Join(words, " "))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,95 @@
+package pgnparse
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// TagProcessor rewrites the tags of every game after they're parsed and
+// normalized, before the importers read them. It may change, add or drop tags.
+type TagProcessor interface {
+	Process(tags []Tag) []Tag
+}
+
+// TagProcessorFunc lets a plain function be a TagProcessor
+type TagProcessorFunc func(tags []Tag) []Tag
+
+func (f TagProcessorFunc) Process(tags []Tag) []Tag {
+	return f(tags)
+}
+
+var tagProcessors = map[string]TagProcessor{
+	"drop-placeholders": TagProcessorFunc(DropPlaceholders),
+	"titles-from-names": TagProcessorFunc(TitlesFromNames),
+}
+
+// RegisterTagProcessor makes a processor available to --tag-processors.
+// Call it from an init function, e.g. in a file run along with the importer.
+func RegisterTagProcessor(name string, processor TagProcessor) {
+	tagProcessors[name] = processor
+}
+
+// TagProcessors looks up registered processors by name, in order
+func TagProcessors(names []string) ([]TagProcessor, error) {
+	processors := make([]TagProcessor, 0, len(names))
+	for _, name := range names {
+		processor, ok := tagProcessors[name]
+		if !ok {
+			known := make([]string, 0, len(tagProcessors))
+			for name := range tagProcessors {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown tag processor %q (known: %s)", name, strings.Join(known, ", "))
+		}
+		processors = append(processors, processor)
+	}
+	return processors, nil
+}
+
+// ProcessTags runs the processors one after the other
+func ProcessTags(tags []Tag, processors []TagProcessor) []Tag {
+	for _, processor := range processors {
+		tags = processor.Process(tags)
+	}
+	return tags
+}
+
+// DropPlaceholders drops tags whose value only says it's unknown ("?", "-",
+// "????.??.??"), so they are stored empty instead of as placeholders
+func DropPlaceholders(tags []Tag) []Tag {
+	kept := tags[:0]
+	for _, tag := range tags {
+		if strings.Trim(tag.Value, "?.-") == "" {
+			continue
+		}
+		kept = append(kept, tag)
+	}
+	return kept
+}
+
+var titles = []string{"GM", "IM", "FM", "CM", "NM", "WGM", "WIM", "WFM", "WCM", "LM", "BOT"}
+
+// TitlesFromNames moves a title written before a player's name
+// ("GM Magnus Carlsen") to WhiteTitle or BlackTitle, unless those are set
+func TitlesFromNames(tags []Tag) []Tag {
+	has := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		has[tag.Name] = true
+	}
+
+	for i, tag := range tags {
+		if tag.Name != "White" && tag.Name != "Black" || has[tag.Name+"Title"] {
+			continue
+		}
+		title, name, found := strings.Cut(tag.Value, " ")
+		if !found || name == "" || !slices.Contains(titles, title) {
+			continue
+		}
+		tags[i].Value = name
+		tags = append(tags, Tag{Name: tag.Name + "Title", Value: title})
+	}
+	return tags
+}
@@ -0,0 +1,76 @@
+package pgnparse
+
+import (
+	"regexp"
+	"strings"
+)
+
+var commentRe = regexp.MustCompile(`\{[^}]*\}`)
+
+// MovetextResult returns the game terminator at the end of the movetext
+// (1-0, 0-1, 1/2-1/2 or *), or "" when the movetext has none
+func MovetextResult(data string) string {
+	var movetext strings.Builder
+	for _, line := range strings.Split(data, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "[") {
+			continue
+		}
+		movetext.WriteString(trimmed + " ")
+	}
+
+	fields := strings.Fields(commentRe.ReplaceAllString(movetext.String(), " "))
+	if len(fields) == 0 {
+		return ""
+	}
+
+	switch last := fields[len(fields)-1]; last {
+	case "1-0", "0-1", "1/2-1/2", "*":
+		return last
+	}
+	return ""
+}
+
+// Result is the canonical outcome of a game
+type Result string
+
+const (
+	WhiteWins Result = "1-0"
+	BlackWins Result = "0-1"
+	Draw      Result = "1/2-1/2"
+	Unknown   Result = "*"
+)
+
+// resultSpellings maps the cleaned up spellings found in the wild to a Result
+var resultSpellings = map[string]Result{
+	"1-0":     WhiteWins,
+	"+/-":     WhiteWins, // forfeit
+	"+--":     WhiteWins,
+	"0-1":     BlackWins,
+	"-/+":     BlackWins,
+	"--+":     BlackWins,
+	"1/2-1/2": Draw,
+	"1/2":     Draw,
+	"=":       Draw,
+	"=-=":     Draw,
+	"draw":    Draw,
+	"*":       Unknown,
+	"-/-":     Unknown, // double forfeit, nobody scores
+	"0-0":     Unknown,
+}
+
+// NormalizeResult maps a Result tag ("1–0" with an en dash, "1:0", "½-½",
+// "+/-" forfeits, ...) to its canonical value. Unrecognized values give
+// Unknown and false.
+func NormalizeResult(raw string) (Result, bool) {
+	value := strings.ToLower(strings.Join(strings.Fields(raw), ""))
+	value = strings.NewReplacer(
+		"–", "-", "—", "-", "−", "-", "‐", "-", ":", "-",
+		"½", "1/2", "0.5", "1/2", "0,5", "1/2",
+	).Replace(value)
+
+	if result, ok := resultSpellings[value]; ok {
+		return result, true
+	}
+	return Unknown, false
+}
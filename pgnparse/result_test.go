@@ -0,0 +1,41 @@
+package pgnparse
+
+import "testing"
+
+func TestNormalizeResult(t *testing.T) {
+	tests := []struct {
+		raw    string
+		result Result
+		ok     bool
+	}{
+		{"1-0", WhiteWins, true},
+		{"0-1", BlackWins, true},
+		{"1/2-1/2", Draw, true},
+		{"*", Unknown, true},
+		{"1–0", WhiteWins, true},
+		{"0—1", BlackWins, true},
+		{"1:0", WhiteWins, true},
+		{"½-½", Draw, true},
+		{"0.5-0.5", Draw, true},
+		{"0,5:0,5", Draw, true},
+		{" 1 - 0 ", WhiteWins, true},
+		{"1/2", Draw, true},
+		{"Draw", Draw, true},
+		{"+/-", WhiteWins, true},
+		{"-/+", BlackWins, true},
+		{"-/-", Unknown, true},
+		{"0-0", Unknown, true},
+		{"", Unknown, false},
+		{"?", Unknown, false},
+		{"2-0", Unknown, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			result, ok := NormalizeResult(tt.raw)
+			if result != tt.result || ok != tt.ok {
+				t.Errorf("NormalizeResult(%q) = %q, %v, want %q, %v", tt.raw, result, ok, tt.result, tt.ok)
+			}
+		})
+	}
+}
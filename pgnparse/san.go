@@ -0,0 +1,25 @@
+package pgnparse
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	castlingRe  = regexp.MustCompile(`^0-0(-0)?`)
+	promotionRe = regexp.MustCompile(`^([a-h]x?[a-h]?[18])([QRBN])`)
+)
+
+// NormalizeSAN fixes the spellings of SAN that can be fixed without the
+// position: 0-0 castling, ':' captures, e.p. suffixes, "++" checks and
+// promotions without '='. Missing check marks and long algebraic moves need
+// the position, see replay.
+func NormalizeSAN(san string) string {
+	san = strings.TrimSuffix(san, "e.p.")
+	san = strings.Replace(san, ":", "x", 1)
+	san = strings.Replace(san, "++", "+", 1)
+	if castlingRe.MatchString(san) {
+		san = strings.ReplaceAll(san, "0", "O")
+	}
+	return promotionRe.ReplaceAllString(san, "$1=$2")
+}
@@ -0,0 +1,144 @@
+package pgnparse
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Scanner splits a PGN stream into games, the same way bufio.Scanner splits lines.
+// A new game starts at a tag pair that follows movetext, or at movetext that
+// follows a finished game after a blank line or with a new "1." move number.
+// Blank lines between games are optional, CRLF line ends and UTF-8 byte order
+// marks (also in the middle of concatenated files) are dropped.
+type Scanner struct {
+	lines  *bufio.Scanner
+	text   string
+	offset int64 // of text
+
+	pos       int64 // bytes consumed by lines
+	lineStart int64 // offset of the last line read
+	gameStart int64 // offset of the game being collected
+
+	game     strings.Builder
+	content  bool // non-blank lines in the current game
+	moves    bool // movetext seen in the current game
+	finished bool // movetext ended with a game terminator
+	blank    bool // previous line was blank
+}
+
+const bom = "\ufeff"
+
+var (
+	tagLineRe   = regexp.MustCompile(`^\[\w+\s+"`)
+	firstMoveRe = regexp.MustCompile(`^1\.\s*[a-zA-Z]`)
+)
+
+func NewScanner(r io.Reader) *Scanner {
+	s := &Scanner{lines: bufio.NewScanner(r)}
+	s.lines.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	// Count the bytes behind every line, so games have an offset in the stream
+	s.lines.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if token != nil {
+			s.lineStart = s.pos
+			s.pos += int64(advance)
+		}
+		return advance, token, err
+	})
+	return s
+}
+
+// Scan advances to the next game, which is then available through Text
+func (s *Scanner) Scan() bool {
+	for s.lines.Scan() {
+		// bufio.ScanLines already drops the \r of CRLF
+		line := strings.TrimPrefix(s.lines.Text(), bom)
+
+		if s.content && s.startsGame(line) {
+			s.text, s.offset = s.game.String(), s.gameStart
+			s.reset()
+			s.add(line)
+			return true
+		}
+
+		s.add(line)
+	}
+
+	if s.content {
+		s.text, s.offset = s.game.String(), s.gameStart
+		s.reset()
+		return true
+	}
+
+	return false
+}
+
+// Text returns the raw PGN of the current game
+func (s *Scanner) Text() string {
+	return s.text
+}
+
+// Offset returns the byte offset of the current game in the stream
+func (s *Scanner) Offset() int64 {
+	return s.offset
+}
+
+// Err returns the first non-EOF read error
+func (s *Scanner) Err() error {
+	return s.lines.Err()
+}
+
+func (s *Scanner) startsGame(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" {
+		return false
+	}
+
+	// Tag pairs never follow movetext within a game. "[%clk ...]" lines
+	// of a wrapped comment are not tag pairs.
+	if strings.HasPrefix(trimmed, "[") {
+		return s.moves && tagLineRe.MatchString(trimmed)
+	}
+
+	// Movetext of a game without tag section
+	return s.finished && (s.blank || firstMoveRe.MatchString(trimmed))
+}
+
+func (s *Scanner) add(line string) {
+	s.game.WriteString(line + "\n")
+
+	trimmed := strings.TrimSpace(line)
+	s.blank = trimmed == ""
+	if s.blank {
+		return
+	}
+
+	if !s.content {
+		s.gameStart = s.lineStart
+	}
+	s.content = true
+	if !strings.HasPrefix(trimmed, "[") {
+		s.moves = true
+		s.finished = endsWithTerminator(trimmed)
+	}
+}
+
+func (s *Scanner) reset() {
+	s.game.Reset()
+	s.content = false
+	s.moves = false
+	s.finished = false
+	s.blank = false
+}
+
+func endsWithTerminator(line string) bool {
+	fields := strings.Fields(line)
+	switch fields[len(fields)-1] {
+	case "1-0", "0-1", "1/2-1/2", "*":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package pgnparse
+
+import (
+	"strings"
+	"testing"
+)
+
+func scanAll(t *testing.T, pgn string) ([]string, []int64) {
+	t.Helper()
+	var games []string
+	var offsets []int64
+	s := NewScanner(strings.NewReader(pgn))
+	for s.Scan() {
+		games = append(games, s.Text())
+		offsets = append(offsets, s.Offset())
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return games, offsets
+}
+
+func TestScanner(t *testing.T) {
+	tests := []struct {
+		name    string
+		pgn     string
+		games   []string
+		offsets []int64
+	}{
+		{
+			name: "blank line between games",
+			pgn:  "[Event \"A\"]\n\n1. e4 e5 1-0\n\n[Event \"B\"]\n\n1. d4 d5 0-1\n",
+			games: []string{
+				"[Event \"A\"]\n\n1. e4 e5 1-0\n\n",
+				"[Event \"B\"]\n\n1. d4 d5 0-1\n",
+			},
+			offsets: []int64{0, 27},
+		},
+		{
+			name: "byte order mark at the start",
+			pgn:  "\ufeff[Event \"A\"]\n\n1. e4 e5 1-0\n",
+			games: []string{
+				"[Event \"A\"]\n\n1. e4 e5 1-0\n",
+			},
+			offsets: []int64{0},
+		},
+		{
+			name: "byte order mark of a concatenated file",
+			pgn:  "\ufeff[Event \"A\"]\n\n1. e4 e5 1-0\n\ufeff[Event \"B\"]\n\n1. d4 d5 0-1\n",
+			games: []string{
+				"[Event \"A\"]\n\n1. e4 e5 1-0\n",
+				"[Event \"B\"]\n\n1. d4 d5 0-1\n",
+			},
+			offsets: []int64{0, 29},
+		},
+		{
+			name: "CRLF line ends",
+			pgn:  "[Event \"A\"]\r\n[Site \"?\"]\r\n\r\n1. e4 e5\r\n2. Nf3 1-0\r\n\r\n[Event \"B\"]\r\n\r\n1. d4 d5 0-1\r\n",
+			games: []string{
+				"[Event \"A\"]\n[Site \"?\"]\n\n1. e4 e5\n2. Nf3 1-0\n\n",
+				"[Event \"B\"]\n\n1. d4 d5 0-1\n",
+			},
+			offsets: []int64{0, 51},
+		},
+		{
+			name: "no blank line between games",
+			pgn:  "[Event \"A\"]\n1. e4 e5 1-0\n[Event \"B\"]\n1. d4 d5 0-1\n",
+			games: []string{
+				"[Event \"A\"]\n1. e4 e5 1-0\n",
+				"[Event \"B\"]\n1. d4 d5 0-1\n",
+			},
+			offsets: []int64{0, 25},
+		},
+		{
+			name: "games without tags",
+			pgn:  "1. e4 e5 1-0\n1. d4 d5 0-1\n\n1. c4 *\n",
+			games: []string{
+				"1. e4 e5 1-0\n",
+				"1. d4 d5 0-1\n\n",
+				"1. c4 *\n",
+			},
+			offsets: []int64{0, 13, 27},
+		},
+		{
+			name: "wrapped clock comments",
+			pgn: "[Event \"A\"]\n\n1. e4 { \n[%clk 0:03:00] } 1... e5 {\n[%clk 0:02:59]\n} 2. Nf3 { [%clk\n0:02:58] } 1-0\n\n" +
+				"[Event \"B\"]\n\n1. d4 0-1\n",
+			games: []string{
+				"[Event \"A\"]\n\n1. e4 { \n[%clk 0:03:00] } 1... e5 {\n[%clk 0:02:59]\n} 2. Nf3 { [%clk\n0:02:58] } 1-0\n\n",
+				"[Event \"B\"]\n\n1. d4 0-1\n",
+			},
+			offsets: []int64{0, 97},
+		},
+		{
+			name: "unfinished movetext continues on the next line",
+			pgn:  "[Event \"A\"]\n\n1. e4 e5\n\n2. Nf3 1-0\n",
+			games: []string{
+				"[Event \"A\"]\n\n1. e4 e5\n\n2. Nf3 1-0\n",
+			},
+			offsets: []int64{0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			games, offsets := scanAll(t, tt.pgn)
+			if len(games) != len(tt.games) {
+				t.Fatalf("got %d games %q, want %d", len(games), games, len(tt.games))
+			}
+			for i := range games {
+				if games[i] != tt.games[i] {
+					t.Errorf("game %d = %q, want %q", i, games[i], tt.games[i])
+				}
+				if offsets[i] != tt.offsets[i] {
+					t.Errorf("game %d offset = %d, want %d", i, offsets[i], tt.offsets[i])
+				}
+			}
+		})
+	}
+}
+
+func TestScannerEmpty(t *testing.T) {
+	games, _ := scanAll(t, "\ufeff\n\n  \r\n")
+	if len(games) != 0 {
+		t.Errorf("got games %q from blank input", games)
+	}
+}
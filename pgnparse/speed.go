@@ -0,0 +1,39 @@
+package pgnparse
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Speed classifies a TimeControl tag the way Lichess does, from the
+// estimated game duration (base + 40 * increment seconds)
+func Speed(timeControl string) string {
+	if timeControl == "-" {
+		return "correspondence"
+	}
+
+	base, increment, found := strings.Cut(timeControl, "+")
+	seconds, err := strconv.Atoi(base)
+	if err != nil {
+		return ""
+	}
+	if found {
+		inc, err := strconv.Atoi(increment)
+		if err != nil {
+			return ""
+		}
+		seconds += 40 * inc
+	}
+
+	switch {
+	case seconds < 30:
+		return "ultraBullet"
+	case seconds < 180:
+		return "bullet"
+	case seconds < 480:
+		return "blitz"
+	case seconds < 1500:
+		return "rapid"
+	}
+	return "classical"
+}
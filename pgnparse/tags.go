@@ -0,0 +1,83 @@
+// Package pgnparse holds the PGN parsing shared by the importers
+package pgnparse
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Version changes whenever parsing changes, so stored output can be traced to the parser that made it
+const Version = "3"
+
+// Tag is a single PGN tag pair
+type Tag struct {
+	Name  string
+	Value string
+}
+
+// DuplicatePolicy decides which value is kept when a tag repeats within one game
+type DuplicatePolicy int
+
+const (
+	LastWins DuplicatePolicy = iota
+	FirstWins
+	RejectDuplicates
+)
+
+// ParseDuplicatePolicy accepts "last", "first" or "reject"
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "last", "last-wins":
+		return LastWins, nil
+	case "first", "first-wins":
+		return FirstWins, nil
+	case "reject", "reject-and-report":
+		return RejectDuplicates, nil
+	}
+	return LastWins, fmt.Errorf("unknown duplicate tag policy %q", s)
+}
+
+// DuplicateTagError is returned for games rejected by RejectDuplicates
+type DuplicateTagError struct {
+	Names []string
+}
+
+func (e *DuplicateTagError) Error() string {
+	return "duplicate tags: " + strings.Join(e.Names, ", ")
+}
+
+var tagRe = regexp.MustCompile(`\[(\w+) "([^"]*)"\]`)
+
+// ParseTags returns the tags of a game in order of appearance with repeats
+// resolved by policy, plus the names of the tags that were repeated
+func ParseTags(data string, policy DuplicatePolicy) ([]Tag, []string, error) {
+	var tags []Tag
+	var duplicates []string
+	index := make(map[string]int)
+
+	for _, match := range tagRe.FindAllStringSubmatch(data, -1) {
+		tag := Tag{Name: match[1], Value: match[2]}
+
+		i, seen := index[tag.Name]
+		if !seen {
+			index[tag.Name] = len(tags)
+			tags = append(tags, tag)
+			continue
+		}
+
+		if !slices.Contains(duplicates, tag.Name) {
+			duplicates = append(duplicates, tag.Name)
+		}
+		if policy == LastWins {
+			tags[i] = tag
+		}
+	}
+
+	if policy == RejectDuplicates && len(duplicates) > 0 {
+		return nil, duplicates, &DuplicateTagError{Names: duplicates}
+	}
+
+	return tags, duplicates, nil
+}
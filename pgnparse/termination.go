@@ -0,0 +1,45 @@
+package pgnparse
+
+import "strings"
+
+// TerminationDetail normalizes how a game ended. The Termination tag is used
+// when it is specific (Lichess "Time forfeit", Chess.com "won by resignation"),
+// otherwise ending, the rules ending of the replayed final position (see
+// replay.Ending), and the result decide: decisive games that didn't end on the
+// board were resigned, draws were agreed.
+func TerminationDetail(termination string, result Result, ending string) string {
+	value := strings.ToLower(termination)
+	switch {
+	case strings.Contains(value, "insufficient material"):
+		return "insufficient_material"
+	case strings.Contains(value, "time forfeit"), strings.Contains(value, "on time"):
+		return "time_forfeit"
+	case strings.Contains(value, "abandon"):
+		return "abandoned"
+	case strings.Contains(value, "rules infraction"):
+		return "rules_infraction"
+	case strings.Contains(value, "unterminated"):
+		return "unterminated"
+	case strings.Contains(value, "checkmate"):
+		return "checkmate"
+	case strings.Contains(value, "stalemate"):
+		return "stalemate"
+	case strings.Contains(value, "resignation"):
+		return "resignation"
+	case strings.Contains(value, "agreement"):
+		return "draw_agreement"
+	case strings.Contains(value, "repetition"):
+		return "repetition"
+	}
+
+	// Missing or generic ("Normal") termination
+	switch {
+	case ending != "":
+		return ending
+	case result == WhiteWins || result == BlackWins:
+		return "resignation"
+	case result == Draw:
+		return "draw_agreement"
+	}
+	return "unknown"
+}
@@ -0,0 +1,133 @@
+package pgnparse
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	tagPairsRe = regexp.MustCompile(`^(\[\w+\s+"[^"]*"\]\s*)+$`)
+	nagRe      = regexp.MustCompile(`^\$\d+$`)
+	glyphRe    = regexp.MustCompile(`^[+=\-/±∓∞]+$`)
+	// SAN, plus what other tools write: 0-0, long algebraic (e2e4, Ng1-f3),
+	// drops (N@f3), null moves and e.p. suffixes
+	sanRe = regexp.MustCompile(`^(?:[KQRBN]?[a-h]?[1-8]?[x:-]?[a-h][1-8](?:=?[QRBN])?|[O0]-[O0](?:-[O0])?|[KQRBNP]?@[a-h][1-8]|--|Z0)(?:\+{1,2}|#)?(?:e\.p\.)?[!?]*$`)
+)
+
+// SyntaxError is the first malformed part of a game
+type SyntaxError struct {
+	Line int // within the game, from 1
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// Validate checks that a game is well formed: tag pairs, closed comments and
+// variations, and movetext made of move numbers, moves, NAGs and a result.
+// It doesn't check that the moves are legal.
+func Validate(data string) error {
+	var tags, moves, depth, commentLine int
+	inComment := false
+
+	lines := strings.Split(data, "\n")
+	for n, line := range lines {
+		lineNo := n + 1
+		trimmed := strings.TrimSpace(line)
+		if !inComment {
+			if trimmed == "" || strings.HasPrefix(line, "%") {
+				continue
+			}
+			if strings.HasPrefix(trimmed, "[") {
+				if !tagPairsRe.MatchString(trimmed) {
+					return &SyntaxError{lineNo, fmt.Sprintf("malformed tag pair %s", shorten(trimmed))}
+				}
+				tags++
+				continue
+			}
+		}
+
+		for i := 0; i < len(line); {
+			if inComment {
+				end := strings.IndexByte(line[i:], '}')
+				if end < 0 {
+					break
+				}
+				inComment = false
+				i += end + 1
+				continue
+			}
+
+			switch c := line[i]; c {
+			case '{':
+				inComment, commentLine = true, lineNo
+				i++
+			case '}':
+				return &SyntaxError{lineNo, "unexpected }"}
+			case ';':
+				i = len(line)
+			case '(':
+				depth++
+				i++
+			case ')':
+				if depth--; depth < 0 {
+					return &SyntaxError{lineNo, "unexpected )"}
+				}
+				i++
+			case ' ', '\t', '\r':
+				i++
+			default:
+				end := i + strings.IndexAny(line[i:], " \t\r{};()")
+				if end < i {
+					end = len(line)
+				}
+				move, ok := checkToken(line[i:end])
+				if !ok {
+					return &SyntaxError{lineNo, fmt.Sprintf("unexpected %s", shorten(line[i:end]))}
+				}
+				if move {
+					moves++
+				}
+				i = end
+			}
+		}
+	}
+
+	if inComment {
+		return &SyntaxError{commentLine, "unclosed comment"}
+	}
+	if depth > 0 {
+		return &SyntaxError{len(lines), "unclosed variation"}
+	}
+	if tags == 0 && moves == 0 {
+		return &SyntaxError{1, "no tags and no moves"}
+	}
+	return nil
+}
+
+// checkToken tells whether a movetext token is valid and whether it's a move
+func checkToken(token string) (move, ok bool) {
+	switch token {
+	case "1-0", "0-1", "1/2-1/2", "*", "e.p.":
+		return false, true
+	}
+	if nagRe.MatchString(token) || glyphRe.MatchString(token) {
+		return false, true
+	}
+
+	// "12.", "12..." or a number stuck to its move, "12.e4"
+	token = moveNumberRe.ReplaceAllString(token, "")
+	if token == "" {
+		return false, true
+	}
+	return true, sanRe.MatchString(token)
+}
+
+func shorten(s string) string {
+	if len(s) > 40 {
+		s = s[:40] + "..."
+	}
+	return fmt.Sprintf("%q", s)
+}
@@ -0,0 +1,26 @@
+package pgnparse
+
+import "strings"
+
+// IsStandardVariant reports whether a game with this Variant tag follows
+// standard chess rules from the initial position, so positions can be replayed
+func IsStandardVariant(variant string) bool {
+	switch strings.ToLower(strings.TrimSpace(variant)) {
+	case "", "standard":
+		return true
+	}
+	return false
+}
+
+// VariantIn reports whether variant matches one of the names (case-insensitive)
+func VariantIn(variant string, names []string) bool {
+	if variant == "" {
+		variant = "Standard"
+	}
+	for _, name := range names {
+		if strings.EqualFold(strings.TrimSpace(name), variant) {
+			return true
+		}
+	}
+	return false
+}
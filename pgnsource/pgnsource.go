@@ -0,0 +1,106 @@
+// Package pgnsource finds and opens the PGN input of an import. It copes with
+// directories of millions of one-game files and with tar archives of them.
+package pgnsource
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Walk calls fn for every regular file under root. Directories are read
+// batchSize entries at a time, so huge directories are never loaded at once.
+func Walk(root string, batchSize int, fn func(path string)) error {
+	dir, err := os.Open(root)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	info, err := dir.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		fn(root)
+		return nil
+	}
+
+	for {
+		entries, err := dir.ReadDir(batchSize)
+		for _, entry := range entries {
+			path := filepath.Join(root, entry.Name())
+			switch {
+			case entry.IsDir():
+				if err := Walk(path, batchSize, fn); err != nil {
+					fmt.Printf("Error accessing directory %s: %s\n", path, err)
+				}
+			case entry.Type().IsRegular():
+				fn(path)
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// IsArchive reports whether the file is read as a tar archive
+func IsArchive(path string) bool {
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(strings.ToLower(path), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// Each calls fn with the content of the file, or with every regular entry
+// of a tar archive (named "archive.tar/entry")
+func Each(path string, fn func(name string, r io.Reader) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if !IsArchive(path) {
+		return fn(path, file)
+	}
+
+	var r io.Reader = file
+	if !strings.HasSuffix(strings.ToLower(path), ".tar") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(path+"/"+header.Name, archive); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Package progress keeps a rolling throughput and error-rate history in a local state file,
+// so a run can be compared with the previous 24 hours
+package progress
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// Window is how much history is kept
+const Window = 24 * time.Hour
+
+// Sample is the work done during one interval
+type Sample struct {
+	At      time.Time `json:"at"`
+	Seconds float64   `json:"seconds"`
+	Games   int       `json:"games"`
+	Errors  int       `json:"errors"`
+}
+
+// History is the state file content
+type History struct {
+	Samples []Sample `json:"samples"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// Load reads the state file, a missing file gives an empty history
+func Load(path string) (*History, error) {
+	h := &History{path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// Add records a sample and drops the ones older than Window
+func (h *History) Add(s Sample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.Samples = append(h.Samples, s)
+
+	cutoff := s.At.Add(-Window)
+	i := 0
+	for i < len(h.Samples) && h.Samples[i].At.Before(cutoff) {
+		i++
+	}
+	h.Samples = h.Samples[i:]
+}
+
+// Save writes the state file atomically
+func (h *History) Save() error {
+	h.mu.Lock()
+	data, err := json.MarshalIndent(h, "", "  ")
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := h.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.path)
+}
+
+// Rate returns games per second and the share of failed games since the given time
+func (h *History) Rate(since time.Time) (gamesPerSecond, errorRate float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var seconds float64
+	var games, failed int
+	for _, s := range h.Samples {
+		if s.At.Before(since) {
+			continue
+		}
+		seconds += s.Seconds
+		games += s.Games
+		failed += s.Errors
+	}
+
+	if seconds > 0 {
+		gamesPerSecond = float64(games) / seconds
+	}
+	if games+failed > 0 {
+		errorRate = float64(failed) / float64(games+failed)
+	}
+	return gamesPerSecond, errorRate
+}
+
+// Track samples the counters every interval and saves the history.
+// The returned stop function records the last partial interval.
+func (h *History) Track(interval time.Duration, counts func() (games, errors int)) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+
+		last := time.Now()
+		lastGames, lastErrors := counts()
+		record := func(now time.Time) {
+			games, failed := counts()
+			h.Add(Sample{
+				At:      now,
+				Seconds: now.Sub(last).Seconds(),
+				Games:   games - lastGames,
+				Errors:  failed - lastErrors,
+			})
+			last, lastGames, lastErrors = now, games, failed
+			h.Save()
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				record(now)
+			case <-done:
+				record(time.Now())
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+	}
+}
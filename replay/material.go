@@ -0,0 +1,69 @@
+package replay
+
+import (
+	"strings"
+
+	"github.com/notnil/chess"
+)
+
+var pieceOrder = []chess.PieceType{chess.King, chess.Queen, chess.Rook, chess.Bishop, chess.Knight, chess.Pawn}
+
+var pieceLetters = map[chess.PieceType]string{
+	chess.King: "K", chess.Queen: "Q", chess.Rook: "R", chess.Bishop: "B", chess.Knight: "N", chess.Pawn: "P",
+}
+
+var pieceValues = map[chess.PieceType]int{
+	chess.Queen: 9, chess.Rook: 5, chess.Bishop: 3, chess.Knight: 3, chess.Pawn: 1,
+}
+
+// MaterialSignature lists the pieces on the board, white first: "KRPPvKRP"
+func MaterialSignature(position *chess.Position) string {
+	counts := map[chess.Color]map[chess.PieceType]int{chess.White: {}, chess.Black: {}}
+	for _, piece := range position.Board().SquareMap() {
+		counts[piece.Color()][piece.Type()]++
+	}
+
+	var signature strings.Builder
+	for i, color := range []chess.Color{chess.White, chess.Black} {
+		if i > 0 {
+			signature.WriteString("v")
+		}
+		for _, pieceType := range pieceOrder {
+			signature.WriteString(strings.Repeat(pieceLetters[pieceType], counts[color][pieceType]))
+		}
+	}
+	return signature.String()
+}
+
+// Imbalance is white's material minus black's in pawns (Q=9, R=5, B=N=3, P=1)
+func Imbalance(position *chess.Position) int {
+	var imbalance int
+	for _, piece := range position.Board().SquareMap() {
+		if piece.Color() == chess.White {
+			imbalance += pieceValues[piece.Type()]
+		} else {
+			imbalance -= pieceValues[piece.Type()]
+		}
+	}
+	return imbalance
+}
+
+// MaxImbalance returns the largest imbalance of the game, negative when black
+// was ahead, e.g. -5 when black was once a rook up
+func MaxImbalance(game *chess.Game) int {
+	var largest int
+	for _, position := range game.Positions() {
+		imbalance := Imbalance(position)
+		if abs(imbalance) > abs(largest) {
+			largest = imbalance
+		}
+	}
+	return largest
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,153 @@
+// Package replay plays the moves of a game with a chess rules engine
+package replay
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/notnil/chess"
+)
+
+// IllegalMoveError reports the first move that can't be played
+type IllegalMoveError struct {
+	Ply  int // 1-based
+	Move string
+	Err  error
+}
+
+func (e *IllegalMoveError) Error() string {
+	number := (e.Ply + 1) / 2
+	dots := "."
+	if e.Ply%2 == 0 {
+		dots = "..."
+	}
+	return fmt.Sprintf("illegal move %d%s%s: %s", number, dots, e.Move, e.Err)
+}
+
+// Play replays SAN moves from the initial position. Missing or extra check
+// marks and long algebraic moves (e2e4, Ng1-f3, e7e8q) are accepted.
+func Play(sans []string) (*chess.Game, error) {
+	game := chess.NewGame()
+	for i, san := range sans {
+		move, err := decode(game.Position(), san)
+		if err == nil {
+			err = game.Move(move)
+		}
+		if err != nil {
+			return game, &IllegalMoveError{Ply: i + 1, Move: san, Err: err}
+		}
+	}
+	return game, nil
+}
+
+var longAlgebraic = strings.NewReplacer("-", "", "x", "", "=", "", "+", "", "#", "")
+
+// decode reads a SAN move, falling back to long algebraic notation
+func decode(position *chess.Position, san string) (*chess.Move, error) {
+	move, err := chess.AlgebraicNotation{}.Decode(position, san)
+	if err == nil {
+		return move, nil
+	}
+
+	long := strings.TrimLeft(longAlgebraic.Replace(san), "KQRBNP")
+	uci, uciErr := chess.UCINotation{}.Decode(position, strings.ToLower(long))
+	if uciErr != nil {
+		return nil, err
+	}
+	for _, valid := range position.ValidMoves() {
+		if valid.S1() == uci.S1() && valid.S2() == uci.S2() && valid.Promo() == uci.Promo() {
+			return valid, nil
+		}
+	}
+	return nil, err
+}
+
+// SANs returns the moves played in canonical SAN, whatever notation they were read in
+func SANs(game *chess.Game) []string {
+	positions := game.Positions()
+	moves := game.Moves()
+
+	sans := make([]string, 0, len(moves))
+	for i, move := range moves {
+		sans = append(sans, chess.AlgebraicNotation{}.Encode(positions[i], move))
+	}
+	return sans
+}
+
+// Positions returns the FEN after every move played
+func Positions(game *chess.Game) []string {
+	positions := game.Positions()
+	if len(positions) == 0 {
+		return nil
+	}
+
+	fens := make([]string, 0, len(positions)-1)
+	for _, position := range positions[1:] {
+		fens = append(fens, position.String())
+	}
+	return fens
+}
+
+// UCI returns the moves played in UCI long algebraic notation (e2e4, e7e8q)
+func UCI(game *chess.Game) []string {
+	positions := game.Positions()
+	moves := game.Moves()
+
+	ucis := make([]string, 0, len(moves))
+	for i, move := range moves {
+		ucis = append(ucis, chess.UCINotation{}.Encode(positions[i], move))
+	}
+	return ucis
+}
+
+// Summary is what the importers store from a replayed game
+type Summary struct {
+	Positions []string // FEN after every move
+	Zobrist   []int64  // Zobrist hash after every move
+	SANs      []string // canonical SAN of the moves played
+	UCI       []string
+	FinalFEN  string // "" when replay stopped at an illegal move
+
+	MaterialSignature string // final material, "KRPPvKRP"; "" after an illegal move
+	MaxImbalance      int    // see MaxImbalance
+	Ending            string // see Ending, "" after an illegal move
+}
+
+// Summarize replays SAN moves. On an illegal move the summary covers the
+// moves before it and the error is returned too.
+func Summarize(sans []string) (Summary, error) {
+	game, err := Play(sans)
+
+	summary := Summary{
+		Positions:    Positions(game),
+		Zobrist:      ZobristHashes(game),
+		SANs:         SANs(game),
+		UCI:          UCI(game),
+		MaxImbalance: MaxImbalance(game),
+	}
+	if err == nil {
+		summary.FinalFEN = game.Position().String()
+		summary.MaterialSignature = MaterialSignature(game.Position())
+		summary.Ending = Ending(game)
+	}
+	return summary, err
+}
+
+// Ending returns how the final position ended the game by the rules:
+// checkmate, stalemate, insufficient_material, fivefold_repetition or
+// seventy_five_move_rule, "" when the game could go on
+func Ending(game *chess.Game) string {
+	switch game.Method() {
+	case chess.Checkmate:
+		return "checkmate"
+	case chess.Stalemate:
+		return "stalemate"
+	case chess.InsufficientMaterial:
+		return "insufficient_material"
+	case chess.FivefoldRepetition:
+		return "fivefold_repetition"
+	case chess.SeventyFiveMoveRule:
+		return "seventy_five_move_rule"
+	}
+	return ""
+}
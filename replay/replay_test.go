@@ -0,0 +1,96 @@
+package replay
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name     string
+		sans     []string
+		finalFEN string
+		uci      []string
+	}{
+		{
+			name:     "en passant",
+			sans:     []string{"e4", "Nf6", "e5", "d5", "exd6"},
+			finalFEN: "rnbqkb1r/ppp1pppp/3P1n2/8/8/8/PPPP1PPP/RNBQKBNR b KQkq - 0 3",
+			uci:      []string{"e2e4", "g8f6", "e4e5", "d7d5", "e5d6"},
+		},
+		{
+			name:     "kingside castling",
+			sans:     []string{"e4", "e5", "Nf3", "Nc6", "Bc4", "Bc5", "O-O"},
+			finalFEN: "r1bqk1nr/pppp1ppp/2n5/2b1p3/2B1P3/5N2/PPPP1PPP/RNBQ1RK1 b kq - 5 4",
+			uci:      []string{"e2e4", "e7e5", "g1f3", "b8c6", "f1c4", "f8c5", "e1g1"},
+		},
+		{
+			name:     "queenside castling",
+			sans:     []string{"d4", "d5", "Nc3", "Nc6", "Bf4", "Bf5", "Qd2", "Qd7", "O-O-O", "O-O-O"},
+			finalFEN: "2kr1bnr/pppqpppp/2n5/3p1b2/3P1B2/2N5/PPPQPPPP/2KR1BNR w - - 8 6",
+			uci:      []string{"d2d4", "d7d5", "b1c3", "b8c6", "c1f4", "c8f5", "d1d2", "d8d7", "e1c1", "e8c8"},
+		},
+		{
+			name:     "rook moves lose castling rights",
+			sans:     []string{"h4", "a5", "Rh3", "Ra6"},
+			finalFEN: "1nbqkbnr/1ppppppp/r7/p7/7P/7R/PPPPPPP1/RNBQKBN1 w Qk - 2 3",
+			uci:      []string{"h2h4", "a7a5", "h1h3", "a8a6"},
+		},
+		{
+			name:     "underpromotion with capture",
+			sans:     []string{"h4", "g5", "hxg5", "Nf6", "gxf6", "Rg8", "fxe7", "d6", "exf8=N"},
+			finalFEN: "rnbqkNr1/ppp2p1p/3p4/8/8/8/PPPPPPP1/RNBQKBNR b KQq - 0 5",
+			uci:      []string{"h2h4", "g7g5", "h4g5", "g8f6", "g5f6", "h8g8", "f6e7", "d7d6", "e7f8n"},
+		},
+		{
+			name:     "promotion with check",
+			sans:     []string{"h4", "g5", "hxg5", "Nf6", "gxf6", "Rg8", "fxe7", "d6", "exf8=Q+"},
+			finalFEN: "rnbqkQr1/ppp2p1p/3p4/8/8/8/PPPPPPP1/RNBQKBNR b KQq - 0 5",
+			uci:      []string{"h2h4", "g7g5", "h4g5", "g8f6", "g5f6", "h8g8", "f6e7", "d7d6", "e7f8q"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary, err := Summarize(tt.sans)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if summary.FinalFEN != tt.finalFEN {
+				t.Errorf("FinalFEN = %q, want %q", summary.FinalFEN, tt.finalFEN)
+			}
+			if !slices.Equal(summary.UCI, tt.uci) {
+				t.Errorf("UCI = %q, want %q", summary.UCI, tt.uci)
+			}
+			if !slices.Equal(summary.SANs, tt.sans) {
+				t.Errorf("SANs = %q, want %q", summary.SANs, tt.sans)
+			}
+			if len(summary.Positions) != len(tt.sans) || summary.Positions[len(tt.sans)-1] != tt.finalFEN {
+				t.Errorf("Positions = %q, want %d ending with the final FEN", summary.Positions, len(tt.sans))
+			}
+		})
+	}
+}
+
+func TestPlayLongAlgebraic(t *testing.T) {
+	game, err := Play([]string{"e2e4", "e7-e5", "Ng1-f3", "b8c6", "Bf1-c4", "Ng8-f6", "e1g1", "f8c5", "b2b4", "c5xb4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"e4", "e5", "Nf3", "Nc6", "Bc4", "Nf6", "O-O", "Bc5", "b4", "Bxb4"}
+	if got := SANs(game); !slices.Equal(got, want) {
+		t.Errorf("SANs = %q, want %q", got, want)
+	}
+}
+
+func TestPlayIllegal(t *testing.T) {
+	_, err := Play([]string{"e4", "e5", "Ke3"})
+	var illegal *IllegalMoveError
+	if !errors.As(err, &illegal) || illegal.Ply != 3 {
+		t.Fatalf("Play = %v, want an illegal move at ply 3", err)
+	}
+	if want := "illegal move 2.Ke3: " + illegal.Err.Error(); err.Error() != want {
+		t.Errorf("error = %q, want %q", err.Error(), want)
+	}
+}
@@ -0,0 +1,69 @@
+package replay
+
+import "github.com/notnil/chess"
+
+// Zobrist keys: 12 pieces x 64 squares, side to move, 4 castling rights and
+// 8 en passant files. They come from a fixed seed, so hashes are stable
+// between runs (but not Polyglot compatible).
+var zobristKeys = func() [12*64 + 1 + 4 + 8]uint64 {
+	var keys [12*64 + 1 + 4 + 8]uint64
+	state := uint64(0x9E3779B97F4A7C15)
+	for i := range keys {
+		// splitmix64
+		state += 0x9E3779B97F4A7C15
+		z := state
+		z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+		z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+		keys[i] = z ^ (z >> 31)
+	}
+	return keys
+}()
+
+const (
+	zobristTurn      = 12 * 64
+	zobristCastling  = zobristTurn + 1
+	zobristEnPassant = zobristCastling + 4
+)
+
+// Zobrist returns the 64-bit Zobrist hash of a position
+func Zobrist(position *chess.Position) uint64 {
+	var hash uint64
+	for square, piece := range position.Board().SquareMap() {
+		index := (int(piece.Color())-1)*6 + int(piece.Type()) - 1
+		hash ^= zobristKeys[index*64+int(square)]
+	}
+
+	if position.Turn() == chess.Black {
+		hash ^= zobristKeys[zobristTurn]
+	}
+
+	rights := position.CastleRights()
+	for i, right := range []struct {
+		color chess.Color
+		side  chess.Side
+	}{{chess.White, chess.KingSide}, {chess.White, chess.QueenSide}, {chess.Black, chess.KingSide}, {chess.Black, chess.QueenSide}} {
+		if rights.CanCastle(right.color, right.side) {
+			hash ^= zobristKeys[zobristCastling+i]
+		}
+	}
+
+	if square := position.EnPassantSquare(); square != chess.NoSquare {
+		hash ^= zobristKeys[zobristEnPassant+int(square.File())]
+	}
+	return hash
+}
+
+// ZobristHashes returns the hash after every move played, as signed integers
+// the way BSON and Postgres BIGINT store them
+func ZobristHashes(game *chess.Game) []int64 {
+	positions := game.Positions()
+	if len(positions) == 0 {
+		return nil
+	}
+
+	hashes := make([]int64, 0, len(positions)-1)
+	for _, position := range positions[1:] {
+		hashes = append(hashes, int64(Zobrist(position)))
+	}
+	return hashes
+}
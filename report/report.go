@@ -0,0 +1,116 @@
+// Package report collects per-game problems found during an import.
+// Entries are appended to a JSON lines file as they happen and counted by kind.
+package report
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Entry is one problem with one game
+type Entry struct {
+	File    string `json:"file"`
+	Game    int    `json:"game"` // 1-based index of the game in the file
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// Report is safe for concurrent use
+type Report struct {
+	path   string
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	counts map[string]int
+}
+
+// Open creates the report file, an empty path only counts entries
+func Open(path string) (*Report, error) {
+	r := &Report{path: path, counts: make(map[string]int)}
+	if path == "" {
+		return r, nil
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r.file = file
+	r.writer = bufio.NewWriter(file)
+
+	return r, nil
+}
+
+// Add records an entry
+func (r *Report) Add(file string, game int, kind, message string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.counts[kind]++
+	if r.writer == nil {
+		return
+	}
+
+	data, err := json.Marshal(Entry{File: file, Game: game, Kind: kind, Message: message})
+	if err != nil {
+		return
+	}
+	r.writer.Write(append(data, '\n'))
+}
+
+// Counts returns the number of entries per kind
+func (r *Report) Counts() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := make(map[string]int, len(r.counts))
+	for kind, n := range r.counts {
+		counts[kind] = n
+	}
+	return counts
+}
+
+// Summary is a one-line description for the end of a run
+func (r *Report) Summary() string {
+	counts := r.Counts()
+	if len(counts) == 0 {
+		return "no problems found"
+	}
+
+	kinds := make([]string, 0, len(counts))
+	for kind := range counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	parts := make([]string, len(kinds))
+	for i, kind := range kinds {
+		parts[i] = fmt.Sprintf("%s: %d", kind, counts[kind])
+	}
+
+	summary := strings.Join(parts, ", ")
+	if r.path != "" {
+		summary += " (see " + r.path + ")"
+	}
+	return summary
+}
+
+// Close flushes the report file
+func (r *Report) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	if err := r.writer.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
@@ -0,0 +1,165 @@
+// Package routing sends games to different collections by rules like
+// "speed=bullet:games_bullet", so one import can split games by category
+package routing
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Game is what rules look at
+type Game struct {
+	Speed    string // see pgnparse.Speed
+	Variant  string
+	WhiteElo *int
+	BlackElo *int
+	File     string
+}
+
+// Rule sends the games matching all its conditions to Collection
+type Rule struct {
+	Conditions []Condition
+	Collection string
+}
+
+// Condition is one field=value test
+type Condition struct {
+	Field string // speed, variant, elo or file
+	Value string
+}
+
+// Parse reads comma separated rules, "conditions:collection", where
+// conditions are joined by '&':
+//
+//	speed=bullet:games_bullet,variant=Crazyhouse:games_zh,speed=blitz&elo=2200-:games_blitz_master
+//
+// speed and variant compare case-insensitively, elo=MIN-MAX takes the
+// average rating of rated games (either bound may be left out) and file=GLOB
+// matches the file path or name.
+func Parse(spec string) ([]Rule, error) {
+	var rules []Rule
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		// Globs may contain ':' on Windows, the collection comes last
+		i := strings.LastIndex(part, ":")
+		if i < 0 || strings.TrimSpace(part[i+1:]) == "" {
+			return nil, fmt.Errorf("route %q has no collection", part)
+		}
+		rule := Rule{Collection: strings.TrimSpace(part[i+1:])}
+
+		for _, test := range strings.Split(part[:i], "&") {
+			field, value, found := strings.Cut(strings.TrimSpace(test), "=")
+			if !found {
+				return nil, fmt.Errorf("route condition %q is not field=value", test)
+			}
+			condition := Condition{Field: strings.ToLower(field), Value: value}
+			if err := condition.check(); err != nil {
+				return nil, err
+			}
+			rule.Conditions = append(rule.Conditions, condition)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Route returns the collection of the first rule the game matches, "" when none does
+func Route(rules []Rule, game Game) string {
+	for _, rule := range rules {
+		if rule.matches(game) {
+			return rule.Collection
+		}
+	}
+	return ""
+}
+
+// Collections lists the collections the rules route to, without repeats
+func Collections(rules []Rule) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		if !seen[rule.Collection] {
+			seen[rule.Collection] = true
+			names = append(names, rule.Collection)
+		}
+	}
+	return names
+}
+
+func (r Rule) matches(game Game) bool {
+	for _, c := range r.Conditions {
+		if !c.matches(game) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Condition) check() error {
+	switch c.Field {
+	case "speed", "variant":
+		return nil
+	case "elo":
+		_, _, err := eloRange(c.Value)
+		return err
+	case "file":
+		_, err := filepath.Match(c.Value, "")
+		return err
+	}
+	return fmt.Errorf("unknown route field %q (speed, variant, elo or file)", c.Field)
+}
+
+func (c Condition) matches(game Game) bool {
+	switch c.Field {
+	case "speed":
+		return strings.EqualFold(game.Speed, c.Value)
+	case "variant":
+		variant := game.Variant
+		if variant == "" {
+			variant = "Standard"
+		}
+		return strings.EqualFold(variant, c.Value)
+	case "elo":
+		if game.WhiteElo == nil || game.BlackElo == nil {
+			return false
+		}
+		min, max, _ := eloRange(c.Value)
+		average := (*game.WhiteElo + *game.BlackElo) / 2
+		return average >= min && average <= max
+	case "file":
+		matched, _ := filepath.Match(c.Value, game.File)
+		if !matched {
+			matched, _ = filepath.Match(c.Value, filepath.Base(game.File))
+		}
+		return matched
+	}
+	return false
+}
+
+// eloRange reads "2000-2199", "2200-" or "-1200"
+func eloRange(value string) (int, int, error) {
+	low, high, found := strings.Cut(value, "-")
+	if !found {
+		return 0, 0, fmt.Errorf("elo route %q is not MIN-MAX", value)
+	}
+
+	min, max := 0, int(^uint(0)>>1)
+	var err error
+	if low != "" {
+		if min, err = strconv.Atoi(low); err != nil {
+			return 0, 0, fmt.Errorf("elo route %q: %s", value, err)
+		}
+	}
+	if high != "" {
+		if max, err = strconv.Atoi(high); err != nil {
+			return 0, 0, fmt.Errorf("elo route %q: %s", value, err)
+		}
+	}
+	return min, max, nil
+}
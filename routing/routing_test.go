@@ -0,0 +1,98 @@
+package routing
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec  string
+		rules int
+		err   bool
+	}{
+		{"", 0, false},
+		{"speed=bullet:games_bullet", 1, false},
+		{" speed=bullet:games_bullet , variant=Crazyhouse:games_zh ,", 2, false},
+		{"speed=blitz&elo=2200-:games_blitz_master", 1, false},
+		{`file=C:\pgn\*.pgn:games_windows`, 1, false},
+		{"speed=bullet", 0, true},
+		{"speed=bullet: ", 0, true},
+		{"bullet:games_bullet", 0, true},
+		{"color=white:games_white", 0, true},
+		{"elo=2200:games_master", 0, true},
+		{"elo=abc-:games_master", 0, true},
+		{"file=[:games_broken", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			rules, err := Parse(tt.spec)
+			if (err != nil) != tt.err {
+				t.Fatalf("Parse error = %v, want error: %v", err, tt.err)
+			}
+			if len(rules) != tt.rules {
+				t.Errorf("Parse returned %d rules, want %d", len(rules), tt.rules)
+			}
+		})
+	}
+}
+
+func TestRoute(t *testing.T) {
+	rules, err := Parse("speed=bullet:games_bullet,variant=Crazyhouse:games_zh,speed=blitz&elo=2200-:games_blitz_master,elo=-1199:games_beginners,file=twic*.pgn:games_otb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		game Game
+		want string
+	}{
+		{"speed", Game{Speed: "bullet"}, "games_bullet"},
+		{"speed ignores case", Game{Speed: "Bullet"}, "games_bullet"},
+		{"first matching rule wins", Game{Speed: "bullet", Variant: "Crazyhouse"}, "games_bullet"},
+		{"variant", Game{Speed: "blitz", Variant: "crazyhouse"}, "games_zh"},
+		{"all conditions", Game{Speed: "blitz", WhiteElo: elo(2250), BlackElo: elo(2190)}, "games_blitz_master"},
+		{"average below the band", Game{Speed: "blitz", WhiteElo: elo(2250), BlackElo: elo(2100)}, ""},
+		{"only some conditions", Game{Speed: "rapid", WhiteElo: elo(2300), BlackElo: elo(2300)}, ""},
+		{"upper bound", Game{WhiteElo: elo(1100), BlackElo: elo(1250)}, "games_beginners"},
+		{"unrated player", Game{WhiteElo: elo(1100), BlackElo: nil}, ""},
+		{"file name", Game{File: "2024/twic1500.pgn"}, "games_otb"},
+		{"archive entry", Game{File: "twic.tgz/twic1501.pgn"}, "games_otb"},
+		{"no rule matches", Game{Speed: "classical", File: "lichess.pgn"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Route(rules, tt.game); got != tt.want {
+				t.Errorf("Route = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteStandardVariant(t *testing.T) {
+	rules, err := Parse("variant=Standard:games_standard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := Route(rules, Game{}); got != "games_standard" {
+		t.Errorf("Route of a game without Variant tag = %q, want games_standard", got)
+	}
+}
+
+func TestCollections(t *testing.T) {
+	rules, err := Parse("speed=bullet:games_fast,speed=blitz:games_fast,variant=Atomic:games_atomic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"games_fast", "games_atomic"}
+	if got := Collections(rules); !slices.Equal(got, want) {
+		t.Errorf("Collections = %q, want %q", got, want)
+	}
+}
+
+func elo(rating int) *int {
+	return &rating
+}
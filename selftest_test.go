@@ -0,0 +1,158 @@
+//go:build integration
+
+package main
+
+// Helpers shared by the integration tests of both importers: they start
+// throwaway MongoDB or Postgres containers (Docker is required), provide a
+// small corpus of edge case games and check what was stored from it
+
+import (
+	"context"
+	"embed"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/mongodb"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+//go:embed testdata/selftest/*.pgn
+var corpus embed.FS
+
+// selftestTable is the folder the corpus is written to, and so the Postgres table
+const selftestTable = "selftest"
+
+// selftestGames is the number of games stored from the corpus (one game is repeated,
+// one is malformed)
+const selftestGames = 8
+
+// selftestCheck is a value the import must store for the game of a white player
+type selftestCheck struct {
+	White  string
+	Field  string // MongoDB field
+	Column string // Postgres column
+	Want   string // "" for a missing field or NULL
+}
+
+// selftestChecks cover normalization, identity, replay and encoding edge cases
+var selftestChecks = []selftestCheck{
+	{"alice", "source", "source", "lichess"},
+	{"alice", "sourceId", "source_id", "abcd1234"},
+	{"alice", "plyCount", "ply_count", "7"},
+	{"alice", "whiteRatingDiff", "white_rating_diff", "6"},
+	{"alice", "termination_detail", "termination_detail", "checkmate"},
+	{"alice", "materialSignature", "material_signature", "KQRRBBNNPPPPPPPPvKQRRBBNNPPPPPPP"},
+	{"alice", "maxImbalance", "max_imbalance", "1"},
+	{"gina", "result", "result", "1/2-1/2"},
+	{"gina", "resultRaw", "result_raw", "½-½"},
+	{"gina", "tournamentId", "tournament_id", "yc1WW2Ox"},
+	{"gina", "termination_detail", "termination_detail", "draw_agreement"},
+	{"erin", "variant", "variant", "Crazyhouse"},
+	{"erin", "finalFen", "final_fen", ""},
+	{"carol", "source", "source", "chesscom"},
+	{"carol", "sourceId", "source_id", "987654321"},
+	{"carol", "termination_detail", "termination_detail", "time_forfeit"},
+	{"Smith, John", "source", "source", "hash"},
+	{"Smith, John", "plyCount", "ply_count", "10"},
+	{"Smith, John", "termination_detail", "termination_detail", "resignation"},
+	{"Smith, John", "eventType", "event_type", "swiss"},
+	{"Smith, John", "board", "board", "4"},
+	{"Smith, John", "annotator", "annotator", "Fritz"},
+	{"Müller, Karl", "black", "black", "Schmidt, Otto"},
+	// windows.pgn: byte order mark, CRLF and no blank lines
+	{"ivan", "event", "event", "Casual game"},
+	{"ivan", "plyCount", "ply_count", "4"},
+	{"ivan", "termination_detail", "termination_detail", "checkmate"},
+	{"judy", "round", "round", "2"},
+	{"judy", "plyCount", "ply_count", "5"},
+}
+
+// writeCorpus writes the corpus to a temporary folder, inside a selftestTable folder
+func writeCorpus(t *testing.T) string {
+	t.Helper()
+	folder := t.TempDir()
+
+	files, err := corpus.ReadDir("testdata/selftest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(folder, selftestTable), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := corpus.ReadFile("testdata/selftest/" + file.Name())
+		if err == nil {
+			err = os.WriteFile(filepath.Join(folder, selftestTable, file.Name()), data, 0o644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return folder
+}
+
+// startMongo starts a MongoDB container for the test and returns its URI
+func startMongo(t *testing.T) string {
+	t.Helper()
+	ctx := context.Background()
+
+	container, err := mongodb.Run(ctx, "mongo:7")
+	if err != nil {
+		t.Fatal("Failed to start MongoDB container:", err)
+	}
+	t.Cleanup(func() { container.Terminate(context.Background()) })
+
+	uri, err := container.ConnectionString(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uri
+}
+
+// startPostgres starts a Postgres container for the test and returns its connection URL
+func startPostgres(t *testing.T) string {
+	t.Helper()
+	ctx := context.Background()
+
+	container, err := postgres.Run(ctx, "postgres:16-alpine",
+		postgres.WithDatabase("selftest"),
+		postgres.WithUsername("selftest"),
+		postgres.WithPassword("selftest"),
+		testcontainers.WithWaitStrategy(wait.ForLog("database system is ready to accept connections").
+			WithOccurrence(2).WithStartupTimeout(time.Minute)),
+	)
+	if err != nil {
+		t.Fatal("Failed to start Postgres container:", err)
+	}
+	t.Cleanup(func() { container.Terminate(context.Background()) })
+
+	url, err := container.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return url
+}
+
+// verifyCorpus compares the stored game count and values with the expected ones.
+// value returns a stored value as text, "" when it is missing.
+func verifyCorpus(t *testing.T, count int, value func(c selftestCheck) (string, error)) {
+	t.Helper()
+	if count != selftestGames {
+		t.Errorf("stored %d games, want %d", count, selftestGames)
+	}
+
+	for _, c := range selftestChecks {
+		got, err := value(c)
+		if err != nil {
+			t.Errorf("%s: %s: %s", c.White, c.Field, err)
+			continue
+		}
+		if got != c.Want {
+			t.Errorf("%s: %s is %q, want %q", c.White, c.Field, got, c.Want)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+// Package bq stages the games of the MongoDB importer in GCS as gzipped
+// NDJSON objects and loads every object into a BigQuery table with a load
+// job (--backend=bigquery): load jobs are free, unlike streaming inserts.
+// Loads run while the next object is written.
+package bq
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"importGames/env"
+	"importGames/fieldmap"
+	"importGames/jsondoc"
+	"importGames/sink"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
+)
+
+// stagedGames are written to a staging object before it is loaded
+const stagedGames = 1_000_000
+
+type store struct {
+	tableName string // [project.]dataset.table
+	staging   string // gs://bucket/prefix
+
+	gcs      *storage.Client
+	bq       *bigquery.Client
+	table    *bigquery.Table
+	bucket   string
+	prefix   string
+	importId string
+	fields   fieldmap.Map
+	lostFunc func(batch []sink.Record, err error)
+
+	mu       sync.Mutex
+	object   *storage.Writer // nil until a game is staged
+	cancel   context.CancelFunc
+	gz       *gzip.Writer
+	name     string
+	staged   []sink.Record // games in the object, all lost when it fails
+	objects  int
+	loads    sync.WaitGroup
+	loadErrs []error
+}
+
+func init() {
+	sink.Register("bigquery", &store{})
+}
+
+func (s *store) RegisterFlags() {
+	flag.StringVar(&s.tableName, "bq-table", env.String("BIGQUERY_TABLE", "chess.games"), "BigQuery table of --backend=bigquery, dataset.table or project.dataset.table, created if needed")
+	flag.StringVar(&s.staging, "bq-staging", env.String("BIGQUERY_STAGING", ""), "gs://bucket/prefix where --backend=bigquery stages the games before loading them")
+}
+
+func (s *store) Open(ctx context.Context, opts sink.Options) error {
+	parts := strings.Split(s.tableName, ".")
+	if !strings.HasPrefix(s.staging, "gs://") || len(parts) < 2 {
+		return fmt.Errorf("needs --bq-staging=gs://bucket/prefix and --bq-table=[project.]dataset.table")
+	}
+	s.bucket, s.prefix, _ = strings.Cut(strings.TrimPrefix(s.staging, "gs://"), "/")
+	project := bigquery.DetectProjectID
+	if len(parts) == 3 {
+		project, parts = parts[0], parts[1:]
+	}
+
+	bq, err := bigquery.NewClient(ctx, project)
+	if err != nil {
+		return err
+	}
+	gcs, err := storage.NewClient(ctx)
+	if err != nil {
+		bq.Close()
+		return err
+	}
+	s.gcs, s.bq, s.table = gcs, bq, bq.Dataset(parts[0]).Table(parts[1])
+	s.importId, s.fields, s.lostFunc = opts.ImportId, opts.Fields, opts.Lost
+	return nil
+}
+
+// EnsureSchema has nothing to do, load jobs create the table
+func (s *store) EnsureSchema(ctx context.Context, table sink.Table) error {
+	return nil
+}
+
+// schema types the fields (as renamed by --field-map) like the documents.
+// Fields it doesn't list are left out of the table.
+func schema(fields fieldmap.Map) bigquery.Schema {
+	types := map[string]bigquery.FieldType{
+		"playedAt":      bigquery.TimestampFieldType,
+		"eventDate":     bigquery.TimestampFieldType,
+		"extra_tags":    bigquery.JSONFieldType,
+		"movesZstd":     bigquery.BytesFieldType,
+		"positionsZstd": bigquery.BytesFieldType,
+	}
+	for _, field := range []string{"source", "sourceId", "gameId", "movesHash", "contentHash", "importId", "importFile", "opening", "variation",
+		"eco", "result", "resultRaw", "white", "black", "finalFen", "materialSignature", "event", "tournamentId", "eventType", "section", "stage",
+		"annotator", "dataSource", "time_control", "termination", "termination_detail", "variant", "round", "whiteTitle", "blackTitle",
+		"date", "time", "playedAtPrecision", "site"} {
+		types[field] = bigquery.StringFieldType
+	}
+	for _, field := range []string{"whiteElo", "blackElo", "moves_count", "plyCount", "maxImbalance", "board", "whiteRatingDiff", "blackRatingDiff"} {
+		types[field] = bigquery.IntegerFieldType
+	}
+
+	var schema bigquery.Schema
+	for field, fieldType := range types {
+		schema = append(schema, &bigquery.FieldSchema{Name: fields.Name(field), Type: fieldType})
+	}
+	repeated := map[string]bigquery.FieldType{
+		"uci_moves": bigquery.StringFieldType,
+		"positions": bigquery.StringFieldType,
+		"zobrist":   bigquery.IntegerFieldType,
+		"features":  bigquery.FloatFieldType,
+	}
+	for field, fieldType := range repeated {
+		schema = append(schema, &bigquery.FieldSchema{Name: fields.Name(field), Type: fieldType, Repeated: true})
+	}
+	schema = append(schema, &bigquery.FieldSchema{Name: fields.Name("moves"), Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+		{Name: "ply", Type: bigquery.IntegerFieldType},
+		{Name: "san", Type: bigquery.StringFieldType},
+		{Name: "uci", Type: bigquery.StringFieldType},
+		{Name: "clock", Type: bigquery.FloatFieldType},
+		{Name: "eval", Type: bigquery.FloatFieldType},
+		{Name: "comment", Type: bigquery.StringFieldType},
+	}})
+	sort.Slice(schema, func(i, j int) bool { return schema[i].Name < schema[j].Name })
+	return schema
+}
+
+// WriteBatch stages the games. BigQuery has no unique keys, games imported
+// twice are there twice.
+func (s *store) WriteBatch(ctx context.Context, batch []sink.Record) (map[int]error, error) {
+	failed := make(map[int]error)
+	var lines []byte
+	for i, r := range batch {
+		line, err := jsondoc.Marshal(r.Doc)
+		if err != nil {
+			failed[i] = err
+			continue
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.object == nil {
+		s.objects++
+		s.name = path.Join(s.prefix, fmt.Sprintf("%s_%05d.ndjson.gz", s.importId, s.objects))
+		var ctx context.Context
+		ctx, s.cancel = context.WithCancel(context.Background())
+		s.object = s.gcs.Bucket(s.bucket).Object(s.name).NewWriter(ctx)
+		s.object.ContentType = "application/x-ndjson"
+		s.gz = gzip.NewWriter(s.object)
+	}
+
+	// The games of earlier batches were reported stored, so when the object
+	// fails they are reported again as failed; the batch's games fail with err
+	earlier := len(s.staged)
+	if _, err := s.gz.Write(lines); err != nil {
+		s.cancel() // drops the upload
+		s.lost(s.staged[:earlier], err)
+		s.object, s.gz, s.staged = nil, nil, nil
+		return nil, err
+	}
+	for i, r := range batch {
+		if _, ok := failed[i]; !ok {
+			s.staged = append(s.staged, r)
+		}
+	}
+	if len(s.staged) >= stagedGames {
+		staged := s.staged
+		if err := s.finishObject(); err != nil {
+			s.lost(staged[:earlier], err)
+			return nil, err
+		}
+	}
+	return failed, nil
+}
+
+// lost reports games of earlier batches whose object failed
+func (s *store) lost(games []sink.Record, err error) {
+	if len(games) == 0 {
+		return
+	}
+	fmt.Printf("Failed to stage gs://%s/%s, its %d games of earlier batches are lost: %s\n", s.bucket, s.name, len(games), err)
+	if s.lostFunc != nil {
+		s.lostFunc(games, err)
+	}
+}
+
+// finishObject uploads the object being written and loads it
+func (s *store) finishObject() error {
+	err := errors.Join(s.gz.Close(), s.object.Close())
+	name := s.name
+	s.cancel()
+	s.object, s.gz, s.staged = nil, nil, nil
+	if err != nil {
+		return err
+	}
+
+	s.loads.Add(1)
+	go func() {
+		defer s.loads.Done()
+		if err := s.load(name); err != nil {
+			fmt.Printf("Failed to load gs://%s/%s into BigQuery, it stays staged: %s\n", s.bucket, name, err)
+			s.mu.Lock()
+			s.loadErrs = append(s.loadErrs, err)
+			s.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+// load runs the load job of a staged object, then deletes it
+func (s *store) load(name string) error {
+	ctx := context.Background()
+	source := bigquery.NewGCSReference("gs://" + s.bucket + "/" + name)
+	source.SourceFormat = bigquery.JSON
+	source.Compression = bigquery.Gzip
+	source.Schema = schema(s.fields)
+	source.IgnoreUnknownValues = true
+	loader := s.table.LoaderFrom(source)
+	loader.WriteDisposition = bigquery.WriteAppend
+	loader.CreateDisposition = bigquery.CreateIfNeeded
+
+	job, err := loader.Run(ctx)
+	if err != nil {
+		return err
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	if err := status.Err(); err != nil {
+		return err
+	}
+	fmt.Printf("Loaded gs://%s/%s into BigQuery\n", s.bucket, name)
+	return s.gcs.Bucket(s.bucket).Object(name).Delete(ctx)
+}
+
+// Close loads the last object and waits for the loads
+func (s *store) Close() error {
+	s.mu.Lock()
+	var err error
+	if s.object != nil {
+		staged := s.staged
+		if err = s.finishObject(); err != nil {
+			s.lost(staged, err)
+		}
+	}
+	s.mu.Unlock()
+	s.loads.Wait()
+
+	errs := append([]error{err}, s.loadErrs...)
+	errs = append(errs, s.bq.Close(), s.gcs.Close())
+	return errors.Join(errs...)
+}
@@ -0,0 +1,131 @@
+// Package csv writes the selected columns of every game of the Postgres
+// importer into one CSV file (--backend=csv) for spreadsheets: a header,
+// RFC 4180 quoting, empty cells for NULL, moves joined with spaces and
+// other lists as JSON
+package csv
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"importGames/env"
+	"importGames/pgcopy"
+	"importGames/sink"
+)
+
+type store struct {
+	path string
+
+	mu      sync.Mutex
+	names   []string // columns of the file, see sink.Options.Columns
+	file    *os.File
+	w       *csv.Writer
+	header  bool
+	indexes []int // of the file's columns in the rows
+	types   []string
+	games   int
+}
+
+func init() {
+	sink.Register("csv", &store{})
+}
+
+func (s *store) RegisterFlags() {
+	flag.StringVar(&s.path, "csv-file", env.String("CSV_FILE", "games.csv"), "file of --backend=csv, replaced if it exists")
+}
+
+func (s *store) Open(ctx context.Context, opts sink.Options) error {
+	file, err := os.Create(s.path)
+	if err != nil {
+		return err
+	}
+	s.file, s.w, s.names = file, csv.NewWriter(file), opts.Columns
+	return nil
+}
+
+// EnsureSchema writes the header, with the columns of the first table:
+// games of every table go to the file
+func (s *store) EnsureSchema(ctx context.Context, table sink.Table) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.header {
+		return nil
+	}
+
+	names := s.names
+	if len(names) == 0 {
+		for _, c := range table.Columns {
+			names = append(names, c.Name)
+		}
+	}
+	for _, name := range names {
+		i := slices.IndexFunc(table.Columns, func(c sink.Column) bool { return c.Name == name })
+		if i < 0 {
+			return fmt.Errorf("no column %s", name)
+		}
+		s.indexes, s.types = append(s.indexes, i), append(s.types, table.Columns[i].Type)
+	}
+	if err := s.w.Write(names); err != nil {
+		return err
+	}
+	s.header = true
+	return nil
+}
+
+// value is the text of a column value in a cell
+func value(v any, ddl string) string {
+	rv := reflect.ValueOf(v)
+	if v == nil || (rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Slice) && rv.IsNil() {
+		return ""
+	}
+	if moves, ok := v.([]string); ok {
+		return strings.Join(moves, " ")
+	}
+	if strings.Contains(ddl, "[]") {
+		array, _ := json.Marshal(v)
+		return string(array)
+	}
+	text, _ := pgcopy.Value(v, ddl)
+	return text
+}
+
+// WriteBatch appends a row per game. Nothing finds games written before.
+func (s *store) WriteBatch(ctx context.Context, batch []sink.Record) (map[int]error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := make([]string, len(s.indexes))
+	for _, r := range batch {
+		for i, j := range s.indexes {
+			record[i] = value(r.Row[j], s.types[i])
+		}
+		if err := s.w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	s.w.Flush()
+	if err := s.w.Error(); err != nil {
+		return nil, err
+	}
+	s.games += len(batch)
+	return nil, nil
+}
+
+func (s *store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.Flush()
+	if err := errors.Join(s.w.Error(), s.file.Close()); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d games to %s\n", s.games, s.file.Name())
+	return nil
+}
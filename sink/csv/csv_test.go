@@ -0,0 +1,64 @@
+package csv
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"importGames/sink"
+)
+
+func TestValue(t *testing.T) {
+	elo := 1500
+	var noElo *int
+
+	tests := []struct {
+		name string
+		v    any
+		ddl  string
+		want string
+	}{
+		{"nil", nil, "TEXT", ""},
+		{"nil pointer", noElo, "INTEGER", ""},
+		{"pointer", &elo, "INTEGER", "1500"},
+		{"moves", []string{"e4", "e5", "Nf3"}, "TEXT[]", "e4 e5 Nf3"},
+		{"other list", []*int{&elo, nil}, "INTEGER[]", "[1500,null]"},
+		{"nil list", []string(nil), "TEXT[]", ""},
+		{"json", []byte(`{"a":1}`), "JSONB", `{"a":1}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := value(tt.v, tt.ddl); got != tt.want {
+				t.Errorf("value = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestColumnOrder(t *testing.T) {
+	ctx := context.Background()
+	s := &store{path: filepath.Join(t.TempDir(), "games.csv")}
+	if err := s.Open(ctx, sink.Options{Columns: []string{"white", "source"}}); err != nil {
+		t.Fatal(err)
+	}
+	table := sink.Table{Name: "games", Columns: []sink.Column{{Name: "source", Type: "TEXT"}, {Name: "white", Type: "TEXT"}}}
+	if err := s.EnsureSchema(ctx, table); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WriteBatch(ctx, []sink.Record{{Table: "games", Row: []any{"lichess", "Carlsen, Magnus"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(s.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "white,source\n\"Carlsen, Magnus\",lichess\n"; string(got) != want {
+		t.Errorf("wrote %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,129 @@
+// Package es bulk-indexes the games of the MongoDB importer into
+// Elasticsearch or OpenSearch (--backend=elasticsearch), the MongoDB
+// documents with the game id as document id
+package es
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+
+	"importGames/elastic"
+	"importGames/env"
+	"importGames/fieldmap"
+	"importGames/jsondoc"
+	"importGames/sink"
+)
+
+type store struct {
+	url, index  string
+	nestedMoves bool
+
+	client        *elastic.Client
+	upsert        bool
+	dedupeContent bool
+}
+
+func init() {
+	sink.Register("elasticsearch", &store{})
+}
+
+func (s *store) RegisterFlags() {
+	flag.StringVar(&s.url, "es-url", env.String("ELASTICSEARCH_URL", "http://localhost:9200"), "Elasticsearch or OpenSearch URL of --backend=elasticsearch")
+	flag.StringVar(&s.index, "es-index", env.String("ELASTICSEARCH_INDEX", "games"), "index receiving the games, created with its mapping if needed")
+	flag.BoolVar(&s.nestedMoves, "es-nested-moves", env.Bool("ELASTICSEARCH_NESTED_MOVES", false), "map moves as nested documents, to search moves by ply, clock or eval (a Lucene document per move)")
+}
+
+// Open creates --es-index, whichever the collection
+func (s *store) Open(ctx context.Context, opts sink.Options) error {
+	s.client = elastic.New(s.url, s.index, os.Getenv("ELASTICSEARCH_USERNAME"), os.Getenv("ELASTICSEARCH_PASSWORD"), os.Getenv("ELASTICSEARCH_API_KEY"))
+	s.upsert, s.dedupeContent = opts.Upsert, opts.DedupeContent
+	return s.client.EnsureIndex(ctx, mappings(opts.Fields, s.nestedMoves))
+}
+
+func (s *store) EnsureSchema(ctx context.Context, table sink.Table) error {
+	return nil
+}
+
+// mappings type the fields (as renamed by --field-map): ids and codes are
+// keywords, names text with a keyword for sorting and aggregations, so
+// full-text and fuzzy searches on players and events work. Moves are kept
+// in _source only, unless nestedMoves.
+func mappings(fields fieldmap.Map, nestedMoves bool) map[string]any {
+	keyword := map[string]any{"type": "keyword"}
+	text := map[string]any{"type": "text", "fields": map[string]any{"keyword": map[string]any{"type": "keyword", "ignore_above": 256}}}
+	types := map[string]any{
+		"playedAt":      map[string]any{"type": "date"},
+		"eventDate":     map[string]any{"type": "date"},
+		"moves":         map[string]any{"type": "object", "enabled": false},
+		"uci_moves":     map[string]any{"type": "keyword", "index": false, "doc_values": false},
+		"positions":     map[string]any{"type": "keyword", "index": false, "doc_values": false},
+		"features":      map[string]any{"type": "float", "index": false},
+		"zobrist":       map[string]any{"type": "long"},
+		"extra_tags":    map[string]any{"type": "object", "enabled": false},
+		"movesZstd":     map[string]any{"type": "binary"},
+		"positionsZstd": map[string]any{"type": "binary"},
+		"rawPgnOffset":  map[string]any{"type": "long"},
+	}
+	for _, field := range []string{"white", "black", "event", "opening", "variation", "site", "annotator", "section", "stage"} {
+		types[field] = text
+	}
+	for _, field := range []string{"source", "sourceId", "gameId", "movesHash", "contentHash", "importId", "importFile", "eco", "result", "resultRaw",
+		"time_control", "termination", "termination_detail", "variant", "round", "whiteTitle", "blackTitle", "tournamentId", "eventType",
+		"dataSource", "date", "time", "playedAtPrecision", "finalFen", "materialSignature"} {
+		types[field] = keyword
+	}
+	for _, field := range []string{"whiteElo", "blackElo", "whiteRatingDiff", "blackRatingDiff", "moves_count", "plyCount", "maxImbalance", "board"} {
+		types[field] = map[string]any{"type": "integer"}
+	}
+	if nestedMoves {
+		types["moves"] = map[string]any{"type": "nested", "properties": map[string]any{
+			"ply": map[string]any{"type": "integer"}, "san": keyword, "uci": keyword,
+			"clock": map[string]any{"type": "float"}, "eval": map[string]any{"type": "float"}, "comment": map[string]any{"type": "text"},
+		}}
+	}
+
+	properties := make(map[string]any, len(types))
+	for field, mapping := range types {
+		properties[fields.Name(field)] = mapping
+	}
+	return map[string]any{"properties": properties}
+}
+
+// WriteBatch indexes the batch with one bulk request. Games already indexed
+// are duplicates, or replaced with --upsert.
+func (s *store) WriteBatch(ctx context.Context, batch []sink.Record) (map[int]error, error) {
+	docs := make([]elastic.Doc, len(batch))
+	for i, r := range batch {
+		source, err := jsondoc.Marshal(r.Doc)
+		if err != nil {
+			return nil, err
+		}
+		// Copies of a game share their content hash
+		id := r.Game.GameId()
+		if s.dedupeContent {
+			id = r.Game.ContentHash
+		}
+		docs[i] = elastic.Doc{ID: id, Source: source}
+	}
+
+	errs, err := s.client.Bulk(ctx, docs, s.upsert)
+	if err != nil {
+		return nil, err
+	}
+	failed := make(map[int]error)
+	for i, err := range errs {
+		if errors.Is(err, elastic.ErrConflict) {
+			err = sink.ErrDuplicate
+		}
+		if err != nil {
+			failed[i] = err
+		}
+	}
+	return failed, nil
+}
+
+func (s *store) Close() error {
+	return nil
+}
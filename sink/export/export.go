@@ -0,0 +1,196 @@
+// Package export writes a COPY file per games table of the Postgres
+// importer and the SQL loading them instead of importing (--export-dir), so
+// DBAs review the data first and load it with their own tooling
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"importGames/eco"
+	"importGames/pgcopy"
+	"importGames/pgmigrate"
+	"importGames/sink"
+)
+
+type exporter struct {
+	dir    string
+	format string // "csv" or "tsv"
+
+	mu     sync.Mutex
+	schema *os.File // schema.sql: shared tables, games tables, partitions
+	load   *os.File // load.sql, the psql script loading everything
+	tables map[string]*table
+}
+
+type table struct {
+	sink.Table
+	mu     sync.Mutex
+	file   *os.File
+	writer *pgcopy.Writer
+}
+
+func init() {
+	sink.Register("export", &exporter{})
+}
+
+// Open creates the directory, schema.sql with the shared tables and
+// load.sql
+func (e *exporter) Open(ctx context.Context, opts sink.Options) error {
+	if opts.ExportFormat != "csv" && opts.ExportFormat != "tsv" {
+		return fmt.Errorf("unknown export format %q", opts.ExportFormat)
+	}
+	migrations, err := pgmigrate.List()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(opts.ExportDir, 0o755); err != nil {
+		return err
+	}
+
+	e.dir, e.format, e.tables = opts.ExportDir, opts.ExportFormat, make(map[string]*table)
+	if e.schema, err = os.Create(filepath.Join(e.dir, "schema.sql")); err != nil {
+		return err
+	}
+	if e.load, err = os.Create(filepath.Join(e.dir, "load.sql")); err != nil {
+		e.schema.Close()
+		return err
+	}
+
+	// The migrations are idempotent, the importer applies them again later
+	var schema strings.Builder
+	for _, m := range migrations {
+		fmt.Fprintf(&schema, "-- %s\n%s\n", m.Name, strings.TrimSpace(m.SQL))
+	}
+	var codes []string
+	for _, c := range eco.Codes() {
+		codes = append(codes, fmt.Sprintf("(%s, %s)", quoteLiteral(c.Code), quoteLiteral(c.Name)))
+	}
+	fmt.Fprintf(&schema, "INSERT INTO eco_codes (code, name) VALUES\n%s\nON CONFLICT (code) DO NOTHING;\n", strings.Join(codes, ",\n"))
+	if _, err := e.schema.WriteString(schema.String()); err != nil {
+		return err
+	}
+	_, err = e.load.WriteString("\\set ON_ERROR_STOP on\n\\ir schema.sql\n")
+	return err
+}
+
+// EnsureSchema creates the file of a games table and adds the table to the
+// SQL, the first time
+func (e *exporter) EnsureSchema(ctx context.Context, t sink.Table) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.tables[t.Name] != nil {
+		return nil
+	}
+
+	format := "csv"
+	if e.format == "tsv" {
+		format = "text"
+	}
+	name := unquoted(t.Name) + "." + e.format
+	file, err := os.Create(filepath.Join(e.dir, name))
+	if err != nil {
+		return err
+	}
+	writer, err := pgcopy.NewWriter(file, format)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	names := make([]string, len(t.Columns))
+	for i, c := range t.Columns {
+		names[i] = c.Name
+	}
+	if err := writer.Header(names); err != nil {
+		file.Close()
+		return err
+	}
+
+	if _, err := e.schema.WriteString(t.Create + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := fmt.Fprintf(e.load, "\\copy %s (%s) FROM %s WITH (%s)\n", t.Name, strings.Join(names, ", "), quoteLiteral(name), pgcopy.Options(format)); err != nil {
+		file.Close()
+		return err
+	}
+	e.tables[t.Name] = &table{Table: t, file: file, writer: writer}
+	return nil
+}
+
+// EnsurePartition adds the statement creating a partition to schema.sql
+func (e *exporter) EnsurePartition(ctx context.Context, statement string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.schema.WriteString(statement + ";\n")
+	return err
+}
+
+// WriteBatch appends the games to the file of their table. Exported games
+// count as stored, the DBA loads them.
+func (e *exporter) WriteBatch(ctx context.Context, batch []sink.Record) (map[int]error, error) {
+	e.mu.Lock()
+	t := e.tables[batch[0].Table]
+	e.mu.Unlock()
+
+	types := make([]string, len(t.Columns))
+	for i, c := range t.Columns {
+		types[i] = c.Type
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, r := range batch {
+		if err := t.writer.Write(r.Row, types); err != nil {
+			return nil, err
+		}
+	}
+	return nil, t.writer.Flush()
+}
+
+// Close finishes the files, with indexes.sql run after the load when the
+// tables have indexes (--ensure-indexes)
+func (e *exporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var errs []error
+	var indexes strings.Builder
+	names := make([]string, 0, len(e.tables))
+	for name := range e.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		errs = append(errs, e.tables[name].file.Close())
+		for _, statement := range e.tables[name].Indexes {
+			indexes.WriteString(statement + ";\n")
+		}
+	}
+	if indexes.Len() > 0 {
+		errs = append(errs, os.WriteFile(filepath.Join(e.dir, "indexes.sql"), []byte(indexes.String()), 0o644))
+		_, err := e.load.WriteString("\\ir indexes.sql\n")
+		errs = append(errs, err)
+	}
+	errs = append(errs, e.schema.Close(), e.load.Close())
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d tables to %s, load them with: cd %s && psql -f load.sql\n", len(names), e.dir, e.dir)
+	return nil
+}
+
+// quoteLiteral quotes a SQL string
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// unquoted returns the name of a table quoted by the importer
+func unquoted(tableName string) string {
+	return strings.ReplaceAll(strings.Trim(tableName, "\""), "\"\"", "\"")
+}
@@ -0,0 +1,104 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Fanout is a sink writing every batch to several sinks at once
+type Fanout struct {
+	names []string
+	sinks []Sink
+}
+
+// Add adds a destination, named in its errors
+func (f *Fanout) Add(name string, s Sink) {
+	f.names, f.sinks = append(f.names, name), append(f.sinks, s)
+}
+
+// Open opens every destination, closing those opened when one fails
+func (f *Fanout) Open(ctx context.Context, opts Options) error {
+	for i, s := range f.sinks {
+		if err := s.Open(ctx, opts); err != nil {
+			for _, opened := range f.sinks[:i] {
+				opened.Close()
+			}
+			return fmt.Errorf("%s: %w", f.names[i], err)
+		}
+	}
+	return nil
+}
+
+// EnsureSchema creates the table in every destination
+func (f *Fanout) EnsureSchema(ctx context.Context, table Table) error {
+	for i, s := range f.sinks {
+		if err := s.EnsureSchema(ctx, table); err != nil {
+			return fmt.Errorf("%s: %w", f.names[i], err)
+		}
+	}
+	return nil
+}
+
+// EnsurePartition creates the partition in the destinations partitioning
+// their tables
+func (f *Fanout) EnsurePartition(ctx context.Context, statement string) error {
+	for i, s := range f.sinks {
+		if p, ok := s.(Partitioner); ok {
+			if err := p.EnsurePartition(ctx, statement); err != nil {
+				return fmt.Errorf("%s: %w", f.names[i], err)
+			}
+		}
+	}
+	return nil
+}
+
+// WriteBatch returns the first failure of every game, a real one rather
+// than a duplicate (ErrDuplicate), named after its destination. A
+// destination failing the whole batch fails each game, unless it's the
+// only one.
+func (f *Fanout) WriteBatch(ctx context.Context, batch []Record) (map[int]error, error) {
+	if len(f.sinks) == 1 {
+		return f.sinks[0].WriteBatch(ctx, batch)
+	}
+
+	results := make([]map[int]error, len(f.sinks))
+	errs := make([]error, len(f.sinks))
+	var wg sync.WaitGroup
+	for i, s := range f.sinks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = s.WriteBatch(ctx, batch)
+		}()
+	}
+	wg.Wait()
+
+	failed := make(map[int]error)
+	for i, name := range f.names {
+		if errs[i] != nil {
+			results[i] = make(map[int]error, len(batch))
+			for j := range batch {
+				results[i][j] = errs[i]
+			}
+		}
+		for j, err := range results[i] {
+			if previous, ok := failed[j]; !ok || errors.Is(previous, ErrDuplicate) && !errors.Is(err, ErrDuplicate) {
+				failed[j] = fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return failed, nil
+}
+
+// Close closes every destination, even when one fails
+func (f *Fanout) Close() error {
+	var errs []error
+	for i, s := range f.sinks {
+		if err := s.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.names[i], err))
+		}
+	}
+	return errors.Join(errs...)
+}
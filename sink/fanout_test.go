@@ -0,0 +1,152 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// fake fails the games of failed, or the whole batch with err
+type fake struct {
+	failed     map[int]error
+	err        error
+	openErr    error
+	closeErr   error
+	opened     bool
+	closed     bool
+	partitions []string
+}
+
+func (s *fake) Open(ctx context.Context, opts Options) error {
+	s.opened = true
+	return s.openErr
+}
+
+func (s *fake) EnsureSchema(ctx context.Context, table Table) error {
+	return nil
+}
+
+func (s *fake) WriteBatch(ctx context.Context, batch []Record) (map[int]error, error) {
+	return s.failed, s.err
+}
+
+func (s *fake) Close() error {
+	s.closed = true
+	return s.closeErr
+}
+
+func TestWriteBatch(t *testing.T) {
+	broken := errors.New("broken")
+	down := errors.New("down")
+
+	tests := []struct {
+		name  string
+		sinks map[string]*fake
+		want  map[int]string // the error of every failed game
+		err   bool
+	}{
+		{"all stored", map[string]*fake{"a": {}, "b": {}}, map[int]string{}, false},
+		{"one sink failing the batch", map[string]*fake{"a": {err: down}}, nil, true},
+		{"one sink failing games", map[string]*fake{"a": {failed: map[int]error{1: broken}}}, map[int]string{1: "broken"}, false},
+		{"failures of both", map[string]*fake{"a": {failed: map[int]error{0: broken}}, "b": {failed: map[int]error{2: broken}}},
+			map[int]string{0: "a: broken", 2: "b: broken"}, false},
+		{"failure over a duplicate", map[string]*fake{"a": {failed: map[int]error{1: ErrDuplicate}}, "b": {failed: map[int]error{1: broken}}},
+			map[int]string{1: "b: broken"}, false},
+		{"first failure kept", map[string]*fake{"a": {failed: map[int]error{1: broken}}, "b": {failed: map[int]error{1: ErrDuplicate}}},
+			map[int]string{1: "a: broken"}, false},
+		{"duplicate in both", map[string]*fake{"a": {failed: map[int]error{0: ErrDuplicate}}, "b": {failed: map[int]error{0: ErrDuplicate}}},
+			map[int]string{0: "a: duplicate game"}, false},
+		{"a sink failing the batch", map[string]*fake{"a": {failed: map[int]error{0: ErrDuplicate}}, "b": {err: down}},
+			map[int]string{0: "b: down", 1: "b: down", 2: "b: down"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &Fanout{}
+			for _, name := range []string{"a", "b"} {
+				if s, ok := tt.sinks[name]; ok {
+					f.Add(name, s)
+				}
+			}
+
+			failed, err := f.WriteBatch(context.Background(), make([]Record, 3))
+			if (err != nil) != tt.err {
+				t.Fatalf("WriteBatch error = %v, want error: %v", err, tt.err)
+			}
+			if tt.err {
+				return
+			}
+			got := make(map[int]string)
+			for i, err := range failed {
+				got[i] = err.Error()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("WriteBatch failed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDuplicateStaysDuplicate(t *testing.T) {
+	f := &Fanout{}
+	f.Add("a", &fake{})
+	f.Add("b", &fake{failed: map[int]error{0: ErrDuplicate}})
+
+	failed, err := f.WriteBatch(context.Background(), make([]Record, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(failed[0], ErrDuplicate) {
+		t.Errorf("WriteBatch failed %v, want a duplicate", failed[0])
+	}
+}
+
+// partitioned is a fake with partitioned tables
+type partitioned struct{ fake }
+
+func (s *partitioned) EnsurePartition(ctx context.Context, statement string) error {
+	s.partitions = append(s.partitions, statement)
+	return nil
+}
+
+func TestEnsurePartition(t *testing.T) {
+	plain, tables := &fake{}, &partitioned{}
+	f := &Fanout{}
+	f.Add("plain", plain)
+	f.Add("tables", tables)
+
+	if err := f.EnsurePartition(context.Background(), "CREATE TABLE games_2024_01"); err != nil {
+		t.Fatal(err)
+	}
+	if len(tables.partitions) != 1 || plain.partitions != nil {
+		t.Errorf("EnsurePartition reached %v and %v, want the partitioned destination only", tables.partitions, plain.partitions)
+	}
+}
+
+func TestOpenAndClose(t *testing.T) {
+	a, b, c := &fake{}, &fake{openErr: errors.New("refused")}, &fake{}
+	f := &Fanout{}
+	f.Add("a", a)
+	f.Add("b", b)
+	f.Add("c", c)
+	err := f.Open(context.Background(), Options{})
+	if err == nil || err.Error() != "b: refused" {
+		t.Errorf("Open = %v, want b: refused", err)
+	}
+	if !a.closed || c.opened {
+		t.Error("Open didn't close the opened destinations or opened the ones after the failing one")
+	}
+
+	a, b = &fake{closeErr: errors.New("disk full")}, &fake{}
+	f = &Fanout{}
+	f.Add("a", a)
+	f.Add("b", b)
+	err = f.Close()
+	if err == nil || err.Error() != "a: disk full" {
+		t.Errorf("Close = %v, want a: disk full", err)
+	}
+	if !a.closed || !b.closed {
+		t.Error("Close stopped at the failing destination")
+	}
+}
@@ -0,0 +1,80 @@
+// Package mongodb inserts the games of the MongoDB importer into their
+// collections (--backend=mongodb), over the importer's client
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"importGames/sink"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type store struct {
+	db     *mongo.Database
+	upsert bool
+}
+
+func init() {
+	sink.Register("mongodb", &store{})
+}
+
+func (s *store) Open(ctx context.Context, opts sink.Options) error {
+	if opts.Mongo == nil {
+		return fmt.Errorf("no MongoDB connection")
+	}
+	s.db, s.upsert = opts.Mongo, opts.Upsert
+	return nil
+}
+
+// EnsureSchema has nothing to do, the importer prepares its collections
+// with their validation, indexes and sharding
+func (s *store) EnsureSchema(ctx context.Context, table sink.Table) error {
+	return nil
+}
+
+// WriteBatch writes games of one collection with an unordered bulk write,
+// so the server tries every game and a failing one doesn't stop the others.
+// Upserts replace the game matching the record's Key.
+func (s *store) WriteBatch(ctx context.Context, batch []sink.Record) (map[int]error, error) {
+	models := make([]mongo.WriteModel, len(batch))
+	for i, r := range batch {
+		if s.upsert {
+			models[i] = mongo.NewReplaceOneModel().
+				SetFilter(r.Key).
+				SetReplacement(r.Doc).
+				SetUpsert(true)
+			continue
+		}
+		models[i] = mongo.NewInsertOneModel().SetDocument(r.Doc)
+	}
+	_, err := s.db.Collection(batch[0].Table).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+
+	var bulkErr mongo.BulkWriteException
+	switch {
+	case err == nil || errors.Is(err, mongo.ErrUnacknowledgedWrite): // --write-concern=0
+		return nil, nil
+	case !errors.As(err, &bulkErr):
+		return nil, err
+	}
+	failures := make(map[int]error)
+	for _, writeErr := range bulkErr.WriteErrors {
+		failures[writeErr.Index] = writeErr.WriteError
+		if mongo.IsDuplicateKeyError(writeErr.WriteError) {
+			failures[writeErr.Index] = sink.ErrDuplicate
+		}
+	}
+	// The games were written but not acknowledged as asked
+	if bulkErr.WriteConcernError != nil {
+		fmt.Printf("Write concern error for a batch of %d games: %s\n", len(batch), bulkErr.WriteConcernError)
+	}
+	return failures, nil
+}
+
+// Close leaves the client to the importer
+func (s *store) Close() error {
+	return nil
+}
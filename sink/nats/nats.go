@@ -0,0 +1,144 @@
+// Package nats publishes the games of the MongoDB importer to a JetStream
+// stream (--backend=nats), on a subject made of the game (--nats-subject),
+// so consumers subscribe to games.blitz.> or games.*.chess960 only
+package nats
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"importGames/env"
+	"importGames/jsondoc"
+	"importGames/pgnparse"
+	"importGames/sink"
+
+	"github.com/nats-io/nats.go"
+)
+
+// duplicates is how long JetStream remembers published game ids
+const duplicates = 24 * time.Hour
+
+type store struct {
+	url, stream, subject string
+
+	conn *nats.Conn
+	js   nats.JetStreamContext
+}
+
+func init() {
+	sink.Register("nats", &store{})
+}
+
+func (s *store) RegisterFlags() {
+	flag.StringVar(&s.url, "nats-url", env.String("NATS_URL", nats.DefaultURL), "NATS server of --backend=nats")
+	flag.StringVar(&s.stream, "nats-stream", env.String("NATS_STREAM", "GAMES"), "JetStream stream receiving the games, created if needed")
+	flag.StringVar(&s.subject, "nats-subject", env.String("NATS_SUBJECT", "games.{speed}.{variant}"), "subject of every game, with {speed}, {variant}, {eco}, {source} and {time_control} replaced")
+}
+
+func (s *store) Open(ctx context.Context, opts sink.Options) error {
+	options := []nats.Option{nats.Name("importPGN")}
+	if creds := os.Getenv("NATS_CREDS"); creds != "" {
+		options = append(options, nats.UserCredentials(creds))
+	}
+	conn, err := nats.Connect(s.url, options...)
+	if err != nil {
+		return err
+	}
+	// Every worker can have a whole batch in flight
+	js, err := conn.JetStream(nats.PublishAsyncMaxPending(opts.Workers * opts.BatchSize))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	// The stream takes every subject of the template, games.*.*
+	_, err = js.StreamInfo(s.stream)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		tokens := strings.Split(s.subject, ".")
+		for i, token := range tokens {
+			if strings.Contains(token, "{") {
+				tokens[i] = "*"
+			}
+		}
+		_, err = js.AddStream(&nats.StreamConfig{Name: s.stream, Subjects: []string{strings.Join(tokens, ".")}, Duplicates: duplicates})
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	s.conn, s.js = conn, js
+	return nil
+}
+
+func (s *store) EnsureSchema(ctx context.Context, table sink.Table) error {
+	return nil
+}
+
+// subjectToken makes a value one subject token: no dots, spaces or
+// wildcards, "unknown" when empty
+var subjectToken = strings.NewReplacer(".", "_", " ", "_", "\t", "_", "*", "_", ">", "_")
+
+// subject fills the placeholders of the --nats-subject template with the game
+func subject(template string, game *sink.Game) string {
+	value := func(s string) string {
+		if s == "" {
+			return "unknown"
+		}
+		return subjectToken.Replace(strings.ToLower(s))
+	}
+	return strings.NewReplacer(
+		"{speed}", value(pgnparse.Speed(game.TimeControl)),
+		"{variant}", value(game.Variant),
+		"{eco}", value(game.Eco),
+		"{source}", value(game.Source),
+		"{time_control}", value(game.TimeControl),
+	).Replace(template)
+}
+
+// WriteBatch publishes the batch asynchronously and waits for the acks. The game
+// id is the message id, so JetStream drops games published again within
+// duplicates.
+func (s *store) WriteBatch(ctx context.Context, batch []sink.Record) (map[int]error, error) {
+	failed := make(map[int]error)
+	futures := make(map[int]nats.PubAckFuture)
+	for i, r := range batch {
+		data, err := jsondoc.Marshal(r.Doc)
+		if err != nil {
+			failed[i] = err
+			continue
+		}
+		future, err := s.js.PublishAsync(subject(s.subject, r.Game), data, nats.MsgId(r.Game.GameId()))
+		if err != nil {
+			failed[i] = err
+			continue
+		}
+		futures[i] = future
+	}
+
+	// Wait for this batch's acks only, the JetStream context is shared by
+	// all workers
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	for i, future := range futures {
+		select {
+		case ack := <-future.Ok():
+			if ack.Duplicate {
+				failed[i] = sink.ErrDuplicate
+			}
+		case err := <-future.Err():
+			failed[i] = err
+		case <-ctx.Done():
+			failed[i] = fmt.Errorf("no ack from JetStream within a minute")
+		}
+	}
+	return failed, nil
+}
+
+func (s *store) Close() error {
+	return s.conn.Drain()
+}
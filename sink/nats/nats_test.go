@@ -0,0 +1,29 @@
+package nats
+
+import (
+	"testing"
+
+	"importGames/sink"
+)
+
+func TestSubject(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		game     sink.Game
+		want     string
+	}{
+		{"speed and variant", "games.{speed}.{variant}", sink.Game{TimeControl: "180+2", Variant: "Standard"}, "games.blitz.standard"},
+		{"unknown values", "games.{eco}.{variant}", sink.Game{}, "games.unknown.unknown"},
+		{"dots and wildcards", "games.{source}.{variant}", sink.Game{Source: "lichess", Variant: "From Position.*"}, "games.lichess.from_position__"},
+		{"fixed subject", "games", sink.Game{Source: "lichess"}, "games"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := subject(tt.template, &tt.game); got != tt.want {
+				t.Errorf("subject = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+// Package ndjson writes a JSON document per line (--backend=ndjson), the
+// MongoDB document of every game, for jq or Spark. Runs append to the file,
+// compressed files too: concatenated zstd or gzip streams read as one.
+package ndjson
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"importGames/env"
+	"importGames/jsondoc"
+	"importGames/sink"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type store struct {
+	path string
+
+	mu         sync.Mutex
+	file       *os.File
+	compressor io.WriteCloser // nil when not compressed
+	w          *bufio.Writer
+}
+
+func init() {
+	sink.Register("ndjson", &store{})
+}
+
+func (s *store) RegisterFlags() {
+	flag.StringVar(&s.path, "out", env.String("OUT", "games.ndjson.zst"), "file of --backend=ndjson, zstd or gzip compressed when named .zst or .gz")
+}
+
+// Open opens the file, zstd compressed when named .zst and gzip when .gz
+func (s *store) Open(ctx context.Context, opts sink.Options) error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	switch {
+	case strings.HasSuffix(s.path, ".zst"):
+		if s.compressor, err = zstd.NewWriter(file); err != nil {
+			file.Close()
+			return err
+		}
+	case strings.HasSuffix(s.path, ".gz"):
+		s.compressor = gzip.NewWriter(file)
+	}
+	if s.compressor != nil {
+		s.w = bufio.NewWriterSize(s.compressor, 1<<20)
+	} else {
+		s.w = bufio.NewWriterSize(file, 1<<20)
+	}
+	return nil
+}
+
+func (s *store) EnsureSchema(ctx context.Context, table sink.Table) error {
+	return nil
+}
+
+// WriteBatch appends the games. Nothing finds games written before, so a file
+// imported twice is there twice.
+func (s *store) WriteBatch(ctx context.Context, batch []sink.Record) (map[int]error, error) {
+	lines := make([][]byte, len(batch))
+	failed := make(map[int]error)
+	for i, r := range batch {
+		line, err := jsondoc.Marshal(r.Doc)
+		if err != nil {
+			failed[i] = err
+			continue
+		}
+		lines[i] = line
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, line := range lines {
+		if line == nil {
+			continue
+		}
+		s.w.Write(line)
+		if err := s.w.WriteByte('\n'); err != nil {
+			return nil, err
+		}
+	}
+	return failed, nil
+}
+
+func (s *store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	errs := []error{s.w.Flush()}
+	if s.compressor != nil {
+		errs = append(errs, s.compressor.Close())
+	}
+	errs = append(errs, s.file.Close())
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	fmt.Println("Games written to", s.file.Name())
+	return nil
+}
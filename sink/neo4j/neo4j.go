@@ -0,0 +1,143 @@
+// Package neo4j writes the games of the MongoDB importer as a graph
+// (--backend=neo4j): players are Player nodes and every game a PLAYED
+// relationship from white to black, for opponent networks and shortest
+// paths between players
+package neo4j
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"strings"
+
+	"importGames/env"
+	"importGames/sink"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// errNoPlayers is the failure of a game missing a player name, which has
+// no relationship to make
+var errNoPlayers = errors.New("no White or Black player name")
+
+type store struct {
+	url, database string
+
+	driver neo4j.DriverWithContext
+}
+
+func init() {
+	sink.Register("neo4j", &store{})
+}
+
+func (s *store) RegisterFlags() {
+	flag.StringVar(&s.url, "neo4j-url", env.String("NEO4J_URL", "neo4j://127.0.0.1:7687"), "Neo4j server of --backend=neo4j")
+	flag.StringVar(&s.database, "neo4j-database", env.String("NEO4J_DATABASE", "neo4j"), "Neo4j database receiving the players and games")
+}
+
+func (s *store) Open(ctx context.Context, opts sink.Options) error {
+	driver, err := neo4j.NewDriverWithContext(s.url, neo4j.BasicAuth(os.Getenv("NEO4J_USERNAME"), os.Getenv("NEO4J_PASSWORD"), ""))
+	if err != nil {
+		return err
+	}
+	if err := driver.VerifyConnectivity(ctx); err != nil {
+		driver.Close(ctx)
+		return err
+	}
+	s.driver = driver
+	return nil
+}
+
+// EnsureSchema makes players unique by key and indexes the games by id, so
+// the MERGEs of WriteBatch don't scan
+func (s *store) EnsureSchema(ctx context.Context, table sink.Table) error {
+	for _, statement := range []string{
+		"CREATE CONSTRAINT player_key IF NOT EXISTS FOR (p:Player) REQUIRE p.key IS UNIQUE",
+		"CREATE INDEX played_game_id IF NOT EXISTS FOR ()-[g:PLAYED]-() ON (g.gameId)",
+		"CREATE INDEX played_eco IF NOT EXISTS FOR ()-[g:PLAYED]-() ON (g.eco)",
+	} {
+		if _, err := neo4j.ExecuteQuery(ctx, s.driver, statement, nil, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase(s.database)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write merges the players and games of a batch. A game imported again
+// updates its relationship.
+const write = `UNWIND $games AS g
+MERGE (w:Player {key: g.white.key}) ON CREATE SET w.name = g.white.name, w.source = g.source
+MERGE (b:Player {key: g.black.key}) ON CREATE SET b.name = g.black.name, b.source = g.source
+MERGE (w)-[p:PLAYED {gameId: g.gameId}]->(b)
+SET p += g.properties`
+
+// player identifies a player by source and lowercased name, Lichess and
+// Chess.com names being case-insensitive
+func player(source string, name string) map[string]any {
+	return map[string]any{"key": source + ":" + strings.ToLower(name), "name": name}
+}
+
+// named tells whether the tag names a player, "?" being PGN for unknown
+func named(name string) bool {
+	return name != "" && name != "?"
+}
+
+// properties are the properties of the PLAYED relationship of a game
+func properties(game *sink.Game) map[string]any {
+	p := map[string]any{
+		"eco":         game.Eco,
+		"opening":     game.Opening,
+		"result":      game.Result,
+		"timeControl": game.TimeControl,
+		"variant":     game.Variant,
+		"event":       game.Event,
+		"plyCount":    game.PlyCount,
+	}
+	if game.WhiteElo != nil {
+		p["whiteElo"] = *game.WhiteElo
+	}
+	if game.BlackElo != nil {
+		p["blackElo"] = *game.BlackElo
+	}
+	if game.Date != nil {
+		p["date"] = neo4j.DateOf(*game.Date)
+		if game.Time != "" && game.PlayedAt != nil {
+			p["playedAt"] = game.PlayedAt.UTC()
+		}
+	}
+	return p
+}
+
+// WriteBatch writes the batch in one transaction, which the driver retries
+// when batches writing the same players deadlock. Games without both
+// player names fail, they have no relationship to make.
+func (s *store) WriteBatch(ctx context.Context, batch []sink.Record) (map[int]error, error) {
+	failed := make(map[int]error)
+	var games []map[string]any
+	for i, r := range batch {
+		game := r.Game
+		if !named(game.White) || !named(game.Black) {
+			failed[i] = errNoPlayers
+			continue
+		}
+		games = append(games, map[string]any{
+			"gameId":     game.GameId(),
+			"source":     game.Source,
+			"white":      player(game.Source, game.White),
+			"black":      player(game.Source, game.Black),
+			"properties": properties(game),
+		})
+	}
+	if len(games) == 0 {
+		return failed, nil
+	}
+	if _, err := neo4j.ExecuteQuery(ctx, s.driver, write, map[string]any{"games": games}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase(s.database)); err != nil {
+		return nil, err
+	}
+	return failed, nil
+}
+
+func (s *store) Close() error {
+	return s.driver.Close(context.Background())
+}
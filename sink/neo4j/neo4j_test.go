@@ -0,0 +1,25 @@
+package neo4j
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"importGames/sink"
+)
+
+func TestWriteBatchWithoutPlayers(t *testing.T) {
+	batch := []sink.Record{
+		{Game: &sink.Game{White: "?", Black: "DrNykterstein"}},
+		{Game: &sink.Game{White: "DrNykterstein"}},
+	}
+	failed, err := (&store{}).WriteBatch(context.Background(), batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range batch {
+		if !errors.Is(failed[i], errNoPlayers) {
+			t.Errorf("game %d failed with %v, want %v", i, failed[i], errNoPlayers)
+		}
+	}
+}
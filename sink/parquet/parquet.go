@@ -0,0 +1,114 @@
+// Package parquet writes every table or collection as Hive partitioned
+// Parquet files (--backend=parquet), partitioned by month or ECO code, with
+// the columns of the Postgres importer's games table whichever importer
+// runs. --duckdb-file loads them into a DuckDB database at the end.
+package parquet
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"importGames/env"
+	"importGames/parquetdir"
+	"importGames/sink"
+)
+
+type store struct {
+	path        string
+	partitionBy string // "month" or "eco"
+	duckdbFile  string // built from the files with the duckdb CLI
+
+	dir *parquetdir.Dir
+}
+
+func init() {
+	sink.Register("parquet", &store{})
+}
+
+func (s *store) RegisterFlags() {
+	flag.StringVar(&s.path, "parquet-dir", env.String("PARQUET_DIR", "parquet"), "directory receiving the Parquet files of --backend=parquet")
+	flag.StringVar(&s.partitionBy, "parquet-partition-by", env.String("PARQUET_PARTITION_BY", "month"), "Parquet files per month played or per eco code")
+	flag.StringVar(&s.duckdbFile, "duckdb-file", env.String("DUCKDB_FILE", ""), "also load the Parquet files into this DuckDB database, with the duckdb command")
+}
+
+func (s *store) Open(ctx context.Context, opts sink.Options) error {
+	// Files are named after the run, so runs add files
+	dir, err := parquetdir.Open(s.path, s.partitionBy, "import_"+opts.ImportId)
+	if err != nil {
+		return err
+	}
+	s.dir = dir
+	return nil
+}
+
+// EnsureSchema has nothing to create, the files are created with their
+// first game
+func (s *store) EnsureSchema(ctx context.Context, table sink.Table) error {
+	return nil
+}
+
+// WriteBatch appends the games to the files of their partitions, duplicates
+// are left to the queries
+func (s *store) WriteBatch(ctx context.Context, batch []sink.Record) (map[int]error, error) {
+	rows := make([]parquetdir.Row, len(batch))
+	for i, r := range batch {
+		rows[i] = row(r.Game)
+	}
+	return nil, s.dir.Write(unquoted(batch[0].Table), rows)
+}
+
+// Close writes the file footers and builds --duckdb-file
+func (s *store) Close() error {
+	if err := s.dir.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d tables to %s\n", len(s.dir.Tables()), s.path)
+	if s.duckdbFile == "" {
+		return nil
+	}
+	if err := s.dir.LoadDuckDB(s.duckdbFile); err != nil {
+		return err
+	}
+	fmt.Println("Loaded them into", s.duckdbFile)
+	return nil
+}
+
+// unquoted is the name of a table as the Postgres importer quotes it
+func unquoted(table string) string {
+	return strings.ReplaceAll(strings.Trim(table, `"`), `""`, `"`)
+}
+
+// row converts a game to a row of the Parquet files
+func row(g *sink.Game) parquetdir.Row {
+	optional := func(s string) *string {
+		if s == "" {
+			return nil
+		}
+		return &s
+	}
+	int32Of := func(n *int) *int32 {
+		if n == nil {
+			return nil
+		}
+		v := int32(*n)
+		return &v
+	}
+	row := parquetdir.Row{
+		GameId: g.GameId(), Source: g.Source, SourceId: g.SourceId,
+		SourceFile: optional(g.SourceFile), SourceOffset: g.SourceOffset, ImportJobId: optional(g.ImportId),
+		Event: g.Event, TournamentId: optional(g.TournamentId), EventDate: g.EventDate, Round: g.Round,
+		Date: g.Date, DateYear: int32Of(g.Year), DateMonth: int32Of(g.Month), DateDay: int32Of(g.Day), Time: optional(g.Time), PlayedAt: g.PlayedAt,
+		White: g.White, Black: g.Black, WhiteElo: int32Of(g.WhiteElo), BlackElo: int32Of(g.BlackElo),
+		WhiteTitle: g.WhiteTitle, BlackTitle: g.BlackTitle, WhiteRatingDiff: int32Of(g.WhiteRatingDiff), BlackRatingDiff: int32Of(g.BlackRatingDiff),
+		Result: g.Result, Termination: g.Termination, TimeControl: g.TimeControl, Variant: g.Variant, Opening: g.Opening,
+		MovesCount: int32(g.MovesCount), PlyCount: int32(g.PlyCount), Moves: g.Moves, UciMoves: g.UciMoves, Evals: g.Evals, Clocks: g.Clocks,
+		Analyzed: g.Analyzed, FinalFen: optional(g.FinalFen), MaxImbalance: int32(g.MaxImbalance),
+		MovesHash: g.MovesHash, ContentHash: g.ContentHash, Features: g.Features, Tags: g.Tags, Eco: parquetdir.Eco(g.Eco),
+	}
+	if g.Source == "lichess" {
+		row.LichessId = &g.SourceId
+	}
+	return row
+}
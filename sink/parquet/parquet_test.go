@@ -0,0 +1,54 @@
+package parquet
+
+import (
+	"testing"
+
+	"importGames/sink"
+)
+
+func TestRow(t *testing.T) {
+	tests := []struct {
+		name      string
+		game      sink.Game
+		eco       string // "" for NULL
+		lichessId string
+		time      string
+	}{
+		{"lichess", sink.Game{Source: "lichess", SourceId: "abcd1234", Eco: "B90", Time: "12:00:00"}, "B90", "abcd1234", "12:00:00"},
+		{"unknown eco", sink.Game{Source: "hash", SourceId: "f00", Eco: "?"}, "", "", ""},
+		{"made up eco", sink.Game{Source: "chesscom", SourceId: "1", Eco: "Z99"}, "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := row(&tt.game)
+			if r.GameId != tt.game.Source+":"+tt.game.SourceId {
+				t.Errorf("GameId = %q", r.GameId)
+			}
+			if got := deref(r.Eco); got != tt.eco {
+				t.Errorf("Eco = %q, want %q", got, tt.eco)
+			}
+			if got := deref(r.LichessId); got != tt.lichessId {
+				t.Errorf("LichessId = %q, want %q", got, tt.lichessId)
+			}
+			if got := deref(r.Time); got != tt.time {
+				t.Errorf("Time = %q, want %q", got, tt.time)
+			}
+		})
+	}
+}
+
+func TestUnquoted(t *testing.T) {
+	for table, want := range map[string]string{"games": "games", `"games_2024"`: "games_2024", `"a""b"`: `a"b`} {
+		if got := unquoted(table); got != want {
+			t.Errorf("unquoted(%q) = %q, want %q", table, got, want)
+		}
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
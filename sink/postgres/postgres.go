@@ -0,0 +1,415 @@
+// Package postgres writes the games of the Postgres importer into Postgres
+// (--backend=postgres) over the importer's pool, connected and migrated
+// before the import. The importer writes the SQL of the tables, the
+// package runs it.
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"importGames/sink"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type store struct {
+	pool *pgxpool.Pool
+	opts sink.Options
+
+	mu     sync.Mutex
+	tables map[string]sink.Table
+}
+
+func init() {
+	sink.Register("postgres", &store{})
+}
+
+func (s *store) Open(ctx context.Context, opts sink.Options) error {
+	if opts.Pool == nil {
+		return fmt.Errorf("no Postgres connection")
+	}
+	s.pool, s.opts, s.tables = opts.Pool, opts, make(map[string]sink.Table)
+	return nil
+}
+
+// EnsureSchema creates a games table
+func (s *store) EnsureSchema(ctx context.Context, table sink.Table) error {
+	if _, err := s.pool.Exec(ctx, table.Create); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.tables[table.Name] = table
+	s.mu.Unlock()
+	return nil
+}
+
+// EnsurePartition creates a partition
+func (s *store) EnsurePartition(ctx context.Context, statement string) error {
+	_, err := s.pool.Exec(ctx, statement)
+	return err
+}
+
+// batchTries is how many times a batch is written when Postgres aborts
+// its transaction for a deadlock or serialization failure
+const batchTries = 3
+
+// WriteBatch writes the batch in one transaction, tried again when
+// Postgres asks to
+func (s *store) WriteBatch(ctx context.Context, batch []sink.Record) (map[int]error, error) {
+	s.mu.Lock()
+	table := s.tables[batch[0].Table]
+	s.mu.Unlock()
+
+	for try := 1; ; try++ {
+		failures, err := s.write(ctx, table, batch)
+		if !Retryable(err) || try == batchTries {
+			return failures, err
+		}
+		fmt.Printf("Retrying a batch of %d games: %s\n", len(batch), err)
+		time.Sleep(time.Duration(try) * 100 * time.Millisecond)
+	}
+}
+
+// Close leaves the pool to the importer
+func (s *store) Close() error {
+	return nil
+}
+
+// Retryable reports whether Postgres aborted the transaction for a
+// deadlock or serialization failure, which succeeds when run again
+func Retryable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == "40001" || pgErr.Code == "40P01")
+}
+
+// write stores the games in one transaction, so a crash never leaves half
+// a batch behind. Every game runs in a savepoint: a failing one is rolled
+// back alone and returned by index with the duplicates.
+func (s *store) write(ctx context.Context, table sink.Table, batch []sink.Record) (map[int]error, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	failures := make(map[int]error)
+	if s.opts.Positions == "" && !s.opts.Copy {
+		if err := insertGames(ctx, tx, table, batch, failures); err != nil {
+			return nil, err
+		}
+	} else if s.opts.Positions == "" {
+		// COPY is all or nothing and can't skip conflicts, so when it fails
+		// (usually because some games are already stored) the games are
+		// inserted with ON CONFLICT instead
+		copied, err := copyGames(ctx, tx, table, batch)
+		switch {
+		case err != nil:
+			return nil, err
+		case !copied:
+			if err := insertGames(ctx, tx, table, batch, failures); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		// Positions kept aside are written with their game, in the
+		// savepoint Store opens on the transaction
+		for i, r := range batch {
+			err := Store(ctx, tx, s.opts, table, r, false)
+			switch {
+			case Retryable(err):
+				return nil, err
+			case err != nil:
+				failures[i] = err
+			}
+		}
+	}
+
+	if s.opts.Notify != "" {
+		if err := notifyStored(ctx, tx, s.opts.Notify, table.Name, batch, failures); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return failures, nil
+}
+
+// notifyPayload stays under the 8000 bytes a notification can carry
+const notifyPayload = 7900
+
+// notifyStored sends the stored games, those not failed, on the channel
+// as {"table": ..., "games": [{"gameId", "white", "black", "eco"}, ...]}.
+// Postgres delivers the notifications when the transaction commits; large
+// batches take several.
+func notifyStored(ctx context.Context, tx pgx.Tx, channel string, tableName string, batch []sink.Record, failures map[int]error) error {
+	table, _ := json.Marshal(unquoted(tableName))
+	prefix := `{"table":` + string(table) + `,"games":[`
+
+	var payload strings.Builder
+	send := func() error {
+		if payload.Len() == 0 {
+			return nil
+		}
+		_, err := tx.Exec(ctx, "SELECT pg_notify($1, $2)", channel, prefix+payload.String()+"]}")
+		payload.Reset()
+		return err
+	}
+	for i, r := range batch {
+		if _, ok := failures[i]; ok {
+			continue
+		}
+		event, _ := json.Marshal(map[string]string{
+			"gameId": r.Game.GameId(),
+			"white":  r.Game.White,
+			"black":  r.Game.Black,
+			"eco":    r.Game.Eco,
+		})
+		if payload.Len() > 0 && len(prefix)+payload.Len()+len(event)+3 > notifyPayload {
+			if err := send(); err != nil {
+				return err
+			}
+		}
+		if payload.Len() > 0 {
+			payload.WriteByte(',')
+		}
+		payload.Write(event)
+	}
+	return send()
+}
+
+// copyGames loads the games with the COPY protocol in a savepoint, and
+// reports false when it was rolled back so the games must be inserted
+func copyGames(ctx context.Context, tx pgx.Tx, table sink.Table, batch []sink.Record) (bool, error) {
+	names := make([]string, len(table.Columns))
+	for i, c := range table.Columns {
+		names[i] = c.Name
+	}
+	rows := make([][]any, len(batch))
+	for i, r := range batch {
+		rows[i] = r.Row
+	}
+
+	savepoint, err := tx.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	_, err = savepoint.CopyFrom(ctx, pgx.Identifier{unquoted(table.Name)}, names, pgx.CopyFromRows(rows))
+	if err == nil {
+		return true, savepoint.Commit(ctx)
+	}
+	if err := savepoint.Rollback(ctx); err != nil {
+		return false, err
+	}
+
+	var pgErr *pgconn.PgError
+	if Retryable(err) {
+		return false, err
+	}
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" { // unique_violation
+		fmt.Printf("Failed to COPY %d games into %s, inserting them: %s\n", len(batch), table.Name, err)
+	}
+	return false, nil
+}
+
+// insertGames sends a prepared insert per game, each in its own savepoint,
+// all pipelined in one round trip, adding failed and duplicate games to
+// failures. When a game fails Postgres skips the rest of the pipeline: the
+// failing game is rolled back to its savepoint, keeping the games before
+// it, and the games after it are sent again.
+func insertGames(ctx context.Context, tx pgx.Tx, table sink.Table, batch []sink.Record, failures map[int]error) error {
+	// Parsed and planned once per connection instead of for every game
+	statement := "insert_" + unquoted(table.Name)
+	if _, err := tx.Conn().Prepare(ctx, statement, table.Insert); err != nil {
+		return err
+	}
+
+	for start := 0; start < len(batch); {
+		pipeline := &pgx.Batch{}
+		for _, r := range batch[start:] {
+			pipeline.Queue("SAVEPOINT game")
+			pipeline.Queue(statement, r.Row...)
+			pipeline.Queue("RELEASE SAVEPOINT game")
+		}
+
+		results := tx.SendBatch(ctx, pipeline)
+		done, err := pipelined(results, len(batch)-start, start, failures)
+		if closeErr := results.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			return nil
+		}
+
+		// Without a Postgres error nothing tells which game failed, e.g. the connection dropped
+		var pgErr *pgconn.PgError
+		if Retryable(err) || !errors.As(err, &pgErr) || start+done == len(batch) {
+			return err
+		}
+		if _, rollbackErr := tx.Exec(ctx, "ROLLBACK TO SAVEPOINT game"); rollbackErr != nil {
+			return rollbackErr
+		}
+		failures[start+done] = err
+		start += done + 1
+	}
+	return nil
+}
+
+// pipelined reads the results of insertGames' pipeline for the count games
+// from start on, adding the duplicates to failures. It returns the number
+// of games done before an error.
+func pipelined(results pgx.BatchResults, count int, start int, failures map[int]error) (int, error) {
+	for i := 0; i < count; i++ {
+		if _, err := results.Exec(); err != nil {
+			return i, err
+		}
+		tag, err := results.Exec()
+		if err != nil {
+			return i, err
+		}
+		if _, err := results.Exec(); err != nil {
+			return i, err
+		}
+
+		if tag.RowsAffected() == 0 {
+			failures[start+i] = sink.ErrDuplicate
+		}
+	}
+	return count, nil
+}
+
+// Database is the pool, or a transaction where Begin opens a savepoint
+type Database interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+// positionsChunk is the number of FENs per side table row, enough for rows to
+// be compressed and stored out of line by TOAST
+const positionsChunk = 100
+
+// Store inserts the game, or with update replaces the stored one with
+// table.Update, together with its positions when opts.Positions keeps them
+// out of the games table. It reports no error for a game already stored.
+func Store(ctx context.Context, db Database, opts sink.Options, table sink.Table, r sink.Record, update bool) error {
+	statement, args := table.Insert, r.Row
+	if update {
+		statement, args = table.Update, append(slices.Clip(r.Row), r.Game.Source, r.Game.SourceId)
+	}
+	if opts.Positions == "" {
+		_, err := db.Exec(ctx, statement, args...)
+		return err
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// The positions of a stored game are replaced, not added to
+	replace := update || opts.Upsert
+	game := r.Game
+
+	if opts.Positions == "large-object" {
+		if replace {
+			_, err := tx.Exec(ctx, fmt.Sprintf("SELECT lo_unlink(positions_oid) FROM %s WHERE source = $1 AND source_id = $2 AND positions_oid IS NOT NULL", table.Name),
+				game.Source, game.SourceId)
+			if err != nil {
+				return err
+			}
+		}
+
+		los := tx.LargeObjects()
+		oid, err := los.Create(ctx, 0)
+		if err != nil {
+			return err
+		}
+		object, err := los.Open(ctx, oid, pgx.LargeObjectModeWrite)
+		if err != nil {
+			return err
+		}
+		if _, err := object.Write([]byte(strings.Join(game.Positions, "\n"))); err != nil {
+			return err
+		}
+		if err := object.Close(); err != nil {
+			return err
+		}
+
+		// The row refers to the new object
+		args = slices.Clone(args)
+		if i := slices.IndexFunc(table.Columns, func(c sink.Column) bool { return c.Name == "positions_oid" }); i >= 0 {
+			args[i] = oid
+		}
+	}
+
+	tag, err := tx.Exec(ctx, statement, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil // already stored, the rollback drops the new large object
+	}
+
+	if opts.Positions == "table" {
+		positionsTable := pgx.Identifier{unquoted(table.Name) + "_positions"}.Sanitize()
+		if replace {
+			if _, err := tx.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE game_id = $1", positionsTable), game.GameId()); err != nil {
+				return err
+			}
+		}
+		for chunk, start := 0, 0; start < len(game.Positions); chunk, start = chunk+1, start+positionsChunk {
+			end := min(start+positionsChunk, len(game.Positions))
+			_, err := tx.Exec(ctx, fmt.Sprintf("INSERT INTO %s (game_id, chunk, fens) VALUES ($1, $2, $3)", positionsTable),
+				game.GameId(), chunk, strings.Join(game.Positions[start:end], "\n"))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if opts.Positions == "rows" {
+		if replace {
+			if _, err := tx.Exec(ctx, "DELETE FROM game_positions WHERE game_id = $1", game.GameId()); err != nil {
+				return err
+			}
+		}
+		rows := make([][]any, len(game.Positions))
+		zobrists := make([]*int64, len(game.Positions))
+		for i, fen := range game.Positions {
+			var zobrist any
+			if i < len(game.Zobrist) {
+				zobrist, zobrists[i] = game.Zobrist[i], &game.Zobrist[i]
+			}
+			rows[i] = []any{game.GameId(), i + 1, fen, zobrist}
+		}
+		var err error
+		if opts.Copy {
+			_, err = tx.CopyFrom(ctx, pgx.Identifier{"game_positions"}, []string{"game_id", "ply", "fen", "zobrist"}, pgx.CopyFromRows(rows))
+		} else {
+			_, err = tx.Exec(ctx, `INSERT INTO game_positions (game_id, ply, fen, zobrist)
+				SELECT $1, ply, fen, zobrist FROM unnest($2::text[], $3::int8[]) WITH ORDINALITY AS p(fen, zobrist, ply)`,
+				game.GameId(), game.Positions, zobrists)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// unquoted returns the name of a table quoted by the importer
+func unquoted(tableName string) string {
+	return strings.ReplaceAll(strings.Trim(tableName, "\""), "\"\"", "\"")
+}
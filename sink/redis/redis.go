@@ -0,0 +1,179 @@
+// Package redis caches the games of the MongoDB importer in Redis
+// (--backend=redis) for applications looking them up in well under a
+// millisecond: a hash per game and a sorted set per player of the ids of
+// their latest games. It sits next to the database (--backend=mongodb,redis)
+// rather than replacing it.
+package redis
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"importGames/env"
+	"importGames/fieldmap"
+	"importGames/jsondoc"
+	"importGames/sink"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type store struct {
+	url, prefix, ttlFlag string
+	recent               int // game ids kept per player
+
+	client *redis.Client
+	fields fieldmap.Map
+	ttl    time.Duration
+}
+
+func init() {
+	sink.Register("redis", &store{})
+}
+
+func (s *store) RegisterFlags() {
+	flag.StringVar(&s.url, "redis-url", env.String("REDIS_URL", "redis://127.0.0.1:6379/0"), "Redis server of --backend=redis")
+	flag.StringVar(&s.prefix, "redis-prefix", env.String("REDIS_PREFIX", "chess:"), "prefix of the Redis keys")
+	flag.IntVar(&s.recent, "redis-recent", env.Int("REDIS_RECENT", 100), "latest game ids kept per player in Redis")
+	flag.StringVar(&s.ttlFlag, "redis-ttl", env.String("REDIS_TTL", ""), "expire the Redis keys this long after their last write, e.g. 30d or 12h, empty to keep them")
+}
+
+func (s *store) Open(ctx context.Context, opts sink.Options) error {
+	if s.recent < 1 {
+		return fmt.Errorf("--redis-recent must be at least 1")
+	}
+	if s.ttlFlag != "" {
+		ttl, err := env.ParseDuration(s.ttlFlag)
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf("invalid Redis TTL %q", s.ttlFlag)
+		}
+		s.ttl = ttl
+	}
+	options, err := redis.ParseURL(s.url)
+	if err != nil {
+		return err
+	}
+	client := redis.NewClient(options)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return err
+	}
+	s.client, s.fields = client, opts.Fields
+	return nil
+}
+
+// EnsureSchema has nothing to create, keys appear with their first game
+func (s *store) EnsureSchema(ctx context.Context, table sink.Table) error {
+	return nil
+}
+
+// key joins the parts after --redis-prefix
+func (s *store) key(parts ...string) string {
+	return s.prefix + strings.Join(parts, ":")
+}
+
+// date is the date of a game as in PGN, with ?? for the parts not known
+func date(game *sink.Game) string {
+	part := func(n *int, width int) string {
+		if n == nil {
+			return strings.Repeat("?", width)
+		}
+		return fmt.Sprintf("%0*d", width, *n)
+	}
+	return part(game.Year, 4) + "." + part(game.Month, 2) + "." + part(game.Day, 2)
+}
+
+// hash is the hash of a game: the document as JSON, and the fields lists
+// show (as renamed by --field-map) to read without parsing it
+func hash(fields fieldmap.Map, game *sink.Game, doc []byte) map[string]any {
+	h := map[string]any{
+		"json":                      doc,
+		fields.Name("white"):        game.White,
+		fields.Name("black"):        game.Black,
+		fields.Name("result"):       game.Result,
+		fields.Name("eco"):          game.Eco,
+		fields.Name("opening"):      game.Opening,
+		fields.Name("variant"):      game.Variant,
+		fields.Name("time_control"): game.TimeControl,
+		fields.Name("termination"):  game.Termination,
+		fields.Name("date"):         date(game),
+		fields.Name("plyCount"):     game.PlyCount,
+		fields.Name("moves"):        strings.Join(game.Moves, " "),
+	}
+	if game.WhiteElo != nil {
+		h[fields.Name("whiteElo")] = *game.WhiteElo
+	}
+	if game.BlackElo != nil {
+		h[fields.Name("blackElo")] = *game.BlackElo
+	}
+	if game.PlayedAt != nil {
+		h[fields.Name("playedAt")] = game.PlayedAt.UTC().Format(time.RFC3339)
+	}
+	return h
+}
+
+// WriteBatch sends the batch in one pipeline. A game written again replaces
+// its hash, so importing again refreshes the cache. Player sets are scored
+// by playedAt (games without one come first, so they go first too) and cut
+// to --redis-recent.
+func (s *store) WriteBatch(ctx context.Context, batch []sink.Record) (map[int]error, error) {
+	failed := make(map[int]error)
+	cmds := make(map[int][]redis.Cmder) // of every game
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		players := make(map[string]bool)
+		for i, r := range batch {
+			doc, err := jsondoc.Marshal(r.Doc)
+			if err != nil {
+				failed[i] = err
+				continue
+			}
+			game := r.Game
+			key := s.key("game", game.GameId())
+			cmds[i] = append(cmds[i], pipe.HSet(ctx, key, hash(s.fields, game, doc)))
+			if s.ttl > 0 {
+				cmds[i] = append(cmds[i], pipe.Expire(ctx, key, s.ttl))
+			}
+
+			var score float64
+			if game.PlayedAt != nil {
+				score = float64(game.PlayedAt.Unix())
+			}
+			for _, name := range []string{game.White, game.Black} {
+				if name == "" || name == "?" {
+					continue
+				}
+				// Names are case-insensitive on Lichess and Chess.com
+				player := s.key("player", game.Source, strings.ToLower(name))
+				cmds[i] = append(cmds[i], pipe.ZAdd(ctx, player, redis.Z{Score: score, Member: game.GameId()}))
+				players[player] = true
+			}
+		}
+		// Failures trimming are left alone, the sets are cut by the next batch
+		for player := range players {
+			pipe.ZRemRangeByRank(ctx, player, 0, int64(-s.recent-1))
+			if s.ttl > 0 {
+				pipe.Expire(ctx, player, s.ttl)
+			}
+		}
+		return nil
+	})
+
+	for i, gameCmds := range cmds {
+		for _, cmd := range gameCmds {
+			if cmd.Err() != nil {
+				failed[i] = cmd.Err()
+				break
+			}
+		}
+	}
+	if err != nil && len(failed) == 0 {
+		return nil, err
+	}
+	return failed, nil
+}
+
+func (s *store) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,46 @@
+package redis
+
+import (
+	"testing"
+
+	"importGames/fieldmap"
+	"importGames/sink"
+)
+
+func TestDate(t *testing.T) {
+	year, month, day := 2024, 1, 5
+
+	tests := []struct {
+		name string
+		game sink.Game
+		want string
+	}{
+		{"complete", sink.Game{Year: &year, Month: &month, Day: &day}, "2024.01.05"},
+		{"month", sink.Game{Year: &year, Month: &month}, "2024.01.??"},
+		{"unknown", sink.Game{}, "????.??.??"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := date(&tt.game); got != tt.want {
+				t.Errorf("date = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHash(t *testing.T) {
+	elo := 2850
+	game := &sink.Game{White: "DrNykterstein", WhiteElo: &elo, Moves: []string{"e4", "e5"}}
+	h := hash(fieldmap.Map{"whiteElo": "white_elo"}, game, []byte(`{}`))
+
+	if h["white_elo"] != 2850 {
+		t.Errorf("white_elo = %v, want 2850", h["white_elo"])
+	}
+	if _, ok := h["blackElo"]; ok {
+		t.Errorf("blackElo set for an unrated player")
+	}
+	if h["moves"] != "e4 e5" {
+		t.Errorf("moves = %q, want %q", h["moves"], "e4 e5")
+	}
+}
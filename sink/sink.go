@@ -0,0 +1,200 @@
+// Package sink is where the importers send games: the destinations of
+// --backend, their own database included, all written from one read of the
+// files. Every destination is a package registering itself from init, so a
+// destination of your own needs no change to the importers: import its
+// package from a file run along with the importer.
+package sink
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"importGames/fieldmap"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrDuplicate is the failure of a game the destination already has. The
+// importers report it as a skipped duplicate rather than an error.
+var ErrDuplicate = errors.New("duplicate game")
+
+// Game is what every destination gets of a game, whichever importer read it
+type Game struct {
+	Source       string // lichess, chesscom or hash
+	SourceId     string // game ID within the source, unique with source
+	MovesHash    string // fingerprint of the main line
+	ContentHash  string // players, date and moves, see --dedupe-content
+	ImportId     string // run that read the game
+	SourceFile   string // file the game was read from
+	SourceOffset *int64 // byte offset of the game in the (decompressed) file, when known
+
+	Event        string
+	TournamentId string     // Lichess arena or swiss ID from the Event tag
+	EventDate    *time.Time // complete EventDate tags only
+	Site         string
+	Round        string
+
+	White           string
+	Black           string
+	WhiteElo        *int // nil when unrated
+	BlackElo        *int
+	WhiteTitle      string
+	BlackTitle      string
+	WhiteRatingDiff *int
+	BlackRatingDiff *int
+
+	Result      string // 1-0, 0-1, 1/2-1/2 or *
+	Eco         string // as tagged, "?" or made up codes included
+	Opening     string
+	TimeControl string
+	Termination string
+	Variant     string
+
+	Year, Month, Day *int       // the parts of the date known
+	Date             *time.Time // nil unless year, month and day are known
+	Time             string     // 15:04:05, "" when unknown
+	PlayedAt         *time.Time // date and time, partial dates at the start of the period
+
+	Moves      []string   // SAN
+	UciMoves   []string   // standard games only
+	Evals      []*float32 // per ply in pawns, nil for moves without %eval
+	Clocks     []*int32   // per ply in centiseconds left, nil for moves without %clk
+	Analyzed   bool       // a move has an eval
+	Positions  []string   // FEN after every move, when kept
+	Zobrist    []int64    // hash of the position after every move, standard games only
+	MovesCount int        // full moves
+	PlyCount   int
+
+	FinalFen     string
+	MaxImbalance int               // largest material difference in pawns, negative when black was ahead
+	Features     []float64         // with --features, see features.Names
+	Tags         map[string]string // tags and values kept besides the fields
+}
+
+// GameId is source:sourceId, unique among the games of every source
+func (g *Game) GameId() string {
+	return g.Source + ":" + g.SourceId
+}
+
+// Record is a game to write
+type Record struct {
+	Table string // table or collection the importer writes it to
+	File  string // file the importer read it from, with Index for its report
+	Index int
+	Game  *Game
+
+	// MongoDB importer: the document MongoDB stores (--field-map applied),
+	// for JSON with jsondoc.Marshal, and the fields upserts match it on
+	Doc any
+	Key map[string]any
+
+	// Postgres importer: the values of the table's Columns
+	Row []any
+}
+
+// Table is a table (a collection for MongoDB) receiving games
+type Table struct {
+	Name    string   // as written in SQL, quoted when needed
+	Columns []Column // of Record.Row, Postgres importer only
+
+	// The Postgres importer's SQL, for the destinations speaking it
+	Create  string   // creates the table, its unique indexes and side tables
+	Insert  string   // inserts a Row ($1, ...), skipping or updating a game already there
+	Update  string   // replaces the Row of the game of source $n+1 and source_id $n+2
+	Indexes []string // query indexes of --ensure-indexes, built at the end
+}
+
+// Column is a column of the Postgres importer's games tables
+type Column struct {
+	Name    string
+	Type    string // Postgres type with constraints: "TEXT UNIQUE", "INTEGER[]", ...
+	Mutable bool   // changes when a dump is republished, updated by upserts
+}
+
+// Options are what the importer shares with every destination
+type Options struct {
+	ImportId      string
+	Workers       int  // goroutines calling WriteBatch at once
+	BatchSize     int  // most games of a WriteBatch
+	Upsert        bool // games already there are replaced rather than skipped
+	DedupeContent bool // games with the same ContentHash are copies of one game
+
+	// Lost reports games a destination lost after WriteBatch took them,
+	// e.g. staged in a file that failed to upload
+	Lost func(batch []Record, err error)
+
+	// MongoDB importer
+	Mongo  *mongo.Database
+	Fields fieldmap.Map // how documents name their fields
+
+	// Postgres importer
+	Pool         *pgxpool.Pool // nil without a connection
+	Copy         bool          // the database takes COPY, see --dialect
+	Positions    string        // table, large-object or rows when positions are kept out of the games table
+	Notify       string        // channel notified of the games of every batch
+	Columns      []string      // --columns in the order given, the columns of a file
+	ExportDir    string
+	ExportFormat string // csv or tsv
+}
+
+// Sink is a destination. The importer calls Open, EnsureSchema before the
+// first write to every table, WriteBatch from several goroutines at once
+// (the games of a batch share their table) and Close at the end.
+type Sink interface {
+	Open(ctx context.Context, opts Options) error
+	EnsureSchema(ctx context.Context, table Table) error
+	// WriteBatch returns the failures of the games that failed, by index
+	// in the batch, or an error failing them all
+	WriteBatch(ctx context.Context, batch []Record) (map[int]error, error)
+	Close() error
+}
+
+// Partitioner is a destination with partitioned tables, asked for the
+// partition of every new month or source before its first game. The
+// other destinations get the games of the parent table.
+type Partitioner interface {
+	EnsurePartition(ctx context.Context, statement string) error
+}
+
+// Configurable is a destination with flags of its own. The importers call
+// RegisterFlags once .env is loaded, before parsing their flags, and Open
+// checks them.
+type Configurable interface {
+	RegisterFlags()
+}
+
+var sinks = map[string]Sink{}
+
+// Register makes a destination available to --backend. Call it from an
+// init function.
+func Register(name string, s Sink) {
+	sinks[name] = s
+}
+
+// Lookup returns the registered destination of that name
+func Lookup(name string) (Sink, bool) {
+	s, ok := sinks[name]
+	return s, ok
+}
+
+// Names lists the registered destinations
+func Names() []string {
+	names := make([]string, 0, len(sinks))
+	for name := range sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RegisterFlags defines the flags of the registered destinations
+func RegisterFlags() {
+	for _, name := range Names() {
+		if c, ok := sinks[name].(Configurable); ok {
+			c.RegisterFlags()
+		}
+	}
+}
@@ -0,0 +1,265 @@
+// Package sqlite writes the games tables of the Postgres importer into a
+// single SQLite file (--backend=sqlite), so no server is needed to browse
+// one's games. Arrays and JSONB are stored as JSON text, dates and times in
+// ISO 8601.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"importGames/eco"
+	"importGames/env"
+	"importGames/pgcopy"
+	"importGames/sink"
+
+	_ "modernc.org/sqlite"
+)
+
+type store struct {
+	path string
+
+	db            *sql.DB
+	upsert        bool
+	dedupeContent bool
+
+	mu     sync.Mutex
+	tables map[string]sink.Table
+	order  []string // tables in the order created
+}
+
+func init() {
+	sink.Register("sqlite", &store{})
+}
+
+func (s *store) RegisterFlags() {
+	flag.StringVar(&s.path, "sqlite-file", env.String("SQLITE_FILE", "games.db"), "database file of --backend=sqlite, created if needed")
+}
+
+func (s *store) Open(ctx context.Context, opts sink.Options) error {
+	// WAL lets readers browse the file during the import. SQLite has one
+	// writer at a time anyway, so one connection serializes the batches.
+	db, err := sql.Open("sqlite", "file:"+s.path+"?_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_pragma=busy_timeout(10000)")
+	if err != nil {
+		return err
+	}
+	db.SetMaxOpenConns(1)
+
+	s.db, s.upsert, s.dedupeContent, s.tables = db, opts.Upsert, opts.DedupeContent, make(map[string]sink.Table)
+	if err := s.loadEcoCodes(); err != nil {
+		db.Close()
+		return err
+	}
+	return nil
+}
+
+// loadEcoCodes fills eco_codes, to join the names of the eco column
+func (s *store) loadEcoCodes() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("CREATE TABLE IF NOT EXISTS eco_codes (code TEXT PRIMARY KEY, name TEXT NOT NULL)"); err != nil {
+		return err
+	}
+	for _, c := range eco.Codes() {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO eco_codes (code, name) VALUES (?, ?)", c.Code, c.Name); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// sqliteType maps the Postgres type of a column to SQLite
+func sqliteType(ddl string) string {
+	switch {
+	case strings.Contains(ddl, "[]"), strings.HasPrefix(ddl, "JSONB"):
+		return "TEXT" // JSON, read with json_each and ->>
+	case strings.HasPrefix(ddl, "BYTEA"):
+		return "BLOB"
+	case strings.HasPrefix(ddl, "INTEGER"), strings.HasPrefix(ddl, "SMALLINT"), strings.HasPrefix(ddl, "BIGINT"),
+		strings.HasPrefix(ddl, "BOOLEAN"), strings.HasPrefix(ddl, "OID"):
+		return "INTEGER"
+	case strings.HasPrefix(ddl, "REAL"), strings.HasPrefix(ddl, "DOUBLE PRECISION"):
+		return "REAL"
+	}
+	return "TEXT" // text, dates and times
+}
+
+// sqliteValue converts a column value to what SQLite stores
+func sqliteValue(v any, ddl string) any {
+	value := reflect.ValueOf(v)
+	if v == nil || (value.Kind() == reflect.Pointer || value.Kind() == reflect.Slice) && value.IsNil() {
+		return nil
+	}
+	switch {
+	case strings.Contains(ddl, "[]"):
+		array, _ := json.Marshal(v)
+		return string(array)
+	case strings.HasPrefix(ddl, "JSONB"):
+		return string(v.([]byte))
+	case strings.HasPrefix(ddl, "DATE"), strings.HasPrefix(ddl, "TIME"):
+		text, _ := pgcopy.Value(v, ddl)
+		return text
+	}
+	return v
+}
+
+// EnsureSchema creates the games table, and adds the columns selected since
+func (s *store) EnsureSchema(ctx context.Context, table sink.Table) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tables[table.Name]; ok {
+		return nil
+	}
+
+	definitions := []string{"id INTEGER PRIMARY KEY"}
+	for _, c := range table.Columns {
+		definition := c.Name + " " + sqliteType(c.Type)
+		if strings.HasSuffix(c.Type, " UNIQUE") {
+			definition += " UNIQUE"
+		}
+		definitions = append(definitions, definition)
+	}
+	definitions = append(definitions, "created_at TEXT DEFAULT CURRENT_TIMESTAMP", "updated_at TEXT DEFAULT CURRENT_TIMESTAMP")
+	statements := []string{fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n\t%s\n)", table.Name, strings.Join(definitions, ",\n\t"))}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT name FROM pragma_table_info(?)", unquoted(table.Name))
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, c := range table.Columns {
+		// ADD COLUMN can't add constraints, the new lichess_id isn't unique
+		if len(existing) > 0 && !existing[c.Name] {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table.Name, c.Name, sqliteType(c.Type)))
+		}
+	}
+
+	statements = append(statements, fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (source, source_id)", suffixedName(table.Name, "source_id"), table.Name))
+	if s.dedupeContent {
+		statements = append(statements, fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (content_hash)", suffixedName(table.Name, "content_hash"), table.Name))
+	}
+	for _, statement := range statements {
+		if _, err := s.db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	s.tables[table.Name] = table
+	s.order = append(s.order, table.Name)
+	return nil
+}
+
+// insertSQL inserts one game like the Postgres importer's insert, with
+// SQLite placeholders and timestamps
+func (s *store) insertSQL(table sink.Table) string {
+	names := make([]string, len(table.Columns))
+	var assignments []string
+	for i, c := range table.Columns {
+		names[i] = c.Name
+		if c.Mutable {
+			assignments = append(assignments, fmt.Sprintf("%s = excluded.%s", c.Name, c.Name))
+		}
+	}
+	onConflict := "ON CONFLICT DO NOTHING"
+	if s.upsert {
+		assignments = append(assignments, "updated_at = CURRENT_TIMESTAMP")
+		onConflict = "ON CONFLICT (source, source_id) DO UPDATE SET " + strings.Join(assignments, ", ")
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) %s", table.Name, strings.Join(names, ", "), placeholders, onConflict)
+}
+
+// WriteBatch inserts the games in one transaction. A failing statement
+// doesn't abort a SQLite transaction, so failing games are left out alone.
+func (s *store) WriteBatch(ctx context.Context, batch []sink.Record) (map[int]error, error) {
+	s.mu.Lock()
+	table := s.tables[batch[0].Table]
+	s.mu.Unlock()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	insert, err := tx.Prepare(s.insertSQL(table))
+	if err != nil {
+		return nil, err
+	}
+	defer insert.Close()
+
+	failures := make(map[int]error)
+	args := make([]any, len(table.Columns))
+	for i, r := range batch {
+		for j, c := range table.Columns {
+			args[j] = sqliteValue(r.Row[j], c.Type)
+		}
+		result, err := insert.Exec(args...)
+		if err != nil {
+			failures[i] = err
+			continue
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			failures[i] = sink.ErrDuplicate
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return failures, nil
+}
+
+// Close builds the btree indexes of the tables (SQLite has no GIN or
+// trigram indexes), then folds the WAL into the database file
+func (s *store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for _, name := range s.order {
+		for _, statement := range s.tables[name].Indexes {
+			if strings.Contains(statement, " USING ") || strings.HasPrefix(statement, "CREATE EXTENSION") {
+				continue
+			}
+			_, err := s.db.Exec(statement)
+			errs = append(errs, err)
+		}
+	}
+	_, err := s.db.Exec("PRAGMA wal_checkpoint(TRUNCATE)")
+	errs = append(errs, err, s.db.Close())
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	fmt.Printf("Stored %d tables in %s\n", len(s.order), s.path)
+	return nil
+}
+
+// suffixedName returns the quoted name of an index of a quoted table
+func suffixedName(tableName string, suffix string) string {
+	return `"` + strings.ReplaceAll(unquoted(tableName)+"_"+suffix, `"`, `""`) + `"`
+}
+
+// unquoted returns the name of a table quoted by the importer
+func unquoted(tableName string) string {
+	return strings.ReplaceAll(strings.Trim(tableName, "\""), "\"\"", "\"")
+}
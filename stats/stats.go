@@ -0,0 +1,185 @@
+// Package stats keeps running aggregates of the imported games
+// (per speed, per ECO family and an Elo histogram) while workers insert them
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// EloBucket is the width of the Elo histogram buckets
+const EloBucket = 100
+
+const shardCount = 16
+
+// Aggregates is safe for concurrent use. Counters are sharded so that
+// workers don't all contend on a single lock.
+type Aggregates struct {
+	next   atomic.Uint32
+	shards [shardCount]shard
+}
+
+type shard struct {
+	mu     sync.Mutex
+	counts Snapshot
+}
+
+// Snapshot is the merged state of all shards
+type Snapshot struct {
+	Games        int            `json:"games"`
+	Speeds       map[string]int `json:"speeds"`
+	EcoFamilies  map[string]int `json:"eco_families"`
+	EloHistogram map[int]int    `json:"elo_histogram"` // bucket start -> rated players
+
+	// Only from the stats command, which reads the stored games
+	Ecos         map[string]int `json:"ecos,omitempty"`
+	TimeControls map[string]int `json:"time_controls,omitempty"`
+	Results      map[string]int `json:"results,omitempty"`
+	Duplicates   int            `json:"duplicates,omitempty"` // games whose content is stored more than once, copies only
+}
+
+// NewSnapshot returns an empty snapshot
+func NewSnapshot() Snapshot {
+	return Snapshot{
+		Speeds:       make(map[string]int),
+		EcoFamilies:  make(map[string]int),
+		EloHistogram: make(map[int]int),
+	}
+}
+
+func New() *Aggregates {
+	a := &Aggregates{}
+	for i := range a.shards {
+		a.shards[i].counts = NewSnapshot()
+	}
+	return a
+}
+
+// Add counts one stored game, ratings of 0 are treated as missing
+func (a *Aggregates) Add(speed string, eco string, whiteElo int, blackElo int) {
+	s := &a.shards[a.next.Add(1)%shardCount]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.counts.Games++
+	if speed == "" {
+		speed = "unknown"
+	}
+	s.counts.Speeds[speed]++
+
+	family := "?"
+	if eco != "" {
+		family = strings.ToUpper(eco[:1])
+	}
+	s.counts.EcoFamilies[family]++
+
+	for _, elo := range []int{whiteElo, blackElo} {
+		if elo > 0 {
+			s.counts.EloHistogram[elo/EloBucket*EloBucket]++
+		}
+	}
+}
+
+// Snapshot merges the shards
+func (a *Aggregates) Snapshot() Snapshot {
+	total := NewSnapshot()
+	for i := range a.shards {
+		s := &a.shards[i]
+		s.mu.Lock()
+		total.Games += s.counts.Games
+		for k, v := range s.counts.Speeds {
+			total.Speeds[k] += v
+		}
+		for k, v := range s.counts.EcoFamilies {
+			total.EcoFamilies[k] += v
+		}
+		for k, v := range s.counts.EloHistogram {
+			total.EloHistogram[k] += v
+		}
+		s.mu.Unlock()
+	}
+	return total
+}
+
+// String is the summary printed at the end of an import
+func (s Snapshot) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Games: %d\n", s.Games)
+	fmt.Fprintf(&b, "Per speed: %s\n", formatCounts(s.Speeds))
+	fmt.Fprintf(&b, "Per ECO family: %s\n", formatCounts(s.EcoFamilies))
+	if len(s.Ecos) > 0 {
+		fmt.Fprintf(&b, "Top ECO codes: %s\n", formatTop(s.Ecos, 20))
+	}
+	if len(s.TimeControls) > 0 {
+		fmt.Fprintf(&b, "Top time controls: %s\n", formatTop(s.TimeControls, 20))
+	}
+	if len(s.Results) > 0 {
+		fmt.Fprintf(&b, "Results: %s\n", formatCounts(s.Results))
+	}
+	if s.Duplicates > 0 {
+		fmt.Fprintf(&b, "Duplicates: %d\n", s.Duplicates)
+	}
+
+	buckets := make([]int, 0, len(s.EloHistogram))
+	for bucket := range s.EloHistogram {
+		buckets = append(buckets, bucket)
+	}
+	sort.Ints(buckets)
+	b.WriteString("Elo histogram:\n")
+	for _, bucket := range buckets {
+		fmt.Fprintf(&b, "  %4d-%4d: %d\n", bucket, bucket+EloBucket-1, s.EloHistogram[bucket])
+	}
+
+	return b.String()
+}
+
+// WriteFile saves the snapshot as JSON
+func (s Snapshot) WriteFile(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// formatTop lists the n largest counts, largest first
+func formatTop(counts map[string]int, n int) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s %d", k, counts[k])
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s %d", k, counts[k])
+	}
+	return strings.Join(parts, ", ")
+}
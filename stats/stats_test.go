@@ -0,0 +1,85 @@
+package stats
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	type game struct {
+		speed              string
+		eco                string
+		whiteElo, blackElo int
+	}
+
+	tests := []struct {
+		name      string
+		games     []game
+		speeds    map[string]int
+		families  map[string]int
+		histogram map[int]int
+	}{
+		{
+			name:      "no games",
+			speeds:    map[string]int{},
+			families:  map[string]int{},
+			histogram: map[int]int{},
+		},
+		{
+			name: "merged across shards",
+			games: []game{
+				{"blitz", "B20", 1510, 1590},
+				{"blitz", "b01", 1620, 1500},
+				{"rapid", "C50", 2050, 1999},
+			},
+			speeds:    map[string]int{"blitz": 2, "rapid": 1},
+			families:  map[string]int{"B": 2, "C": 1},
+			histogram: map[int]int{1500: 3, 1600: 1, 1900: 1, 2000: 1},
+		},
+		{
+			name: "missing speed, ECO and ratings",
+			games: []game{
+				{"", "", 0, 1450},
+				{"", "A00", 0, 0},
+			},
+			speeds:    map[string]int{"unknown": 2},
+			families:  map[string]int{"?": 1, "A": 1},
+			histogram: map[int]int{1400: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := New()
+			// More games than shards, so that every shard is merged
+			for i := 0; i < shardCount; i++ {
+				for _, g := range tt.games {
+					a.Add(g.speed, g.eco, g.whiteElo, g.blackElo)
+				}
+			}
+
+			snapshot := a.Snapshot()
+			if want := len(tt.games) * shardCount; snapshot.Games != want {
+				t.Errorf("Games = %d, want %d", snapshot.Games, want)
+			}
+			if want := scaled(tt.speeds); !maps.Equal(snapshot.Speeds, want) {
+				t.Errorf("Speeds = %v, want %v", snapshot.Speeds, want)
+			}
+			if want := scaled(tt.families); !maps.Equal(snapshot.EcoFamilies, want) {
+				t.Errorf("EcoFamilies = %v, want %v", snapshot.EcoFamilies, want)
+			}
+			if want := scaled(tt.histogram); !maps.Equal(snapshot.EloHistogram, want) {
+				t.Errorf("EloHistogram = %v, want %v", snapshot.EloHistogram, want)
+			}
+		})
+	}
+}
+
+// scaled multiplies the counts of one round of games by the rounds added
+func scaled[K comparable](counts map[K]int) map[K]int {
+	out := make(map[K]int, len(counts))
+	for k, v := range counts {
+		out[k] = v * shardCount
+	}
+	return out
+}
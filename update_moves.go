@@ -2,16 +2,24 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"os"
+
+	"importGames/mongoconn"
+	"importGames/pgnparse"
+	"importGames/replay"
+
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"os"
-	"regexp"
-	"strings"
 )
 
+// Documents written per bulk update
+const updateBatchSize = 500
+
 func main() {
 	fmt.Println("Start Parsing")
 
@@ -19,12 +27,21 @@ func main() {
 		fmt.Println("No .env file found")
 	}
 
+	// Same connection flags as the importer
+	var conn mongoconn.Config
+	conn.RegisterFlags()
+	flag.Parse()
+	if err := conn.Parse(); err != nil {
+		fmt.Println("Invalid config:", err)
+		return
+	}
+
 	mongoUri := os.Getenv("MONGODB_URI")
 	mongoDatabase := os.Getenv("MONGODB_DATABASE")
 	mongoCollection := os.Getenv("MONGODB_COLLECTION")
 
 	// MongoDB Client
-	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(mongoUri))
+	client, err := mongo.Connect(context.Background(), conn.ClientOptions(mongoUri))
 	if err != nil {
 		fmt.Println("Failed to connect to MongoDB:", err)
 		return
@@ -34,43 +51,87 @@ func main() {
 	// Collection
 	collection := client.Database(mongoDatabase).Collection(mongoCollection)
 
-	// Get current move from mongodb
-	existingMoves, err := getExistingMoves(collection)
+	updated, err := updateMoves(collection)
 	if err != nil {
-		fmt.Println("Failed to get existing moves:", err)
+		fmt.Println("Failed to update documents in MongoDB:", err)
 		return
 	}
 
-	// Use our filter
-	cleanedExistingMoves := cleanMoves(existingMoves)
+	fmt.Printf("Documents updated successfully: %d\n", updated)
+}
+
+// Only documents imported before moves became an array of move objects
+var legacyMoves = bson.M{"moves": bson.M{"$type": "string"}}
 
-	// Create updating for Mongo
-	update := bson.D{{"$set", bson.D{{"moves", cleanedExistingMoves}}}}
+// legacyGame is the part of an old document needed to rewrite its moves
+type legacyGame struct {
+	ID      primitive.ObjectID `bson:"_id"`
+	Moves   string             `bson:"moves"`
+	Variant string             `bson:"variant"`
+}
 
-	// Confirm to collection
-	_, err = collection.UpdateMany(context.Background(), bson.D{}, update)
+// updateMoves rewrites the moves string of every legacy document into its
+// own move objects and returns the number of documents updated
+func updateMoves(collection *mongo.Collection) (int, error) {
+	ctx := context.Background()
+
+	projection := options.Find().SetProjection(bson.M{"moves": 1, "variant": 1})
+	cursor, err := collection.Find(ctx, legacyMoves, projection)
 	if err != nil {
-		fmt.Println("Failed to update documents in MongoDB:", err)
-		return
+		return 0, err
 	}
+	defer cursor.Close(ctx)
 
-	fmt.Println("Documents updated successfully.")
-}
+	var updated int
+	var models []mongo.WriteModel
+	flush := func() error {
+		if len(models) == 0 {
+			return nil
+		}
+		result, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if result != nil {
+			updated += int(result.ModifiedCount)
+		}
+		models = models[:0]
+		return err
+	}
+
+	for cursor.Next(ctx) {
+		var game legacyGame
+		if err := cursor.Decode(&game); err != nil {
+			return updated, err
+		}
 
-func getExistingMoves(collection *mongo.Collection) (string, error) {
-	// Get one document from collection
-	var result struct {
-		Moves string `bson:"moves"`
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": game.ID, "moves": bson.M{"$type": "string"}}).
+			SetUpdate(bson.M{"$set": bson.M{"moves": moveDetails(game)}}))
+		if len(models) == updateBatchSize {
+			if err := flush(); err != nil {
+				return updated, err
+			}
+		}
 	}
-	err := collection.FindOne(context.Background(), bson.D{}).Decode(&result)
-	if err != nil {
-		return "", err
+	if err := cursor.Err(); err != nil {
+		return updated, err
 	}
-	return result.Moves, nil
+
+	return updated, flush()
 }
 
-// Clear Moves from Numbers & Notations
-func cleanMoves(moves string) string {
-	moves = regexp.MustCompile(`\{[^}]*\}|\b\d+\.|\d+-\d+|\.`).ReplaceAllString(moves, "")
-	return strings.Join(strings.Fields(moves), " ")
+// moveDetails parses the old moves string (SAN, with or without move numbers
+// and comments) into the structured moves stored by the importer
+func moveDetails(game legacyGame) []pgnparse.MoveDetail {
+	moves := pgnparse.Moves(game.Moves)
+
+	// Other variants can't be replayed with standard rules
+	var ucis []string
+	if pgnparse.IsStandardVariant(game.Variant) {
+		played, err := replay.Play(pgnparse.SANs(moves))
+		if err != nil {
+			fmt.Printf("Failed to replay game %s: %s\n", game.ID.Hex(), err)
+		}
+		ucis = replay.UCI(played)
+	}
+
+	return pgnparse.Details(moves, ucis)
 }